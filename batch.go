@@ -0,0 +1,122 @@
+/*
+ * Copyright 2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vcert
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+)
+
+// BatchOptions controls how EnrollBatch drives the connector
+type BatchOptions struct {
+	// Concurrency is the maximum number of requests that are submitted or picked up at the same time.
+	// Values below 1 mean one request at a time.
+	Concurrency int
+	// PickupTimeout is copied to certificate.Request.Timeout of every request that has none set,
+	// so that connectors keep polling while issuance is pending.
+	PickupTimeout time.Duration
+	// NoPickup stops EnrollBatch after the requests are submitted, only pickup IDs are returned
+	NoPickup bool
+}
+
+// BatchResult is the outcome of enrolling a single request of a batch
+type BatchResult struct {
+	Request      *certificate.Request
+	PickupID     string
+	Certificates *certificate.PEMCollection
+	Err          error
+}
+
+// EnrollBatch enrolls all requests through one authenticated connector. The zone configuration is read once,
+// requests are submitted with bounded concurrency and the issued certificates are then picked up in parallel.
+// The returned slice is index-aligned with requests; a failure of one entry does not stop the others.
+// For locally generated CSRs the private key is added to the certificate collection, encrypted with
+// certificate.Request.KeyPassword when one is set.
+func EnrollBatch(conn endpoint.Connector, requests []*certificate.Request, opts BatchOptions) []BatchResult {
+	results := make([]BatchResult, len(requests))
+	for i, req := range requests {
+		results[i].Request = req
+	}
+
+	zoneConfig, err := conn.ReadZoneConfiguration()
+	if err != nil {
+		for i := range results {
+			results[i].Err = fmt.Errorf("failed to read zone configuration: %w", err)
+		}
+		return results
+	}
+
+	runBatch(results, opts.Concurrency, func(r *BatchResult) {
+		r.Err = conn.GenerateRequest(zoneConfig, r.Request)
+		if r.Err != nil {
+			return
+		}
+		r.PickupID, r.Err = conn.RequestCertificate(r.Request)
+	})
+
+	if opts.NoPickup {
+		return results
+	}
+
+	runBatch(results, opts.Concurrency, func(r *BatchResult) {
+		if r.Err != nil {
+			return
+		}
+		req := r.Request
+		req.PickupID = r.PickupID
+		if req.Timeout == 0 {
+			req.Timeout = opts.PickupTimeout
+		}
+		r.Certificates, r.Err = conn.RetrieveCertificate(req)
+		if r.Err != nil {
+			return
+		}
+		if r.Certificates == nil {
+			r.Err = fmt.Errorf("certificate is not returned by remote, while error is nil")
+			return
+		}
+		if req.CsrOrigin == certificate.LocalGeneratedCSR && req.PrivateKey != nil {
+			r.Err = r.Certificates.AddPrivateKey(req.PrivateKey, []byte(req.KeyPassword))
+		}
+	})
+
+	return results
+}
+
+func runBatch(results []BatchResult, concurrency int, f func(r *BatchResult)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *BatchResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			f(r)
+		}(&results[i])
+	}
+	wg.Wait()
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vcert
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+)
+
+func TestEnrollBatch(t *testing.T) {
+	conn, err := NewClient(&Config{ConnectorType: endpoint.ConnectorTypeFake})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"one.example.com", "two.example.com", "forbidden.venafi.com", "three.example.com"}
+	requests := make([]*certificate.Request, len(names))
+	for i, cn := range names {
+		requests[i] = &certificate.Request{
+			Subject:   pkix.Name{CommonName: cn},
+			DNSNames:  []string{cn},
+			CsrOrigin: certificate.LocalGeneratedCSR,
+		}
+	}
+	requests[1].KeyPassword = "newPassw0rd!"
+
+	results := EnrollBatch(conn, requests, BatchOptions{Concurrency: 2, PickupTimeout: time.Minute})
+	if len(results) != len(requests) {
+		t.Fatalf("expected %d results, got %d", len(requests), len(results))
+	}
+	for i, r := range results {
+		if r.Request != requests[i] {
+			t.Fatalf("result %d is not aligned with its request", i)
+		}
+		if names[i] == "forbidden.venafi.com" {
+			if r.Err == nil {
+				t.Fatalf("enrollment of %s should fail", names[i])
+			}
+			continue
+		}
+		if r.Err != nil {
+			t.Fatalf("enrollment of %s failed: %s", names[i], r.Err)
+		}
+		if r.PickupID == "" {
+			t.Fatalf("pickup ID of %s is empty", names[i])
+		}
+		block, _ := pem.Decode([]byte(r.Certificates.Certificate))
+		if block == nil {
+			t.Fatalf("certificate of %s is not PEM", names[i])
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert.Subject.CommonName != names[i] {
+			t.Fatalf("expected CN %s, got %s", names[i], cert.Subject.CommonName)
+		}
+		keyBlock, _ := pem.Decode([]byte(r.Certificates.PrivateKey))
+		if keyBlock == nil {
+			t.Fatalf("private key of %s is missing", names[i])
+		}
+		//nolint:staticcheck
+		if encrypted := x509.IsEncryptedPEMBlock(keyBlock); encrypted != (requests[i].KeyPassword != "") {
+			t.Fatalf("unexpected private key encryption for %s: %v", names[i], encrypted)
+		}
+	}
+}
+
+func TestEnrollBatchNoPickup(t *testing.T) {
+	conn, err := NewClient(&Config{ConnectorType: endpoint.ConnectorTypeFake})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := []*certificate.Request{
+		{Subject: pkix.Name{CommonName: "one.example.com"}, CsrOrigin: certificate.LocalGeneratedCSR},
+		{Subject: pkix.Name{CommonName: "two.example.com"}, CsrOrigin: certificate.LocalGeneratedCSR},
+	}
+	results := EnrollBatch(conn, requests, BatchOptions{NoPickup: true})
+	for _, r := range results {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		if r.PickupID == "" || r.Certificates != nil {
+			t.Fatalf("expected only a pickup ID for %s", r.Request.Subject.CommonName)
+		}
+	}
+}
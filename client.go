@@ -24,7 +24,7 @@ import (
 	"github.com/Venafi/vcert/v4/pkg/venafi/fake"
 	"github.com/Venafi/vcert/v4/pkg/venafi/tpp"
 	"github.com/Venafi/vcert/v4/pkg/verror"
-	"log"
+	"net/url"
 )
 
 // NewClient returns a connector for either Trust Protection Platform (TPP) or Venafi Cloud based on provided configuration.
@@ -32,23 +32,22 @@ import (
 // Returned connector is a concurrency-safe interface to TPP or Venafi Cloud that can be reused without restriction.
 // Connector can also be of type "fake" for local tests, which doesn`t connect to any backend and all certificates enroll locally.
 func (cfg *Config) NewClient() (connector endpoint.Connector, err error) {
-	var connectionTrustBundle *x509.CertPool
-
-	if cfg.ConnectionTrust != "" {
-		log.Println("You specified a trust bundle.")
-		connectionTrustBundle = x509.NewCertPool()
-		if !connectionTrustBundle.AppendCertsFromPEM([]byte(cfg.ConnectionTrust)) {
-			return nil, fmt.Errorf("%w: failed to parse PEM trust bundle", verror.UserDataError)
-		}
+	transport, err := cfg.TransportConfig()
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.ClientIdentity.Validate()
+	if err != nil {
+		return nil, err
 	}
 
 	switch cfg.ConnectorType {
 	case endpoint.ConnectorTypeCloud:
-		connector, err = cloud.NewConnector(cfg.BaseUrl, cfg.Zone, cfg.LogVerbose, connectionTrustBundle)
+		connector, err = cloud.NewConnector(cfg.BaseUrl, cfg.Zone, cfg.LogVerbose, transport.Trust)
 	case endpoint.ConnectorTypeTPP:
-		connector, err = tpp.NewConnector(cfg.BaseUrl, cfg.Zone, cfg.LogVerbose, connectionTrustBundle)
+		connector, err = tpp.NewConnector(cfg.BaseUrl, cfg.Zone, cfg.LogVerbose, transport.Trust)
 	case endpoint.ConnectorTypeFake:
-		connector = fake.NewConnector(cfg.LogVerbose, connectionTrustBundle)
+		connector = fake.NewConnector(cfg.LogVerbose, transport.Trust)
 	default:
 		err = fmt.Errorf("%w: ConnectorType is not defined", verror.UserDataError)
 	}
@@ -56,13 +55,120 @@ func (cfg *Config) NewClient() (connector endpoint.Connector, err error) {
 		return
 	}
 
-	connector.SetZone(cfg.Zone)
+	if c, ok := connector.(loggerSetter); ok {
+		c.SetLogger(cfg.logger())
+	}
+	if c, ok := connector.(transportConfigSetter); ok {
+		c.SetTransportConfig(transport)
+	}
+	if c, ok := connector.(clientIdentitySetter); ok {
+		c.SetClientIdentity(cfg.ClientIdentity)
+	}
 	connector.SetHTTPClient(cfg.Client)
 
-	err = connector.Authenticate(cfg.Credentials)
+	auth := cfg.Credentials
+	if cfg.ClientCertificate != nil {
+		if cfg.ConnectorType != endpoint.ConnectorTypeTPP {
+			return nil, fmt.Errorf("%w: client certificates are not supported by %s", verror.UserDataError, cfg.ConnectorType)
+		}
+		if auth == nil && cfg.CredentialProvider == nil {
+			auth = &endpoint.Authentication{ClientPKCS12: true}
+		}
+	}
+	if cfg.OnTokenRefresh != nil {
+		if c, ok := connector.(tokenRefreshCallbackSetter); ok {
+			c.SetTokenRefreshCallback(cfg.OnTokenRefresh)
+		}
+	}
+	if cfg.CredentialProvider != nil {
+		auth, err = cfg.CredentialProvider.Credentials()
+		if err != nil {
+			return nil, err
+		}
+		if c, ok := connector.(credentialProviderSetter); ok {
+			c.SetCredentialProvider(cfg.CredentialProvider)
+		}
+	}
+	if cfg.Hooks != nil {
+		if c, ok := connector.(hooksSetter); ok {
+			c.SetHooks(cfg.Hooks)
+		}
+		// wrapped last, the setters above are not methods of the wrapper
+		connector = endpoint.WithHooks(connector, cfg.Hooks)
+	}
+	connector.SetZone(cfg.Zone)
+	err = connector.Authenticate(auth)
 	return
 }
 
+// TransportConfig returns the settings of the HTTP client of the connectors created with cfg
+func (cfg *Config) TransportConfig() (t endpoint.TransportConfig, err error) {
+	if cfg.ConnectionTrust != "" {
+		cfg.logger().Debug("Using the trust bundle of the configuration")
+		t.Trust = x509.NewCertPool()
+		if !t.Trust.AppendCertsFromPEM([]byte(cfg.ConnectionTrust)) {
+			return t, fmt.Errorf("%w: failed to parse PEM trust bundle", verror.UserDataError)
+		}
+	}
+	if cfg.ClientCertificate != nil {
+		cert, err := cfg.ClientCertificate.TLSCertificate()
+		if err != nil {
+			return t, err
+		}
+		t.ClientCertificate = &cert
+	}
+	t.InsecureSkipVerify = cfg.InsecureSkipVerify
+	t.Renegotiation = cfg.TLSRenegotiation || cfg.ClientCertificate != nil
+	t.Timeout = cfg.Timeout
+	t.DialTimeout = cfg.DialTimeout
+	t.TLSHandshakeTimeout = cfg.TLSHandshakeTimeout
+	if cfg.ProxyURL != "" {
+		t.Proxy, err = url.Parse(cfg.ProxyURL)
+		if urlErr, ok := err.(*url.Error); ok {
+			// the URL is left out of the error, it may hold the password of the proxy
+			err = urlErr.Err
+		} else if err == nil && (t.Proxy.Scheme == "" || t.Proxy.Host == "") {
+			err = fmt.Errorf("scheme and host are required")
+		}
+		if err != nil {
+			return t, fmt.Errorf("%w: invalid proxy URL: %s", verror.UserDataError, err)
+		}
+	}
+	t.NoProxy = cfg.NoProxy
+	t.Trace = cfg.Trace
+	return t, nil
+}
+
+// credentialProviderSetter is implemented by the connectors that authenticate again with a CredentialProvider
+type credentialProviderSetter interface {
+	SetCredentialProvider(p endpoint.CredentialProvider)
+}
+
+// tokenRefreshCallbackSetter is implemented by the connectors that refresh their access token
+type tokenRefreshCallbackSetter interface {
+	SetTokenRefreshCallback(f tpp.TokenRefreshCallback)
+}
+
+// hooksSetter is implemented by the connectors that call hooks around their HTTP requests
+type hooksSetter interface {
+	SetHooks(h endpoint.Hooks)
+}
+
+// loggerSetter is implemented by the connectors that log
+type loggerSetter interface {
+	SetLogger(l endpoint.Logger)
+}
+
+// clientIdentitySetter is implemented by the connectors that identify the client to the server
+type clientIdentitySetter interface {
+	SetClientIdentity(i endpoint.ClientIdentity)
+}
+
+// transportConfigSetter is implemented by the connectors that create their own HTTP client
+type transportConfigSetter interface {
+	SetTransportConfig(t endpoint.TransportConfig)
+}
+
 // NewClient returns a connector for either Trust Protection Platform (TPP) or Venafi Cloud based on provided configuration.
 // Config should have Credentials compatible with the selected ConnectorType.
 // Returned connector is a concurrency-safe interface to TPP or Venafi Cloud that can be reused without restriction.
@@ -17,23 +17,24 @@
 package vcert
 
 import (
-	"crypto/tls"
 	"crypto/x509/pkix"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/credentials"
 	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/Venafi/vcert/v4/pkg/venafi/tpp"
+	"github.com/Venafi/vcert/v4/pkg/verror"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
 
-func init() {
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-}
-
 func print(a interface{}) {
 	b, err := json.MarshalIndent(a, "", "    ")
 	if err != nil {
@@ -127,3 +128,153 @@ func TestNewClientWithFileConfig(t *testing.T) {
 	haltIf(err)
 	print(certs)
 }
+
+func TestNewClientWithCredentialProvider(t *testing.T) {
+	_, err := NewClient(&Config{
+		ConnectorType:      endpoint.ConnectorTypeFake,
+		CredentialProvider: credentials.Chain{credentials.File{Path: "/nonexistent/vcert/credentials"}},
+	})
+	if !errors.Is(err, verror.NoCredentialsError) || !errors.Is(err, verror.AuthError) {
+		t.Fatalf("expected a NoCredentialsError, got %v", err)
+	}
+
+	_, err = NewClient(&Config{
+		ConnectorType:      endpoint.ConnectorTypeFake,
+		CredentialProvider: credentials.Chain{credentials.File{Path: "/nonexistent/vcert/credentials"}, credentials.Static{Authentication: &endpoint.Authentication{APIKey: "key"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewClientWithTokenRefreshCallback(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/vedauth/authorize/token") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"access-1","refresh_token":"refresh-1","expires":4102444800}`)
+	}))
+	defer ts.Close()
+
+	var refreshed []string
+	_, err := NewClient(&Config{
+		ConnectorType:      endpoint.ConnectorTypeTPP,
+		BaseUrl:            ts.URL,
+		Credentials:        &endpoint.Authentication{RefreshToken: "refresh-0"},
+		InsecureSkipVerify: true,
+		OnTokenRefresh: func(resp tpp.OauthRefreshAccessTokenResponse) {
+			refreshed = append(refreshed, resp.Refresh_token)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refreshed) != 1 || refreshed[0] != "refresh-1" {
+		t.Fatalf("expected OnTokenRefresh to be called with the new refresh token, got %q", refreshed)
+	}
+}
+
+func TestNewClientWithClientCertificate(t *testing.T) {
+	_, err := NewClient(&Config{
+		ConnectorType:     endpoint.ConnectorTypeFake,
+		ClientCertificate: &endpoint.ClientCertificate{PKCS12: []byte("not an archive")},
+	})
+	if !errors.Is(err, verror.UserDataError) {
+		t.Fatalf("expected a UserDataError for a connector without client certificate support, got %v", err)
+	}
+
+	_, err = NewClient(&Config{
+		ConnectorType:     endpoint.ConnectorTypeTPP,
+		BaseUrl:           "https://tpp.example.com",
+		ClientCertificate: &endpoint.ClientCertificate{PKCS12: []byte("not an archive")},
+	})
+	if !errors.Is(err, verror.UserDataError) {
+		t.Fatalf("expected a UserDataError for an invalid client certificate, got %v", err)
+	}
+}
+
+func TestNewClientWithClientIdentity(t *testing.T) {
+	_, err := NewClient(&Config{
+		ConnectorType:  endpoint.ConnectorTypeFake,
+		ClientIdentity: endpoint.ClientIdentity{Source: endpoint.ClientIdentityInterface},
+	})
+	if !errors.Is(err, verror.UserDataError) {
+		t.Fatalf("expected a UserDataError for an interface identity without interface, got %v", err)
+	}
+}
+
+// recordingHooks records the operations it is called for
+type recordingHooks struct {
+	operations []string
+}
+
+func (h *recordingHooks) StartOperation(op endpoint.OperationInfo) func(endpoint.Outcome) {
+	return func(o endpoint.Outcome) {
+		h.operations = append(h.operations, fmt.Sprint(op.ConnectorType, " ", op.Zone, " ", op.Operation))
+	}
+}
+
+func (h *recordingHooks) StartHTTPRequest(req endpoint.HTTPRequestInfo) func(endpoint.Outcome) {
+	return func(endpoint.Outcome) {}
+}
+
+func TestNewClientWithHooks(t *testing.T) {
+	hooks := &recordingHooks{}
+	c, err := NewClient(&Config{ConnectorType: endpoint.ConnectorTypeFake, Zone: "Default", Hooks: hooks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.ReadZoneConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Fake Endpoint Default authenticate", "Fake Endpoint Default read_zone"}
+	if fmt.Sprint(hooks.operations) != fmt.Sprint(expected) {
+		t.Fatalf("expected the operations %q, got %q", expected, hooks.operations)
+	}
+}
+
+// recordingLogger records the messages logged at each level
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) record(level, msg string, keysAndValues []interface{}) {
+	l.messages = append(l.messages, fmt.Sprint(level, " ", msg, " ", keysAndValues))
+}
+
+func (l *recordingLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.record("debug", msg, keysAndValues)
+}
+func (l *recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.record("info", msg, keysAndValues)
+}
+func (l *recordingLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.record("warn", msg, keysAndValues)
+}
+func (l *recordingLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.record("error", msg, keysAndValues)
+}
+
+func TestNewClientWithLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	c, err := NewClient(&Config{ConnectorType: endpoint.ConnectorTypeFake, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &certificate.Request{Subject: pkix.Name{CommonName: "logger.venafi.example.com"}}
+	err = c.GenerateRequest(nil, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.RequestCertificate(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "debug Requested certificate in test mode [cn logger.venafi.example.com pending false]"
+	if len(logger.messages) != 1 || logger.messages[0] != expected {
+		t.Fatalf("expected the connector to log to the logger of the config, got %q", logger.messages)
+	}
+}
@@ -0,0 +1,381 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/Venafi/vcert/v4"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/Venafi/vcert/v4/pkg/verror"
+)
+
+// agentConfig lists the certificates kept renewed by the agent action. Entries accept the keys
+// of an enroll manifest plus the agent specific ones.
+type agentConfig struct {
+	Certificates []agentCertificate `yaml:"certificates"`
+}
+
+type agentCertificate struct {
+	manifestEntry `yaml:",inline"`
+	Zone          string `yaml:"zone"`
+	RenewBefore   string `yaml:"renew-before"`
+	PostHook      string `yaml:"post-hook"`
+}
+
+// agentState is persisted between runs, so that a restarted agent picks up a renewal
+// it has already submitted instead of requesting another one
+type agentState struct {
+	Certificates map[string]*agentCertificateState `json:"certificates"`
+}
+
+type agentCertificateState struct {
+	PickupID   string    `json:"pickupId,omitempty"`
+	Thumbprint string    `json:"thumbprint,omitempty"`
+	NotAfter   time.Time `json:"notAfter,omitempty"`
+	// Pending is set from the moment a request is submitted until its result is written to disk
+	Pending bool `json:"pending,omitempty"`
+	// PendingKey is the locally generated private key of the pending request in PEM, encrypted with the key password
+	// if there is one. The state file is only readable by its owner either way.
+	PendingKey string `json:"pendingKey,omitempty"`
+}
+
+type agent struct {
+	cfg       *vcert.Config
+	entries   []agentCertificate
+	flags     []*commandFlags
+	windows   []certificate.RenewalWindow
+	statePath string
+	state     *agentState
+}
+
+func readAgentConfig(fileName string) (*agentConfig, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent config: %s", err)
+	}
+	ac := &agentConfig{}
+	err = yaml.UnmarshalStrict(b, ac)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse agent config %s: %s", fileName, err)
+	}
+	if len(ac.Certificates) == 0 {
+		return nil, fmt.Errorf("agent config %s does not contain any certificates", fileName)
+	}
+	return ac, nil
+}
+
+func newAgent(cfg *vcert.Config, ac *agentConfig, base *commandFlags, statePath string) (*agent, error) {
+	a := &agent{cfg: cfg, entries: ac.Certificates, statePath: statePath}
+	for i := range ac.Certificates {
+		e := &ac.Certificates[i]
+		cf, err := e.commandFlags(base)
+		if err == nil && cf.format != "" && cf.format != "pem" {
+			err = fmt.Errorf("only pem format is supported by the agent")
+		}
+		renewBefore := e.RenewBefore
+		if renewBefore == "" {
+			renewBefore = base.renewBefore
+		}
+		var window certificate.RenewalWindow
+		if err == nil {
+			window, err = certificate.ParseRenewalWindow(renewBefore)
+		}
+		if err == nil && e.Zone == "" && cfg.Zone == "" && cfg.ConnectorType != endpoint.ConnectorTypeFake {
+			err = fmt.Errorf("zone is required")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("agent config entry %d (%s): %s", i+1, e.CommonName, err)
+		}
+		a.flags = append(a.flags, cf)
+		a.windows = append(a.windows, window)
+	}
+	err := a.loadState()
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *agent) loadState() error {
+	a.state = &agentState{Certificates: map[string]*agentCertificateState{}}
+	b, err := ioutil.ReadFile(a.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read agent state: %s", err)
+	}
+	err = json.Unmarshal(b, a.state)
+	if err != nil {
+		return fmt.Errorf("failed to parse agent state %s: %s", a.statePath, err)
+	}
+	if a.state.Certificates == nil {
+		a.state.Certificates = map[string]*agentCertificateState{}
+	}
+	return nil
+}
+
+func (a *agent) saveState() error {
+	b, err := json.MarshalIndent(a.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(a.statePath, b, 0600)
+}
+
+// run checks all certificates every interval until stop is closed. With once set it returns after the first check.
+func (a *agent) run(interval time.Duration, once bool, stop <-chan struct{}) error {
+	for {
+		err := a.runOnce()
+		if once {
+			return err
+		}
+		if err != nil {
+			logf("%s", err)
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// runOnce checks every certificate and enrolls or renews the ones that are missing or inside their renewal window
+func (a *agent) runOnce() error {
+	conn, err := vcert.NewClient(a.cfg)
+	if err != nil {
+		return fmt.Errorf("Unable to connect to %s: %w", a.cfg.ConnectorType, err)
+	}
+
+	failed := 0
+	for i := range a.entries {
+		err = a.processCertificate(conn, i)
+		if err != nil {
+			failed++
+			logf("Failed to process certificate %s: %s", a.flags[i].commonName, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d certificates could not be processed", failed, len(a.entries))
+	}
+	return nil
+}
+
+func (a *agent) processCertificate(conn endpoint.Connector, i int) error {
+	e, cf := &a.entries[i], a.flags[i]
+	zone := e.Zone
+	if zone == "" {
+		zone = a.cfg.Zone
+	}
+	conn.SetZone(zone)
+
+	certPath := agentCertificatePath(cf)
+	st := a.state.Certificates[certPath]
+	if st == nil {
+		st = &agentCertificateState{}
+		a.state.Certificates[certPath] = st
+	}
+
+	if !st.Pending {
+		oldCert, err := readLeafCertificate(certPath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		var req *certificate.Request
+		if oldCert == nil {
+			logf("Certificate %s does not exist, enrolling %s", certPath, cf.commonName)
+			req, err = fillCertificateRequest(&certificate.Request{}, cf)
+			if err != nil {
+				return err
+			}
+			err = conn.GenerateRequest(nil, req)
+			if err != nil {
+				return err
+			}
+			st.PickupID, err = conn.RequestCertificate(req)
+			if err != nil {
+				return err
+			}
+		} else {
+			if !a.windows[i].NeedsRenewal(oldCert, time.Now()) {
+				if a.cfg.LogVerbose {
+					logf("Certificate %s expires on %s, renewal is not due", certPath, oldCert.NotAfter)
+				}
+				return nil
+			}
+			logf("Certificate %s expires on %s, renewing", certPath, oldCert.NotAfter)
+			req, err = fillCertificateRequest(certificate.NewRequest(oldCert), cf)
+			if err != nil {
+				return err
+			}
+			err = conn.GenerateRequest(nil, req)
+			if err != nil {
+				return err
+			}
+			st.PickupID, err = conn.RenewCertificate(&certificate.RenewalRequest{
+				Thumbprint:         certificateThumbprint(oldCert),
+				CertificateRequest: req,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		st.Pending = true
+		st.PendingKey = ""
+		if req.CsrOrigin == certificate.LocalGeneratedCSR {
+			keyPcc, err := certificate.NewPEMCollection(nil, req.PrivateKey, []byte(cf.keyPassword))
+			if err != nil {
+				return err
+			}
+			st.PendingKey = keyPcc.PrivateKey
+		}
+		err = a.saveState()
+		if err != nil {
+			return fmt.Errorf("failed to save agent state: %s", err)
+		}
+		logf("Successfully posted request for %s, will pick up by %s", cf.commonName, st.PickupID)
+	}
+
+	req := &certificate.Request{
+		PickupID:    st.PickupID,
+		ChainOption: certificate.ChainOptionFromString(cf.chainOption),
+		KeyPassword: cf.keyPassword,
+		Timeout:     time.Duration(cf.timeout) * time.Second,
+	}
+	if st.PendingKey == "" && cf.keyPassword != "" {
+		req.FetchPrivateKey = true
+	}
+	pcc, err := conn.RetrieveCertificate(req)
+	if err != nil {
+		if errors.Is(err, verror.CertificatePendingError) || errors.Is(err, verror.RetrieveCertificateTimeoutError) {
+			logf("Issuance of %s is pending, will retry on the next run", st.PickupID)
+			return nil
+		}
+		if requestFailed(err) {
+			// the request can not be completed anymore, start over on the next run
+			st.Pending = false
+			st.PendingKey = ""
+			if saveErr := a.saveState(); saveErr != nil {
+				logf("Failed to save agent state: %s", saveErr)
+			}
+		}
+		return fmt.Errorf("failed to retrieve certificate %s: %w", st.PickupID, err)
+	}
+	if st.PendingKey != "" {
+		pcc.PrivateKey = st.PendingKey
+	}
+
+	result := &Result{
+		Pcc:      pcc,
+		PickupId: st.PickupID,
+		Config:   resultConfig(cf, commandAgentName),
+	}
+	_, err = result.writeFiles()
+	if err != nil {
+		return err
+	}
+
+	newCert, err := readLeafCertificate(certPath)
+	if err != nil {
+		return err
+	}
+	st.Pending = false
+	st.PendingKey = ""
+	st.Thumbprint = certificateThumbprint(newCert)
+	st.NotAfter = newCert.NotAfter
+	err = a.saveState()
+	if err != nil {
+		return fmt.Errorf("failed to save agent state: %s", err)
+	}
+	logf("Successfully wrote certificate %s, valid until %s", certPath, newCert.NotAfter)
+
+	postHook := e.PostHook
+	if postHook == "" {
+		postHook = cf.postHook
+	}
+	if postHook != "" {
+		return runHook(postHook, postHookEnv(result), cf.postHookTimeout)
+	}
+	return nil
+}
+
+// requestFailed reports whether err means that a pending request can not be completed anymore, e.g. because it was
+// rejected or does not exist. Other errors, such as an unavailable server or expired credentials, leave the request
+// pending for the next run.
+func requestFailed(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, verror.ServerUnavailableError) || errors.Is(err, verror.AuthError) {
+		return false
+	}
+	var respErr *verror.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+		return true
+	}
+	return errors.Is(err, verror.CertificateRejectedError) || errors.Is(err, verror.ServerBadDataResponce) || errors.Is(err, verror.UserDataError)
+}
+
+// agentCertificatePath is the file the agent inspects to decide whether a certificate needs to be renewed
+func agentCertificatePath(cf *commandFlags) string {
+	if cf.certFile != "" {
+		return cf.certFile
+	}
+	return cf.file
+}
+
+// readLeafCertificate returns the first certificate from a PEM, DER or PKCS#7 file that is not a CA, so it works regardless of the chain order
+func readLeafCertificate(fileName string) (*x509.Certificate, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := parseCertificates(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate from %s: %s", fileName, err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found in %s", fileName)
+	}
+	for _, cert := range certs {
+		if !cert.IsCA {
+			return cert, nil
+		}
+	}
+	return certs[0], nil
+}
+
+func certificateThumbprint(cert *x509.Certificate) string {
+	fp := sha1.Sum(cert.Raw)
+	return strings.ToUpper(hex.EncodeToString(fp[:]))
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Venafi/vcert/v4"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/Venafi/vcert/v4/pkg/verror"
+)
+
+func TestAgentRunOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcertAgent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "web.crt")
+	keyFile := filepath.Join(dir, "web.key")
+	hookFile := filepath.Join(dir, "hook.out")
+	configFile := filepath.Join(dir, "agent.yaml")
+	config := fmt.Sprintf(`
+certificates:
+  - cn: web.example.com
+    cert-file: %s
+    key-file: %s
+    post-hook: echo done >> %s
+`, certFile, keyFile, hookFile)
+	err = ioutil.WriteFile(configFile, []byte(config), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac, err := readAgentConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := &commandFlags{format: "pem", chainOption: "root-last", renewBefore: "30d", keyPassword: "Passw0rd"}
+	cfg := &vcert.Config{ConnectorType: endpoint.ConnectorTypeFake}
+	a, err := newAgent(cfg, ac, base, configFile+".state")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the certificate does not exist yet, so it is enrolled
+	err = a.runOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := readLeafCertificate(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	st := a.state.Certificates[certFile]
+	if st == nil || st.Pending || st.Thumbprint != certificateThumbprint(first) {
+		t.Fatalf("unexpected state after enrollment: %+v", st)
+	}
+
+	// the fake certificate is valid for 89 more days, so nothing happens
+	err = a.runOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := readLeafCertificate(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certificateThumbprint(first) != certificateThumbprint(second) {
+		t.Fatal("certificate outside the renewal window should not be renewed")
+	}
+
+	// a restarted agent with a wider window renews the certificate
+	ac.Certificates[0].RenewBefore = "100d"
+	a, err = newAgent(cfg, ac, base, configFile+".state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.state.Certificates[certFile].Thumbprint != certificateThumbprint(first) {
+		t.Fatal("agent state was not restored")
+	}
+	err = a.runOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := readLeafCertificate(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certificateThumbprint(first) == certificateThumbprint(third) {
+		t.Fatal("certificate inside the renewal window should be renewed")
+	}
+
+	hookOutput, err := ioutil.ReadFile(hookFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(hookOutput) != "done\ndone\n" {
+		t.Fatalf("post-hook should run after enrollment and renewal, got %q", hookOutput)
+	}
+}
+
+func TestAgentWithoutKeyPassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcertAgent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "web.crt")
+	keyFile := filepath.Join(dir, "web.key")
+	ac := &agentConfig{Certificates: []agentCertificate{{manifestEntry: manifestEntry{CommonName: "web.example.com", CertFile: certFile, KeyFile: keyFile}}}}
+	cfg := &vcert.Config{ConnectorType: endpoint.ConnectorTypeFake}
+	a, err := newAgent(cfg, ac, &commandFlags{format: "pem", chainOption: "root-last", renewBefore: "30d"}, filepath.Join(dir, "agent.state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.runOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(key), "PRIVATE KEY") || strings.Contains(string(key), "ENCRYPTED") {
+		t.Fatalf("expected an unencrypted private key, got %s", key)
+	}
+}
+
+func TestRequestFailed(t *testing.T) {
+	cases := []struct {
+		err    error
+		failed bool
+	}{
+		{fmt.Errorf("%w: failed to retrieve certificate", verror.CertificateRejectedError), true},
+		{fmt.Errorf("unable to retrieve: %w", &verror.ResponseError{StatusCode: http.StatusBadRequest}), true},
+		{fmt.Errorf("unable to retrieve: %w", &verror.ResponseError{StatusCode: http.StatusNotFound}), true},
+		{fmt.Errorf("unable to retrieve: %w", &verror.ResponseError{StatusCode: http.StatusServiceUnavailable}), false},
+		{fmt.Errorf("unable to retrieve: %w", &verror.ResponseError{StatusCode: http.StatusUnauthorized, Kind: verror.AuthError}), false},
+		{fmt.Errorf("unable to retrieve: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), false},
+		{errors.New("unexpected"), false},
+	}
+	for _, c := range cases {
+		if failed := requestFailed(c.err); failed != c.failed {
+			t.Errorf("requestFailed(%q) = %v, want %v", c.err, failed, c.failed)
+		}
+	}
+}
@@ -17,6 +17,9 @@
 package main
 
 import (
+	"os"
+	"time"
+
 	"github.com/Venafi/vcert/v4/pkg/certificate"
 )
 
@@ -29,6 +32,9 @@ const (
 	commandGetCredName   = "getcred"
 	commandCheckCredName = "checkcred"
 	commandVoidCredName  = "voidcred"
+	commandAgentName     = "agent"
+	commandConvertName   = "convert"
+	commandInspectName   = "inspect"
 )
 
 var (
@@ -46,6 +52,7 @@ type commandFlags struct {
 	clientP12         string
 	clientP12PW       string
 	commonName        string
+	concurrency       int
 	config            string
 	country           string
 	csrFile           string
@@ -54,10 +61,12 @@ type commandFlags struct {
 	distinguishedName string
 	dnsSans           stringSlice
 	emailSans         rfc822NameSlice
+	errorFormat       string
 	file              string
 	format            string
 	friendlyName      string
 	insecure          bool
+	interval          time.Duration
 	instance          string
 	ipSans            ipSlice
 	jksAlias          string
@@ -70,6 +79,7 @@ type commandFlags struct {
 	keyType           *certificate.KeyType
 	keyTypeString     string
 	locality          string
+	manifest          string
 	noPickup          bool
 	noPrompt          bool
 	noRetire          bool
@@ -95,9 +105,34 @@ type commandFlags struct {
 	uriSans           uriSlice
 	url               string
 	verbose           bool
+	trace             bool
 	zone              string
 	omitSans          bool
+	once              bool
+	postHook          string
+	postHookTimeout   time.Duration
+	reuseKey          bool
+	backup            bool
+	fileModeString    string
+	fileMode          os.FileMode
+	fileOwner         string
+	fileGroup         string
+	secret            k8sSecretConfig
+	secretLabels      []string
+	secretAnnotations []string
+	layout            string
+	pkcs12Profile     string
+	pkcs12TrustStore  bool
+	outputDir         string
+	renewBefore       string
+	stateFile         string
 	csrFormat         string
 	credFormat        string
+	saveCredentials   bool
 	validDays         string
+	inFile            string
+	inKeyFile         string
+	inPassword        string
+	inKeyPassword     string
+	inspectFormat     string
 }
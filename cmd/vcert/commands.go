@@ -17,17 +17,19 @@
 package main
 
 import (
-	"crypto/tls"
+	"bytes"
+	"crypto"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net"
-	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/Venafi/vcert/v4"
@@ -39,7 +41,6 @@ import (
 )
 
 var (
-	tlsConfig      tls.Config
 	connectionType endpoint.ConnectorType
 	commandEnroll  = &cli.Command{
 		Before: runBeforeCommand,
@@ -53,7 +54,8 @@ var (
 		vcert enroll -u https://tpp.example.com -t <TPP access token> -z <zone> --cn <common name>
 		vcert enroll -u https://tpp.example.com -t <TPP access token> -z <zone> --cn <common name> --key-size 4096 --san-dns <alt name> --san-dns <alt name2>
 		vcert enroll -u https://tpp.example.com -t <TPP access token> -z <zone> --cn <common name> --key-type ecdsa --key-curve p384 --san-dns <alt name> -san-dns <alt name2>
-		vcert enroll -u https://tpp.example.com -t <TPP access token> -z <zone> --p12-file <PKCS#12 client cert> --p12-password <PKCS#12 password> --cn <common name>`,
+		vcert enroll -u https://tpp.example.com -t <TPP access token> -z <zone> --p12-file <PKCS#12 client cert> --p12-password <PKCS#12 password> --cn <common name>
+		vcert enroll -u https://tpp.example.com -t <TPP access token> -z <zone> --manifest <YAML manifest file> --concurrency 8`,
 	}
 	commandGetCred = &cli.Command{
 		Before: runBeforeCommand,
@@ -119,17 +121,58 @@ var (
 		Usage:  "To renew a certificate",
 		UsageText: ` vcert renew <Required Venafi Cloud Config> OR <Required Trust Protection Platform Config> <Options>
 		vcert renew -u https://tpp.example.com -t <TPP access token> --id <ID value>
-		vcert renew -k <Venafi Cloud API key> --thumbprint <cert SHA1 fingerprint>`,
+		vcert renew -k <Venafi Cloud API key> --thumbprint <cert SHA1 fingerprint>
+		vcert renew -k <Venafi Cloud API key> --thumbprint <cert SHA1 fingerprint> --renew-before 30d
+		vcert renew -k <Venafi Cloud API key> -z <zone> --cert-file <cert file> --key-file <key file> --reuse-key
+		vcert renew -u https://tpp.example.com -t <TPP access token> --id <ID value> --cert-file <cert file> --key-file <key file> --post-hook "systemctl reload nginx"`,
+	}
+	commandAgent = &cli.Command{
+		Before: runBeforeCommand,
+		Name:   commandAgentName,
+		Flags:  agentFlags,
+		Action: doCommandAgent,
+		Usage:  "To keep the certificates listed in a manifest enrolled and renewed",
+		UsageText: ` vcert agent <Required Venafi Cloud Config> OR <Required Trust Protection Platform Config> <Options>
+		vcert agent -u https://tpp.example.com -t <TPP access token> -z <zone> --manifest <YAML manifest file>
+		vcert agent -k <Venafi Cloud API key> -z <zone> --manifest <YAML manifest file> --renew-before 15d --interval 6h
+		vcert agent -u https://tpp.example.com -t <TPP access token> --manifest <YAML manifest file> --once`,
+	}
+	commandConvert = &cli.Command{
+		Before: runBeforeCommand,
+		Name:   commandConvertName,
+		Flags:  convertFlags,
+		Action: doCommandConvert,
+		Usage:  "To convert a local certificate bundle to another format",
+		UsageText: ` vcert convert --in <input file> <Options>
+		vcert convert --in bundle.p12 --in-password <password> --cert-file cert.pem --key-file key.pem --chain-file chain.pem --no-prompt
+		vcert convert --in cert.pem --in-key-file key.pem --format pkcs12 --pkcs12-profile modern --file bundle.p12 --key-password <password>
+		vcert convert --in keystore.jks --in-password <password> --format pkcs12 --file bundle.p12 --key-password <password> --nickname web`,
+	}
+	commandInspect = &cli.Command{
+		Before: runBeforeCommand,
+		Name:   commandInspectName,
+		Flags:  inspectFlags,
+		Action: doCommandInspect,
+		Usage:  "To show the details of a certificate, CSR or private key file",
+		UsageText: ` vcert inspect --in <input file> <Options>
+		vcert inspect --in cert.pem --in-key-file key.pem --format json
+		vcert inspect --in bundle.p12 --in-password <password>
+		vcert inspect --in request.csr -u https://tpp.example.com -t <TPP access token> -z <zone>`,
 	}
 )
 
 func runBeforeCommand(c *cli.Context) error {
 	//TODO: move all flag validations here
+	if flags.errorFormat != errorFormatText && flags.errorFormat != errorFormatJSON {
+		return fmt.Errorf("Unexpected --error-format: %s; specify text or json", flags.errorFormat)
+	}
 	flags.orgUnits = c.StringSlice("ou")
 	flags.dnsSans = c.StringSlice("san-dns")
 	flags.emailSans = c.StringSlice("san-email")
 	flags.upnSans = c.StringSlice("san-upn")
 	flags.customFields = c.StringSlice("field")
+	flags.secretLabels = c.StringSlice("secret-label")
+	flags.secretAnnotations = c.StringSlice("secret-annotation")
 
 	noDuplicatedFlags := []string{"instance", "tls-address", "app-info"}
 	for _, f := range noDuplicatedFlags {
@@ -160,52 +203,29 @@ func runBeforeCommand(c *cli.Context) error {
 	return nil
 }
 
-func setTLSConfig() error {
-	//Set RenegotiateFreelyAsClient in case of we're communicating with MTLS TPP server with only user\password
-	if flags.apiKey == "" {
-		tlsConfig.Renegotiation = tls.RenegotiateFreelyAsClient
+// loadClientPKCS12 sets the client certificate of cfg to the PKCS#12 archive of the --client-pkcs12 flag. Without
+// a trust bundle the certificates of the archive are trusted to issue the server certificate.
+func loadClientPKCS12(cfg *vcert.Config, flags *commandFlags) error {
+	p12, err := ioutil.ReadFile(flags.clientP12)
+	if err != nil {
+		return fmt.Errorf("Error reading PKCS#12 archive file: %w", err)
 	}
 
-	if flags.insecure {
-		tlsConfig.InsecureSkipVerify = true
+	blocks, err := pkcs12.ToPEM(p12, flags.clientP12PW)
+	if err != nil {
+		return fmt.Errorf("Error converting PKCS#12 archive file to PEM blocks: %w", err)
 	}
+	cfg.ClientCertificate = &endpoint.ClientCertificate{PKCS12: p12, PKCS12Password: flags.clientP12PW}
 
-	if flags.clientP12 != "" {
-		// Load client PKCS#12 archive
-		p12, err := ioutil.ReadFile(flags.clientP12)
-		if err != nil {
-			return fmt.Errorf("Error reading PKCS#12 archive file: %s", err)
-		}
-
-		blocks, err := pkcs12.ToPEM(p12, flags.clientP12PW)
-		if err != nil {
-			return fmt.Errorf("Error converting PKCS#12 archive file to PEM blocks: %s", err)
-		}
-
+	if cfg.ConnectionTrust == "" {
 		var pemData []byte
 		for _, b := range blocks {
-			pemData = append(pemData, pem.EncodeToMemory(b)...)
-		}
-
-		// Construct TLS certificate from PEM data
-		cert, err := tls.X509KeyPair(pemData, pemData)
-		if err != nil {
-			return fmt.Errorf("Error reading PEM data to build X.509 certificate: %s", err)
+			if b.Type == "CERTIFICATE" {
+				pemData = append(pemData, pem.EncodeToMemory(b)...)
+			}
 		}
-
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(pemData)
-
-		// Setup HTTPS client
-		tlsConfig.Certificates = []tls.Certificate{cert}
-		tlsConfig.RootCAs = caCertPool
-		// nolint:staticcheck
-		tlsConfig.BuildNameToCertificate()
+		cfg.ConnectionTrust = string(pemData)
 	}
-
-	//Setting TLS configuration
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tlsConfig
-
 	return nil
 }
 
@@ -214,24 +234,22 @@ func doCommandEnroll1(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	err = setTLSConfig()
-	if err != nil {
-		return err
-	}
-
 	validateOverWritingEnviromentVariables()
 
 	cfg, err := buildConfig(c, &flags)
 	if err != nil {
-		return fmt.Errorf("Failed to build vcert config: %s", err)
+		return fmt.Errorf("Failed to build vcert config: %w", err)
+	}
+
+	if flags.manifest != "" {
+		return doCommandEnrollManifest(c, &cfg)
 	}
 
 	connector, err := vcert.NewClient(&cfg)
 	if err != nil {
-		logf("Unable to connect to %s: %s", cfg.ConnectorType, err)
-	} else {
-		logf("Successfully connected to %s", cfg.ConnectorType)
+		return fmt.Errorf("Unable to connect to %s: %w", cfg.ConnectorType, err)
 	}
+	logf("Successfully connected to %s", cfg.ConnectorType)
 	var req = &certificate.Request{}
 	var pcc = &certificate.PEMCollection{}
 
@@ -241,7 +259,10 @@ func doCommandEnroll1(c *cli.Context) error {
 		return err
 	}
 	logf("Successfully read zone configuration for %s", flags.zone)
-	req = fillCertificateRequest(req, &flags)
+	req, err = fillCertificateRequest(req, &flags)
+	if err != nil {
+		return err
+	}
 	err = connector.GenerateRequest(zoneConfig, req)
 	if err != nil {
 		return err
@@ -281,7 +302,7 @@ func doCommandEnroll1(c *cli.Context) error {
 			// otherwise private key can be taken from *req
 			err := pcc.AddPrivateKey(req.PrivateKey, []byte(flags.keyPassword))
 			if err != nil {
-				log.Fatal(err)
+				return fmt.Errorf("Failed to add the private key to the results: %w", err)
 			}
 		}
 	}
@@ -289,62 +310,164 @@ func doCommandEnroll1(c *cli.Context) error {
 	result := &Result{
 		Pcc:      pcc,
 		PickupId: flags.pickupID,
-		Config: &Config{
-			Command:      c.Command.Name,
-			Format:       flags.format,
-			JKSAlias:     flags.jksAlias,
-			JKSPassword:  flags.jksPassword,
-			ChainOption:  certificate.ChainOptionFromString(flags.chainOption),
-			AllFile:      flags.file,
-			KeyFile:      flags.keyFile,
-			CertFile:     flags.certFile,
-			ChainFile:    flags.chainFile,
-			PickupIdFile: flags.pickupIDFile,
-			KeyPassword:  flags.keyPassword,
-		},
+		Config:   resultConfig(&flags, c.Command.Name),
 	}
 
 	err = result.Flush()
 
 	if err != nil {
-		return fmt.Errorf("Failed to output the results: %s", err)
+		return fmt.Errorf("Failed to output the results: %w", err)
+	}
+	return runPostHook(result)
+}
+
+func doCommandEnrollManifest(c *cli.Context, cfg *vcert.Config) error {
+	m, err := readManifest(flags.manifest)
+	if err != nil {
+		return err
+	}
+
+	entryFlags := make([]*commandFlags, len(m.Certificates))
+	requests := make([]*certificate.Request, len(m.Certificates))
+	for i := range m.Certificates {
+		cf, err := m.Certificates[i].commandFlags(&flags)
+		if err != nil {
+			return fmt.Errorf("manifest entry %d (%s): %w", i+1, m.Certificates[i].CommonName, err)
+		}
+		entryFlags[i] = cf
+		req, err := fillCertificateRequest(&certificate.Request{}, cf)
+		if err != nil {
+			return fmt.Errorf("manifest entry %d (%s): %w", i+1, m.Certificates[i].CommonName, err)
+		}
+		req.ChainOption = certificate.ChainOptionFromString(cf.chainOption)
+		req.KeyPassword = cf.keyPassword
+		requests[i] = req
+	}
+
+	connector, err := vcert.NewClient(cfg)
+	if err != nil {
+		return fmt.Errorf("Unable to connect to %s: %w", cfg.ConnectorType, err)
+	}
+	logf("Successfully connected to %s", cfg.ConnectorType)
+	logf("Enrolling %d certificates from %s", len(requests), flags.manifest)
+
+	results := vcert.EnrollBatch(connector, requests, vcert.BatchOptions{
+		Concurrency:   flags.concurrency,
+		PickupTimeout: time.Duration(flags.timeout) * time.Second,
+		NoPickup:      flags.noPickup,
+	})
+
+	summary := manifestSummary{}
+	for i, r := range results {
+		cf := entryFlags[i]
+		err := r.Err
+		if err == nil {
+			pcc := r.Certificates
+			if flags.noPickup {
+				pcc, err = certificate.NewPEMCollection(nil, r.Request.PrivateKey, []byte(cf.keyPassword))
+			}
+			if err == nil {
+				result := &Result{
+					Pcc:      pcc,
+					PickupId: r.PickupID,
+					Config:   resultConfig(cf, c.Command.Name),
+				}
+				// STDOUT is taken by the summary, the pickup ID is reported there
+				_, err = result.writeFiles()
+				if err == nil {
+					err = runPostHook(result)
+				}
+			}
+		}
+
+		entry := manifestEntryResult{CommonName: cf.commonName, PickupID: r.PickupID}
+		if err != nil {
+			entry.Status = "failed"
+			entry.Error = err.Error()
+			summary.Failed++
+			logf("Failed to enroll %s: %s", cf.commonName, err)
+		} else {
+			entry.Status = "succeeded"
+			summary.Succeeded++
+			logf("Successfully enrolled %s, pickup ID %s", cf.commonName, r.PickupID)
+		}
+		summary.Certificates = append(summary.Certificates, entry)
+	}
+
+	err = outputJSON(summary)
+	if err != nil {
+		return err
+	}
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d of %d certificates from the manifest failed to enroll", summary.Failed, len(results))
 	}
 	return nil
 }
 
-func doCommandCredMgmt1(c *cli.Context) error {
-	err := validateCredMgmtFlags1(c.Command.Name)
+func doCommandAgent(c *cli.Context) error {
+	err := validateAgentFlags(c.Command.Name)
 	if err != nil {
 		return err
 	}
 	validateOverWritingEnviromentVariables()
 
-	err = setTLSConfig()
+	cfg, err := buildConfig(c, &flags)
+	if err != nil {
+		return fmt.Errorf("Failed to build vcert config: %w", err)
+	}
+
+	ac, err := readAgentConfig(flags.manifest)
+	if err != nil {
+		return err
+	}
+	statePath := flags.stateFile
+	if statePath == "" {
+		statePath = flags.manifest + ".state"
+	}
+	a, err := newAgent(&cfg, ac, &flags, statePath)
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		logf("Stopping the agent")
+		close(stop)
+	}()
+
+	logf("Managing %d certificates from %s", len(ac.Certificates), flags.manifest)
+	return a.run(flags.interval, flags.once, stop)
+}
+
+func doCommandCredMgmt1(c *cli.Context) error {
+	err := validateCredMgmtFlags1(c.Command.Name)
 	if err != nil {
 		return err
 	}
+	validateOverWritingEnviromentVariables()
 
 	cfg, err := buildConfig(c, &flags)
 	if err != nil {
-		return fmt.Errorf("Failed to build vcert config: %s", err)
+		return fmt.Errorf("Failed to build vcert config: %w", err)
 	}
 
 	var clientP12 bool
 	if flags.clientP12 != "" {
 		clientP12 = true
 	}
-	var connectionTrustBundle *x509.CertPool
-	if cfg.ConnectionTrust != "" {
-		logf("You specified a trust bundle.")
-		connectionTrustBundle = x509.NewCertPool()
-		if !connectionTrustBundle.AppendCertsFromPEM([]byte(cfg.ConnectionTrust)) {
-			return fmt.Errorf("Failed to parse PEM trust bundle")
-		}
+	transport, err := cfg.TransportConfig()
+	if err != nil {
+		return err
 	}
-	tppConnector, err := tpp.NewConnector(cfg.BaseUrl, "", cfg.LogVerbose, connectionTrustBundle)
+	tppConnector, err := tpp.NewConnector(cfg.BaseUrl, "", cfg.LogVerbose, transport.Trust)
 	if err != nil {
-		return fmt.Errorf("could not create TPP connector: %s", err)
+		return fmt.Errorf("could not create TPP connector: %w", err)
 	}
+	tppConnector.SetTransportConfig(transport)
+	tppConnector.SetLogger(cfg.Logger)
 
 	switch c.Command.Name {
 	case commandGetCredName:
@@ -362,6 +485,12 @@ func doCommandCredMgmt1(c *cli.Context) error {
 			if err != nil {
 				return err
 			}
+			// TPP rotates the refresh token, so a saved one is always replaced
+			if flags.saveCredentials || usesSavedCredential(&flags) {
+				if err := saveCredential(&flags, resp.Access_token, resp.Expires, resp.Refresh_token); err != nil {
+					return err
+				}
+			}
 			if flags.credFormat == "json" {
 				if err := outputJSON(resp); err != nil {
 					return err
@@ -381,6 +510,11 @@ func doCommandCredMgmt1(c *cli.Context) error {
 			if err != nil {
 				return err
 			}
+			if flags.saveCredentials {
+				if err := saveCredential(&flags, resp.Access_token, resp.Expires, resp.Refresh_token); err != nil {
+					return err
+				}
+			}
 			if flags.credFormat == "json" {
 				if err := outputJSON(resp); err != nil {
 					return err
@@ -401,6 +535,11 @@ func doCommandCredMgmt1(c *cli.Context) error {
 			if err != nil {
 				return err
 			}
+			if flags.saveCredentials {
+				if err := saveCredential(&flags, resp.Access_token, resp.Expires, resp.Refresh_token); err != nil {
+					return err
+				}
+			}
 			if flags.credFormat == "json" {
 				if err := outputJSON(resp); err != nil {
 					return err
@@ -454,6 +593,9 @@ func doCommandCredMgmt1(c *cli.Context) error {
 				return err
 			}
 			logf("Access token grant successfully revoked")
+			if err := removeSavedCredential(&flags, cfg.Credentials.AccessToken); err != nil {
+				return err
+			}
 		} else {
 			return fmt.Errorf("Failed to determine credentials set")
 		}
@@ -486,29 +628,23 @@ func doCommandPickup1(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	err = setTLSConfig()
-	if err != nil {
-		return err
-	}
-
 	validateOverWritingEnviromentVariables()
 
 	cfg, err := buildConfig(c, &flags)
 	if err != nil {
-		return fmt.Errorf("Failed to build vcert config: %s", err)
+		return fmt.Errorf("Failed to build vcert config: %w", err)
 	}
 
 	connector, err := vcert.NewClient(&cfg) // Everything else requires an endpoint connection
 	if err != nil {
-		logf("Unable to connect to %s: %s", cfg.ConnectorType, err)
-	} else {
-		logf("Successfully connected to %s", cfg.ConnectorType)
+		return fmt.Errorf("Unable to connect to %s: %w", cfg.ConnectorType, err)
 	}
+	logf("Successfully connected to %s", cfg.ConnectorType)
 
 	if flags.pickupIDFile != "" {
 		bytes, err := ioutil.ReadFile(flags.pickupIDFile)
 		if err != nil {
-			return fmt.Errorf("Failed to read Pickup ID value: %s", err)
+			return fmt.Errorf("Failed to read Pickup ID value: %w", err)
 		}
 		flags.pickupID = strings.TrimSpace(string(bytes))
 	}
@@ -524,33 +660,21 @@ func doCommandPickup1(c *cli.Context) error {
 	var pcc *certificate.PEMCollection
 	pcc, err = retrieveCertificate(connector, req, time.Duration(flags.timeout)*time.Second)
 	if err != nil {
-		return fmt.Errorf("Failed to retrieve certificate: %s", err)
+		return fmt.Errorf("Failed to retrieve certificate: %w", err)
 	}
 	logf("Successfully retrieved request for %s", flags.pickupID)
 
 	result := &Result{
 		Pcc:      pcc,
 		PickupId: flags.pickupID,
-		Config: &Config{
-			Command:      c.Command.Name,
-			Format:       flags.format,
-			JKSAlias:     flags.jksAlias,
-			JKSPassword:  flags.jksPassword,
-			ChainOption:  certificate.ChainOptionFromString(flags.chainOption),
-			AllFile:      flags.file,
-			KeyFile:      flags.keyFile,
-			CertFile:     flags.certFile,
-			ChainFile:    flags.chainFile,
-			PickupIdFile: flags.pickupIDFile,
-			KeyPassword:  flags.keyPassword,
-		},
+		Config:   resultConfig(&flags, c.Command.Name),
 	}
 	err = result.Flush()
 
 	if err != nil {
-		return fmt.Errorf("Failed to output the results: %s", err)
+		return fmt.Errorf("Failed to output the results: %w", err)
 	}
-	return nil
+	return runPostHook(result)
 }
 
 func doCommandRevoke1(c *cli.Context) error {
@@ -558,24 +682,18 @@ func doCommandRevoke1(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	err = setTLSConfig()
-	if err != nil {
-		return err
-	}
-
 	validateOverWritingEnviromentVariables()
 
 	cfg, err := buildConfig(c, &flags)
 	if err != nil {
-		return fmt.Errorf("Failed to build vcert config: %s", err)
+		return fmt.Errorf("Failed to build vcert config: %w", err)
 	}
 
 	connector, err := vcert.NewClient(&cfg) // Everything else requires an endpoint connection
 	if err != nil {
-		logf("Unable to connect to %s: %s", cfg.ConnectorType, err)
-	} else {
-		logf("Successfully connected to %s", cfg.ConnectorType)
+		return fmt.Errorf("Unable to connect to %s: %w", cfg.ConnectorType, err)
 	}
+	logf("Successfully connected to %s", cfg.ConnectorType)
 
 	var revReq = &certificate.RevocationRequest{}
 	switch true {
@@ -604,7 +722,7 @@ func doCommandRevoke1(c *cli.Context) error {
 
 	err = connector.RevokeCertificate(revReq)
 	if err != nil {
-		return fmt.Errorf("Failed to revoke certificate: %s", err)
+		return fmt.Errorf("Failed to revoke certificate: %w", err)
 	}
 	logf("Successfully created revocation request for %s", requestedFor)
 
@@ -617,62 +735,78 @@ func doCommandRenew1(c *cli.Context) error {
 		return err
 	}
 
-	err = setTLSConfig()
-	if err != nil {
-		return err
-	}
-
 	validateOverWritingEnviromentVariables()
 	cfg, err := buildConfig(c, &flags)
 	if err != nil {
-		return fmt.Errorf("Failed to build vcert config: %s", err)
-	}
-
-	connector, err := vcert.NewClient(&cfg) // Everything else requires an endpoint connection
-	if err != nil {
-		logf("Unable to connect to %s: %s", cfg.ConnectorType, err)
-	} else {
-		logf("Successfully connected to %s", cfg.ConnectorType)
+		return fmt.Errorf("Failed to build vcert config: %w", err)
 	}
 
 	var req = &certificate.Request{}
 	var pcc = &certificate.PEMCollection{}
 
-	searchReq := &certificate.Request{
-		PickupID:   flags.distinguishedName,
-		Thumbprint: flags.thumbprint,
+	renewLocal := flags.distinguishedName == "" && flags.thumbprint == ""
+	var oldCert *x509.Certificate
+	if renewLocal {
+		// the certificate from --cert-file is renewed and replaced with the new one
+		oldCert, err = readLeafCertificate(flags.certFile)
+		if err != nil {
+			return fmt.Errorf("Failed to read the certificate to renew: %w", err)
+		}
+		flags.thumbprint = certificateThumbprint(oldCert)
+		logf("Read the certificate from %s. Serial: %x, NotAfter: %s", flags.certFile, oldCert.SerialNumber, oldCert.NotAfter)
+		// checked before connecting, so that cron jobs do not depend on the server until renewal is due
+		err = checkRenewalDue(oldCert)
+		if err != nil {
+			return err
+		}
 	}
 
-	// here we fetch old cert anyway
-	oldPcc, err := connector.RetrieveCertificate(searchReq)
+	connector, err := vcert.NewClient(&cfg) // Everything else requires an endpoint connection
 	if err != nil {
-		return fmt.Errorf("Failed to fetch old certificate by id %s: %s", flags.distinguishedName, err)
-	}
-	oldCertBlock, _ := pem.Decode([]byte(oldPcc.Certificate))
-	if oldCertBlock == nil || oldCertBlock.Type != "CERTIFICATE" {
-		return fmt.Errorf("Failed to fetch old certificate by id %s: PEM parse error", flags.distinguishedName)
+		return fmt.Errorf("Unable to connect to %s: %w", cfg.ConnectorType, err)
 	}
-	oldCert, err := x509.ParseCertificate([]byte(oldCertBlock.Bytes))
-	if err != nil {
-		return fmt.Errorf("Failed to fetch old certificate by id %s: %s", flags.distinguishedName, err)
+	logf("Successfully connected to %s", cfg.ConnectorType)
+
+	if !renewLocal {
+		oldCert, err = fetchCertificateToRenew(connector)
+		if err != nil {
+			return err
+		}
+		err = checkRenewalDue(oldCert)
+		if err != nil {
+			return err
+		}
 	}
-	// now we have old one
-	logf("Fetched the latest certificate. Serial: %x, NotAfter: %s", oldCert.SerialNumber, oldCert.NotAfter)
 
 	switch true {
 	case strings.HasPrefix(flags.csrOption, "file:"):
 		// will be just sending CSR to backend
-		req = fillCertificateRequest(req, &flags)
+		req, err = fillCertificateRequest(req, &flags)
+		if err != nil {
+			return err
+		}
 
 	case "local" == flags.csrOption || "" == flags.csrOption:
 		// restore certificate request from old certificate
 		req = certificate.NewRequest(oldCert)
 		// override values with those from command line flags
-		req = fillCertificateRequest(req, &flags)
+		req, err = fillCertificateRequest(req, &flags)
+		if err != nil {
+			return err
+		}
+		if flags.reuseKey {
+			req.PrivateKey, err = readKeyToReuse(connector, oldCert)
+			if err != nil {
+				return err
+			}
+		}
 
 	case "service" == flags.csrOption:
 		// logger.Panic("service side renewal is not implemented")
-		req = fillCertificateRequest(req, &flags)
+		req, err = fillCertificateRequest(req, &flags)
+		if err != nil {
+			return err
+		}
 
 	default:
 		return fmt.Errorf("unexpected -csr option: %s", flags.csrOption)
@@ -727,7 +861,7 @@ func doCommandRenew1(c *cli.Context) error {
 			// otherwise private key can be taken from *req
 			err = pcc.AddPrivateKey(req.PrivateKey, []byte(flags.keyPassword))
 			if err != nil {
-				logger.Fatal(err)
+				return fmt.Errorf("failed to add private key: %w", err)
 			}
 		}
 	}
@@ -739,37 +873,93 @@ func doCommandRenew1(c *cli.Context) error {
 		if err == nil {
 			old, _ := json.Marshal(oldCert.PublicKey)
 			new, _ := json.Marshal(newCert.PublicKey)
-			if len(old) > 0 && string(old) == string(new) {
+			if len(old) > 0 && string(old) == string(new) && !flags.reuseKey {
 				logf("WARNING: private key reused")
 			}
 		}
 	}
 
+	resultCfg := resultConfig(&flags, c.Command.Name)
+	resultCfg.Backup = renewLocal || flags.backup
 	result := &Result{
 		Pcc:      pcc,
 		PickupId: flags.pickupID,
-		Config: &Config{
-			Command:      c.Command.Name,
-			Format:       flags.format,
-			JKSAlias:     flags.jksAlias,
-			JKSPassword:  flags.jksPassword,
-			ChainOption:  certificate.ChainOptionFromString(flags.chainOption),
-			AllFile:      flags.file,
-			KeyFile:      flags.keyFile,
-			CertFile:     flags.certFile,
-			ChainFile:    flags.chainFile,
-			PickupIdFile: flags.pickupIDFile,
-			KeyPassword:  flags.keyPassword,
-		},
+		Config:   resultCfg,
 	}
 	err = result.Flush()
 
 	if err != nil {
-		return fmt.Errorf("Failed to output the results: %s", err)
+		return fmt.Errorf("Failed to output the results: %w", err)
+	}
+	return runPostHook(result)
+}
+
+// fetchCertificateToRenew retrieves the latest certificate identified by --id or --thumbprint
+func fetchCertificateToRenew(connector endpoint.Connector) (*x509.Certificate, error) {
+	searchReq := &certificate.Request{
+		PickupID:   flags.distinguishedName,
+		Thumbprint: flags.thumbprint,
+	}
+
+	oldPcc, err := connector.RetrieveCertificate(searchReq)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch old certificate by id %s: %w", flags.distinguishedName, err)
+	}
+	oldCertBlock, _ := pem.Decode([]byte(oldPcc.Certificate))
+	if oldCertBlock == nil || oldCertBlock.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("Failed to fetch old certificate by id %s: PEM parse error", flags.distinguishedName)
+	}
+	oldCert, err := x509.ParseCertificate([]byte(oldCertBlock.Bytes))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch old certificate by id %s: %w", flags.distinguishedName, err)
+	}
+	logf("Fetched the latest certificate. Serial: %x, NotAfter: %s", oldCert.SerialNumber, oldCert.NotAfter)
+	return oldCert, nil
+}
+
+// checkRenewalDue returns an exitError with exitCodeRenewalNotDue if --renew-before is set and oldCert is outside of its window
+func checkRenewalDue(oldCert *x509.Certificate) error {
+	if flags.renewBefore == "" {
+		return nil
+	}
+	window, _ := certificate.ParseRenewalWindow(flags.renewBefore) // validated by validateRenewFlags1
+	if !window.NeedsRenewal(oldCert, time.Now()) {
+		return &exitError{
+			code: exitCodeRenewalNotDue,
+			err:  fmt.Errorf("Renewal is not due: the certificate expires on %s, outside of the %s renewal window", oldCert.NotAfter, flags.renewBefore),
+		}
 	}
 	return nil
 }
 
+// readKeyToReuse reads the private key of oldCert from --key-file, provided that the zone policy allows key reuse
+func readKeyToReuse(connector endpoint.Connector, oldCert *x509.Certificate) (crypto.Signer, error) {
+	policy, err := connector.ReadPolicyConfiguration()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the zone policy to check if key reuse is allowed: %w", err)
+	}
+	if !policy.AllowKeyReuse {
+		return nil, fmt.Errorf("The policy of the zone does not allow key reuse")
+	}
+
+	key, err := readPrivateKeyFile(flags.keyFile, flags.keyPassword)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the private key to reuse: %w", err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	oldPub, err := x509.MarshalPKIXPublicKey(oldCert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pub, oldPub) {
+		return nil, fmt.Errorf("The private key in %s does not belong to the certificate to renew", flags.keyFile)
+	}
+	return key, nil
+}
+
 func generateCsrForCommandGenCsr(cf *commandFlags, privateKeyPass []byte) (privateKey []byte, csr []byte, err error) {
 	certReq := &certificate.Request{}
 	if cf.keyType != nil {
@@ -798,7 +988,10 @@ func generateCsrForCommandGenCsr(cf *commandFlags, privateKeyPass []byte) (priva
 		}
 		privateKey = pem.EncodeToMemory(pBlock)
 	}
-	certReq = fillCertificateRequest(certReq, cf)
+	certReq, err = fillCertificateRequest(certReq, cf)
+	if err != nil {
+		return
+	}
 	err = certReq.GenerateCSR()
 	if err != nil {
 		return
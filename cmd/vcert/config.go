@@ -20,16 +20,20 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"strconv"
 	"time"
 
 	"github.com/urfave/cli/v2"
 
 	"github.com/Venafi/vcert/v4"
+	"github.com/Venafi/vcert/v4/pkg/credentials"
 	"github.com/Venafi/vcert/v4/pkg/endpoint"
 )
 
 func buildConfig(c *cli.Context, flags *commandFlags) (cfg vcert.Config, err error) {
 	cfg.LogVerbose = flags.verbose
+	// saved are the TPP tokens saved by getcred --save, used when no other credentials are given
+	var saved *credentials.SavedCredential
 
 	if flags.config != "" {
 		// Loading configuration from file
@@ -93,6 +97,10 @@ func buildConfig(c *cli.Context, flags *commandFlags) (cfg vcert.Config, err err
 					}
 				}
 			}
+		} else if saved = lookupSavedCredential(flags); saved != nil {
+			logf("Using saved credentials for %s", saved.URL)
+			connectorType = endpoint.ConnectorTypeTPP
+			baseURL = credURL(flags)
 		} else {
 			apiKey := flags.apiKey
 			if apiKey == "" {
@@ -133,6 +141,20 @@ func buildConfig(c *cli.Context, flags *commandFlags) (cfg vcert.Config, err err
 		}
 	}
 
+	if flags.clientP12 != "" {
+		err = loadClientPKCS12(&cfg, flags)
+		if err != nil {
+			return cfg, err
+		}
+	}
+	cfg.InsecureSkipVerify = flags.insecure
+	if flags.trace || traceFromEnvironment() {
+		cfg.Trace = logger.Writer()
+	}
+	cfg.Logger = endpoint.NewStdLogger(logger, flags.verbose)
+	// TPP servers that require client certificates for some endpoints only ask for them by renegotiating
+	cfg.TLSRenegotiation = cfg.ConnectorType == endpoint.ConnectorTypeTPP
+
 	// zone may be overridden by CLI flag
 	if flags.zone != "" {
 		if cfg.Zone != "" {
@@ -146,6 +168,14 @@ func buildConfig(c *cli.Context, flags *commandFlags) (cfg vcert.Config, err err
 		cfg.Zone = zone
 	}
 
+	if saved != nil {
+		if c.Command.Name == commandGetCredName {
+			cfg.Credentials.RefreshToken = saved.RefreshToken
+		} else {
+			useSavedCredential(&cfg, saved)
+		}
+	}
+
 	if c.Command.Name == commandEnrollName || c.Command.Name == commandPickupName {
 		if cfg.Zone == "" && cfg.ConnectorType != endpoint.ConnectorTypeFake && !(flags.pickupID != "" || flags.pickupIDFile != "") {
 			return cfg, fmt.Errorf("Zone cannot be empty. Use -z option")
@@ -154,3 +184,13 @@ func buildConfig(c *cli.Context, flags *commandFlags) (cfg vcert.Config, err err
 
 	return cfg, nil
 }
+
+// traceFromEnvironment reports whether VCERT_TRACE enables tracing. Any value but false or 0 does.
+func traceFromEnvironment() bool {
+	value := getPropertyFromEnvironment(vCertTrace)
+	if value == "" {
+		return false
+	}
+	enabled, err := strconv.ParseBool(value)
+	return err != nil || enabled
+}
@@ -0,0 +1,319 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pavel-v-chernykh/keystore-go/v4"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/pkcs12"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+)
+
+var jksMagic = []byte{0xfe, 0xed, 0xfe, 0xed}
+
+// bundle is a private key, if any, and certificates read from local files by the convert command
+type bundle struct {
+	key          crypto.Signer
+	certs        []*x509.Certificate
+	friendlyName string
+}
+
+func doCommandConvert(c *cli.Context) error {
+	err := validateConvertFlags(c.Command.Name)
+	if err != nil {
+		return err
+	}
+
+	b, err := readBundle(flags.inFile, flags.inKeyFile, flags.inPassword, flags.inKeyPassword)
+	if err != nil {
+		return err
+	}
+	chainOption := certificate.ChainOptionFromString(flags.chainOption)
+	pcc, err := b.pemCollection(chainOption, flags.keyPassword)
+	if err != nil {
+		return err
+	}
+
+	friendlyName := flags.friendlyName
+	if friendlyName == "" {
+		friendlyName = b.friendlyName
+	}
+	resultCfg := resultConfig(&flags, c.Command.Name)
+	resultCfg.FriendlyName = friendlyName
+	resultCfg.ChainOption = chainOption
+	result := &Result{
+		Pcc:    pcc,
+		Config: resultCfg,
+	}
+	err = result.Flush()
+	if err != nil {
+		return fmt.Errorf("Failed to output the results: %s", err)
+	}
+	return nil
+}
+
+// readBundle reads fileName in any of the formats vcert writes, and the private key from keyFileName if given
+func readBundle(fileName, keyFileName, password, keyPassword string) (*bundle, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %s", fileName, err)
+	}
+	b, err := parseBundle(data, password, keyPassword)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %s", fileName, err)
+	}
+	if keyFileName != "" {
+		if b.key != nil {
+			return nil, fmt.Errorf("%s already contains a private key, so --in-key-file cannot be used", fileName)
+		}
+		b.key, err = readPrivateKeyFile(keyFileName, keyPassword)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s: %s", keyFileName, err)
+		}
+	}
+	if len(b.certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in %s", fileName)
+	}
+	return b, nil
+}
+
+func parseBundle(data []byte, password, keyPassword string) (*bundle, error) {
+	if bytes.HasPrefix(data, jksMagic) {
+		return parseJKSBundle(data, password, keyPassword)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		certs, err := parseCertificates(data)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parsePrivateKeyPEM(data, keyPassword)
+		if err != nil {
+			return nil, err
+		}
+		return &bundle{key: key, certs: certs}, nil
+	}
+	if isPKCS7(data) {
+		certs, err := parsePKCS7(data)
+		if err != nil {
+			return nil, err
+		}
+		return &bundle{certs: certs}, nil
+	}
+	if certs, err := x509.ParseCertificates(data); err == nil {
+		return &bundle{certs: certs}, nil
+	}
+	return parsePKCS12Bundle(data, password)
+}
+
+func parsePKCS12Bundle(data []byte, password string) (*bundle, error) {
+	p12, err := decodePKCS12(data, password)
+	if err != nil {
+		// OpenSSL 1.x encrypts the certificates with RC2, which only the x/crypto decoder supports
+		if blocks, legacyErr := pkcs12.ToPEM(data, password); legacyErr == nil {
+			return pemBlocksBundle(blocks)
+		}
+		return nil, err
+	}
+	b := &bundle{certs: p12.certs, friendlyName: p12.friendlyName}
+	if p12.key != nil {
+		signer, ok := p12.key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", p12.key)
+		}
+		b.key = signer
+	}
+	return b, nil
+}
+
+// pemBlocksBundle collects the blocks of pkcs12.ToPEM, whose PRIVATE KEY blocks hold PKCS#1 or SEC 1 keys
+func pemBlocksBundle(blocks []*pem.Block) (*bundle, error) {
+	b := &bundle{}
+	for _, block := range blocks {
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			b.certs = append(b.certs, cert)
+			if b.friendlyName == "" {
+				b.friendlyName = block.Headers["friendlyName"]
+			}
+		case "PRIVATE KEY":
+			if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+				b.key = key
+			} else if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+				b.key = key
+			} else {
+				return nil, fmt.Errorf("failed to parse private key: %s", err)
+			}
+		}
+	}
+	return b, nil
+}
+
+// parseJKSBundle reads the private key entry of a JKS file along with its chain, or all its trusted certificates
+func parseJKSBundle(data []byte, password, keyPassword string) (*bundle, error) {
+	keyStore := keystore.New()
+	if err := keyStore.Load(bytes.NewReader(data), []byte(password)); err != nil {
+		return nil, fmt.Errorf("JKS keystore error: %s", err)
+	}
+	b := &bundle{}
+	var trusted []*x509.Certificate
+	for _, alias := range keyStore.Aliases() {
+		if keyStore.IsPrivateKeyEntry(alias) {
+			if b.key != nil {
+				return nil, fmt.Errorf("JKS files with more than one private key are not supported")
+			}
+			entry, err := keyStore.GetPrivateKeyEntry(alias, []byte(keyPassword))
+			if err != nil {
+				return nil, fmt.Errorf("JKS private key error: %s", err)
+			}
+			key, err := x509.ParsePKCS8PrivateKey(entry.PrivateKey)
+			if err != nil {
+				return nil, fmt.Errorf("JKS private key error: %s", err)
+			}
+			signer, ok := key.(crypto.Signer)
+			if !ok {
+				return nil, fmt.Errorf("unsupported private key type %T", key)
+			}
+			b.key = signer
+			b.friendlyName = alias
+			for _, c := range entry.CertificateChain {
+				cert, err := x509.ParseCertificate(c.Content)
+				if err != nil {
+					return nil, fmt.Errorf("JKS certificate error: %s", err)
+				}
+				b.certs = append(b.certs, cert)
+			}
+		} else if keyStore.IsTrustedCertificateEntry(alias) {
+			entry, err := keyStore.GetTrustedCertificateEntry(alias)
+			if err != nil {
+				return nil, fmt.Errorf("JKS certificate error: %s", err)
+			}
+			cert, err := x509.ParseCertificate(entry.Certificate.Content)
+			if err != nil {
+				return nil, fmt.Errorf("JKS certificate error: %s", err)
+			}
+			trusted = append(trusted, cert)
+		}
+	}
+	// the trusted certificates of a key store are not related to its key
+	if b.key == nil {
+		b.certs = trusted
+	}
+	return b, nil
+}
+
+// pemCollection returns the bundle the way a connector would: the certificate of the key, or else the one
+// that did not issue any of the others, and its issuers ordered by chainOption. Unrelated certificates
+// follow the issuers. The private key is encrypted with keyPassword unless it is empty.
+func (b *bundle) pemCollection(chainOption certificate.ChainOption, keyPassword string) (*certificate.PEMCollection, error) {
+	leaf, err := b.leafIndex()
+	if err != nil {
+		return nil, err
+	}
+	certs := b.orderedCertificates(leaf)
+	chain := certs[1:]
+
+	pcc := &certificate.PEMCollection{
+		Certificate: string(pem.EncodeToMemory(certificate.GetCertificatePEMBlock(certs[0].Raw))),
+	}
+	switch chainOption {
+	case certificate.ChainOptionIgnore:
+	case certificate.ChainOptionRootFirst:
+		for i := len(chain) - 1; i >= 0; i-- {
+			pcc.Chain = append(pcc.Chain, string(pem.EncodeToMemory(certificate.GetCertificatePEMBlock(chain[i].Raw))))
+		}
+	default:
+		for _, cert := range chain {
+			pcc.Chain = append(pcc.Chain, string(pem.EncodeToMemory(certificate.GetCertificatePEMBlock(cert.Raw))))
+		}
+	}
+	if b.key != nil {
+		if err := pcc.AddPrivateKey(b.key, []byte(keyPassword)); err != nil {
+			return nil, err
+		}
+	}
+	return pcc, nil
+}
+
+// orderedCertificates returns the certificate at leaf followed by its issuers, and then the unrelated certificates
+func (b *bundle) orderedCertificates(leaf int) []*x509.Certificate {
+	used := make([]bool, len(b.certs))
+	used[leaf] = true
+	certs := []*x509.Certificate{b.certs[leaf]}
+	for current := b.certs[leaf]; ; {
+		issuer := -1
+		for i, cert := range b.certs {
+			if !used[i] && bytes.Equal(cert.RawSubject, current.RawIssuer) {
+				issuer = i
+				break
+			}
+		}
+		if issuer < 0 {
+			break
+		}
+		used[issuer] = true
+		current = b.certs[issuer]
+		certs = append(certs, current)
+	}
+	for i, cert := range b.certs {
+		if !used[i] {
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
+
+func (b *bundle) leafIndex() (int, error) {
+	if b.key != nil {
+		for i, cert := range b.certs {
+			if publicKeysEqual(b.key.Public(), cert.PublicKey) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("the private key does not match any of the certificates")
+	}
+	for i, cert := range b.certs {
+		issuer := false
+		for j, other := range b.certs {
+			issuer = issuer || (i != j && bytes.Equal(other.RawIssuer, cert.RawSubject))
+		}
+		if !issuer {
+			return i, nil
+		}
+	}
+	return 0, nil
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	aDER, err := x509.MarshalPKIXPublicKey(a)
+	if err != nil {
+		return false
+	}
+	bDER, err := x509.MarshalPKIXPublicKey(b)
+	return err == nil && bytes.Equal(aDER, bDER)
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+)
+
+// newTestChain returns a key with its certificate, issued by an intermediate CA, issued by a root CA
+func newTestChain(t *testing.T) (*ecdsa.PrivateKey, []*x509.Certificate) {
+	var certs []*x509.Certificate
+	var parent *x509.Certificate
+	var parentKey *ecdsa.PrivateKey
+	for i, cn := range []string{"Root CA", "Intermediate CA", "web.example.com"} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(int64(i + 1)),
+			Subject:               pkix.Name{CommonName: cn},
+			NotBefore:             time.Now(),
+			NotAfter:              time.Now().Add(time.Hour),
+			BasicConstraintsValid: true,
+			IsCA:                  i < 2,
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append([]*x509.Certificate{cert}, certs...)
+		parent, parentKey = cert, key
+	}
+	return parentKey, certs
+}
+
+func certPEM(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(certificate.GetCertificatePEMBlock(cert.Raw)))
+}
+
+func TestBundlePEMCollection(t *testing.T) {
+	key, certs := newTestChain(t)
+	leaf, intermediate, root := certs[0], certs[1], certs[2]
+
+	cases := []struct {
+		name        string
+		bundle      *bundle
+		chainOption certificate.ChainOption
+		chain       []*x509.Certificate
+	}{
+		{"root first in the file", &bundle{key: key, certs: []*x509.Certificate{root, leaf, intermediate}}, certificate.ChainOptionRootLast, []*x509.Certificate{intermediate, root}},
+		{"root-first chain", &bundle{key: key, certs: certs}, certificate.ChainOptionRootFirst, []*x509.Certificate{root, intermediate}},
+		{"ignored chain", &bundle{key: key, certs: certs}, certificate.ChainOptionIgnore, nil},
+		{"without key", &bundle{certs: []*x509.Certificate{intermediate, root, leaf}}, certificate.ChainOptionRootLast, []*x509.Certificate{intermediate, root}},
+	}
+	for _, c := range cases {
+		pcc, err := c.bundle.pemCollection(c.chainOption, "")
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if pcc.Certificate != certPEM(leaf) {
+			t.Fatalf("%s: unexpected certificate", c.name)
+		}
+		if len(pcc.Chain) != len(c.chain) {
+			t.Fatalf("%s: expected %d chain certificates, got %d", c.name, len(c.chain), len(pcc.Chain))
+		}
+		for i := range c.chain {
+			if pcc.Chain[i] != certPEM(c.chain[i]) {
+				t.Fatalf("%s: unexpected chain certificate %d", c.name, i)
+			}
+		}
+		if (c.bundle.key != nil) != (pcc.PrivateKey != "") {
+			t.Fatalf("%s: unexpected private key %q", c.name, pcc.PrivateKey)
+		}
+	}
+
+	other, _ := newTestChain(t)
+	if _, err := (&bundle{key: other, certs: certs}).pemCollection(certificate.ChainOptionRootLast, ""); err == nil {
+		t.Fatal("a key that matches none of the certificates should be rejected")
+	}
+}
+
+func TestParseBundle(t *testing.T) {
+	key, certs := newTestChain(t)
+	pcc, err := (&bundle{key: key, certs: certs}).pemCollection(certificate.ChainOptionRootLast, "keypass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := &Output{Certificate: pcc.Certificate, PrivateKey: pcc.PrivateKey, Chain: pcc.Chain}
+
+	pemData, err := output.Format(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p12, err := output.AsPKCS12(&Config{KeyPassword: "keypass", FriendlyName: "web", PKCS12Profile: PKCS12ProfileModern})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jks, err := output.AsJKS(&Config{KeyPassword: "keypass", JKSAlias: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := output.AsPKCS7(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name         string
+		data         []byte
+		certs        int
+		key          bool
+		friendlyName string
+	}{
+		{"pem", pemData, 3, true, ""},
+		{"pkcs12", p12, 3, true, "web"},
+		{"jks", jks, 3, true, "web"},
+		{"pkcs7", p7, 3, false, ""},
+		{"der", certs[0].Raw, 1, false, ""},
+	}
+	for _, c := range cases {
+		b, err := parseBundle(c.data, "keypass", "keypass")
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if len(b.certs) != c.certs || !b.certs[0].Equal(certs[0]) {
+			t.Fatalf("%s: expected %d certificates starting with the leaf, got %d", c.name, c.certs, len(b.certs))
+		}
+		if (b.key != nil) != c.key || b.friendlyName != c.friendlyName {
+			t.Fatalf("%s: unexpected key %v or friendly name %q", c.name, b.key != nil, b.friendlyName)
+		}
+	}
+
+	if _, err := parseBundle(p12, "wrong", "wrong"); err == nil || !strings.Contains(err.Error(), "MAC") {
+		t.Fatalf("a wrong PKCS#12 password should fail the MAC verification, got %v", err)
+	}
+}
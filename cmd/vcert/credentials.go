@@ -0,0 +1,121 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"time"
+
+	"github.com/Venafi/vcert/v4"
+	"github.com/Venafi/vcert/v4/pkg/credentials"
+)
+
+func credClientID(cf *commandFlags) string {
+	if cf.clientId != "" {
+		return cf.clientId
+	}
+	return credentials.DefaultClientID
+}
+
+func credURL(cf *commandFlags) string {
+	if cf.url != "" {
+		return cf.url
+	}
+	return getPropertyFromEnvironment(vCertURL)
+}
+
+// lookupSavedCredential returns the credential saved for the URL and client ID of the command, if any.
+// Commands given a Venafi Cloud API key don't use saved credentials. A cache that can not be read is
+// treated as empty, the command then fails asking for credentials.
+func lookupSavedCredential(cf *commandFlags) *credentials.SavedCredential {
+	if cf.apiKey != "" || getPropertyFromEnvironment(vCertApiKey) != "" {
+		return nil
+	}
+	url := credURL(cf)
+	if url == "" {
+		return nil
+	}
+	cache, err := credentials.LoadCache()
+	if err != nil {
+		logf("Ignoring saved credentials: %s", err)
+		return nil
+	}
+	return cache.Find(url, credClientID(cf))
+}
+
+// usesSavedCredential reports whether the command got no credentials and so runs with the saved ones
+func usesSavedCredential(cf *commandFlags) bool {
+	return cf.tppToken == "" && getPropertyFromEnvironment(vCertToken) == "" && cf.tppUser == "" && cf.clientP12 == ""
+}
+
+// saveCredential stores the tokens returned by getcred in the credential cache
+func saveCredential(cf *commandFlags, accessToken string, expires int, refreshToken string) error {
+	cache, err := credentials.LockCache()
+	if err != nil {
+		return err
+	}
+	defer cache.Unlock()
+	cred := newSavedCredential(credURL(cf), credClientID(cf), accessToken, expires, refreshToken)
+	cache.Put(cred)
+	err = cache.Save()
+	if err != nil {
+		return err
+	}
+	logf("Saved credentials for %s to %s", credentials.NormalizeURL(cred.URL), cache.Path())
+	return nil
+}
+
+// removeSavedCredential deletes the tokens saved for the URL and client ID of the command if their access token
+// is accessToken, whose grant voidcred revoked
+func removeSavedCredential(cf *commandFlags, accessToken string) error {
+	cache, err := credentials.LockCache()
+	if err != nil {
+		return err
+	}
+	defer cache.Unlock()
+	saved := cache.Find(credURL(cf), credClientID(cf))
+	if saved == nil || saved.AccessToken != accessToken {
+		return nil
+	}
+	cache.Remove(saved.URL, saved.ClientID)
+	err = cache.Save()
+	if err != nil {
+		return err
+	}
+	logf("Removed saved credentials for %s", credentials.NormalizeURL(credURL(cf)))
+	return nil
+}
+
+func newSavedCredential(url, clientID, accessToken string, expires int, refreshToken string) credentials.SavedCredential {
+	cred := credentials.SavedCredential{
+		URL:          url,
+		ClientID:     clientID,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}
+	if expires > 0 {
+		cred.AccessTokenExpires = time.Unix(int64(expires), 0).UTC()
+	}
+	return cred
+}
+
+// useSavedCredential authenticates the connectors created with cfg with cred. They read the saved tokens when they
+// are created and refresh them as needed, saving the new ones, so that they keep working in long runs such as the agent.
+func useSavedCredential(cfg *vcert.Config, cred *credentials.SavedCredential) {
+	provider := credentials.Saved{URL: cred.URL, ClientID: cred.ClientID}
+	cfg.CredentialProvider = provider
+	cfg.OnTokenRefresh = provider.OnTokenRefresh
+}
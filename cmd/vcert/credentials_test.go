@@ -0,0 +1,154 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/Venafi/vcert/v4"
+	"github.com/Venafi/vcert/v4/pkg/credentials"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/Venafi/vcert/v4/pkg/venafi/tpp"
+)
+
+// useTempConfigDir points the user configuration directory, and so the credential cache, to a temporary directory
+// until the returned function is called
+func useTempConfigDir(t *testing.T) func() {
+	if runtime.GOOS != "linux" {
+		t.Skip("the configuration directory can only be redirected with XDG_CONFIG_HOME")
+	}
+	dir, err := ioutil.TempDir("", "vcertCredentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, had := os.LookupEnv("XDG_CONFIG_HOME")
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	return func() {
+		if had {
+			os.Setenv("XDG_CONFIG_HOME", previous)
+		} else {
+			os.Unsetenv("XDG_CONFIG_HOME")
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCredentialCache(t *testing.T) {
+	defer useTempConfigDir(t)()
+	cf := &commandFlags{url: "tpp.example.com"}
+
+	if lookupSavedCredential(cf) != nil {
+		t.Fatal("expected no saved credential in an empty cache")
+	}
+	if err := saveCredential(cf, "access-1", int(time.Now().Add(time.Hour).Unix()), "refresh-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveCredential(&commandFlags{url: "tpp.example.com", clientId: "other"}, "access-other", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveCredential(cf, "access-2", int(time.Now().Add(time.Hour).Unix()), "refresh-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := credentials.CachePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected the credential cache to be readable only by the user, got %s", info.Mode())
+	}
+
+	saved := lookupSavedCredential(&commandFlags{url: "https://tpp.example.com/vedsdk"})
+	if saved == nil || saved.AccessToken != "access-2" || saved.RefreshToken != "refresh-2" || saved.Expired() {
+		t.Fatalf("expected the last saved credential, got %+v", saved)
+	}
+	if lookupSavedCredential(&commandFlags{url: "tpp.example.com", apiKey: "key"}) != nil {
+		t.Fatal("commands with an API key should not use saved credentials")
+	}
+
+	if err = removeSavedCredential(cf, "access-1"); err != nil {
+		t.Fatal(err)
+	}
+	if lookupSavedCredential(cf) == nil {
+		t.Fatal("credential should only be removed when its access token was revoked")
+	}
+	if err = removeSavedCredential(cf, "access-2"); err != nil {
+		t.Fatal(err)
+	}
+	if lookupSavedCredential(cf) != nil {
+		t.Fatal("expected the credential to be removed")
+	}
+	if lookupSavedCredential(&commandFlags{url: "tpp.example.com", clientId: "other"}) == nil {
+		t.Fatal("credentials of other client IDs should be kept")
+	}
+}
+
+func TestUseSavedCredential(t *testing.T) {
+	defer useTempConfigDir(t)()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+			ClientID     string `json:"client_id"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/vedauth/authorize/token" || req.RefreshToken != "refresh-1" || req.ClientID != credentials.DefaultClientID {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(tpp.OauthRefreshAccessTokenResponse{
+			Access_token:  "access-2",
+			Refresh_token: "refresh-2",
+			Expires:       int(time.Now().Add(time.Hour).Unix()),
+		})
+	}))
+	defer server.Close()
+
+	cf := &commandFlags{url: server.URL}
+	if err := saveCredential(cf, "access-1", int(time.Now().Add(-time.Hour).Unix()), "refresh-1"); err != nil {
+		t.Fatal(err)
+	}
+	saved := lookupSavedCredential(cf)
+	if saved == nil || !saved.Expired() {
+		t.Fatalf("expected an expired saved credential, got %+v", saved)
+	}
+
+	cfg := &vcert.Config{
+		ConnectorType:   endpoint.ConnectorTypeTPP,
+		BaseUrl:         server.URL,
+		ConnectionTrust: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})),
+	}
+	useSavedCredential(cfg, saved)
+	if _, err := vcert.NewClient(cfg); err != nil {
+		t.Fatal(err)
+	}
+	saved = lookupSavedCredential(cf)
+	if saved == nil || saved.AccessToken != "access-2" || saved.RefreshToken != "refresh-2" || saved.Expired() {
+		t.Fatalf("expected the refreshed tokens to be saved, got %+v", saved)
+	}
+}
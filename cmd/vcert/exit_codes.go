@@ -0,0 +1,132 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/Venafi/vcert/v4/pkg/verror"
+)
+
+// Exit codes of vcert. Scripts rely on them, so existing values must never change.
+const (
+	exitCodeGeneral           = 1
+	exitCodeUserData          = 2
+	exitCodeRenewalNotDue     = 3 // renew --renew-before when the certificate is not yet in its renewal window
+	exitCodeAuth              = 4
+	exitCodePolicy            = 5
+	exitCodeNotFound          = 6
+	exitCodeServer            = 7
+	exitCodeServerUnavailable = 8
+	exitCodePending           = 9
+	exitCodeTimeout           = 10
+	exitCodePostHook          = 11
+)
+
+// exitCategories are the categories printed with the exit codes, the verror ones and those of vcert itself
+var exitCategories = map[int]string{
+	exitCodeGeneral:           verror.CategoryGeneral,
+	exitCodeUserData:          verror.CategoryUserData,
+	exitCodeRenewalNotDue:     "renewal_not_due",
+	exitCodeAuth:              verror.CategoryAuth,
+	exitCodePolicy:            verror.CategoryPolicy,
+	exitCodeNotFound:          verror.CategoryNotFound,
+	exitCodeServer:            verror.CategoryServer,
+	exitCodeServerUnavailable: verror.CategoryServerUnavailable,
+	exitCodePending:           verror.CategoryPending,
+	exitCodeTimeout:           verror.CategoryTimeout,
+	exitCodePostHook:          "post_hook",
+}
+
+// categoryExitCodes maps the verror categories to exit codes. Categories without their own code, such as network
+// errors, exit with exitCodeGeneral.
+var categoryExitCodes = map[string]int{
+	verror.CategoryPending:           exitCodePending,
+	verror.CategoryTimeout:           exitCodeTimeout,
+	verror.CategoryAuth:              exitCodeAuth,
+	verror.CategoryNotFound:          exitCodeNotFound,
+	verror.CategoryPolicy:            exitCodePolicy,
+	verror.CategoryUserData:          exitCodeUserData,
+	verror.CategoryServerUnavailable: exitCodeServerUnavailable,
+	verror.CategoryServer:            exitCodeServer,
+}
+
+const (
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+)
+
+// exitError makes vcert exit with code instead of the one exitCode would pick for err
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// exitCode maps err to the exit code of vcert by its verror.Category
+func exitCode(err error) int {
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	if code, ok := categoryExitCodes[verror.Category(err)]; ok {
+		return code
+	}
+	return exitCodeGeneral
+}
+
+// errorPickupID returns the Pickup ID of the request err is about, if known
+func errorPickupID(err error) string {
+	var pending endpoint.ErrCertificatePending
+	if errors.As(err, &pending) {
+		return pending.CertificateID
+	}
+	var timeout endpoint.ErrRetrieveCertificateTimeout
+	if errors.As(err, &timeout) {
+		return timeout.CertificateID
+	}
+	return flags.pickupID
+}
+
+type jsonError struct {
+	Code     int    `json:"code"`
+	Category string `json:"category"`
+	Message  string `json:"message"`
+	PickupID string `json:"pickupId,omitempty"`
+}
+
+// writeJSONError prints err to w as a single line of JSON
+func writeJSONError(w io.Writer, err error, code int) {
+	b, _ := json.Marshal(jsonError{
+		Code:     code,
+		Category: exitCategories[code],
+		Message:  err.Error(),
+		PickupID: errorPickupID(err),
+	})
+	fmt.Fprintf(w, "%s\n", b)
+}
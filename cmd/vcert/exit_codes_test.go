@@ -0,0 +1,79 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/Venafi/vcert/v4/pkg/verror"
+)
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{fmt.Errorf("something went wrong"), exitCodeGeneral},
+		{fmt.Errorf("Unable to connect to TPP: %w", &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}), exitCodeGeneral},
+		{fmt.Errorf("%w: bad subject", verror.UserDataError), exitCodeUserData},
+		{fmt.Errorf("%w: certificate mismatch", verror.CertificateCheckError), exitCodeUserData},
+		{&exitError{code: exitCodeRenewalNotDue, err: fmt.Errorf("not due")}, exitCodeRenewalNotDue},
+		{fmt.Errorf("Unable to connect to TPP: %w", fmt.Errorf("%w: 401", verror.AuthError)), exitCodeAuth},
+		{fmt.Errorf("%w: Default", verror.ZoneNotFoundError), exitCodeNotFound},
+		{fmt.Errorf("%w: App", verror.ApplicationNotFoundError), exitCodeNotFound},
+		{fmt.Errorf("%w: key size", verror.PolicyValidationError), exitCodePolicy},
+		{fmt.Errorf("%w: 500", verror.ServerError), exitCodeServer},
+		{fmt.Errorf("%w: 400", verror.ServerBadDataResponce), exitCodeServer},
+		{fmt.Errorf("%w: 503", verror.ServerTemporaryUnavailableError), exitCodeServerUnavailable},
+		{fmt.Errorf("Failed to retrieve certificate: %w", endpoint.ErrCertificatePending{CertificateID: "id"}), exitCodePending},
+		{endpoint.ErrRetrieveCertificateTimeout{CertificateID: "id"}, exitCodeTimeout},
+		{&exitError{code: exitCodePostHook, err: fmt.Errorf("post-hook failed")}, exitCodePostHook},
+	}
+	for _, c := range cases {
+		if code := exitCode(c.err); code != c.code {
+			t.Errorf("%q: expected exit code %d, got %d", c.err, c.code, code)
+		}
+		if exitCategories[c.code] == "" {
+			t.Errorf("exit code %d has no category", c.code)
+		}
+	}
+}
+
+func TestWriteJSONError(t *testing.T) {
+	var buf bytes.Buffer
+	err := fmt.Errorf("Failed to retrieve certificate: %w", endpoint.ErrRetrieveCertificateTimeout{CertificateID: `\VED\Policy\Certificates\web`})
+	writeJSONError(&buf, err, exitCode(err))
+
+	var out jsonError
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("expected JSON, got %q: %s", buf.String(), err)
+	}
+	expected := jsonError{
+		Code:     exitCodeTimeout,
+		Category: "timeout",
+		Message:  err.Error(),
+		PickupID: `\VED\Policy\Certificates\web`,
+	}
+	if out != expected {
+		t.Fatalf("expected %+v, got %+v", expected, out)
+	}
+}
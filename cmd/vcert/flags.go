@@ -19,8 +19,11 @@ package main
 import (
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/urfave/cli/v2"
+
+	"github.com/Venafi/vcert/v4/pkg/credentials"
 )
 
 var (
@@ -122,7 +125,7 @@ var (
 
 	flagFriendlyName = &cli.StringFlag{
 		Name:        "nickname",
-		Usage:       "Use to specify a name for the new certificate object that will be created and placed in a policy (which you can specify using the -z option).",
+		Usage:       "Use to specify a name for the new certificate object that will be created and placed in a policy (which you can specify using the -z option). It is also the friendly name of the entry in a PKCS#12 file.",
 		Destination: &flags.friendlyName,
 	}
 
@@ -196,8 +199,11 @@ var (
 
 	flagFormat = &cli.StringFlag{
 		Name: "format",
-		Usage: "Use to specify the output format. Options include: pem | json | pkcs12 | jks." +
+		Usage: "Use to specify the output format. Options include: pem | json | pkcs12 | jks | k8s-secret | der | pkcs7." +
 			" If PKCS#12 or JKS formats are specified, the --file parameter is required." +
+			" The k8s-secret format writes a kubernetes.io/tls Secret to --file or STDOUT (see --secret-name)." +
+			" The der and pkcs7 formats write the certificate to --cert-file, der without the chain unless --chain-file is given," +
+			" in which case every chain certificate is written to a numbered file, e.g. chain-1.cer. The private key remains PEM." +
 			" For JKS format, the --jks-alias parameter is required and a password must be provided (see --key-password and --jks-password).",
 		Destination: &flags.format,
 		Value:       "pem",
@@ -266,6 +272,13 @@ var (
 		Value:       false,
 	}
 
+	flagTrace = &cli.BoolFlag{
+		Name: "trace",
+		Usage: "Use to log the HTTP requests sent to Venafi and their responses, with credentials and private keys redacted. " +
+			"Can also be enabled with the VCERT_TRACE environment variable",
+		Destination: &flags.trace,
+	}
+
 	flagNoPrompt = &cli.BoolFlag{
 		Name: "no-prompt",
 		Usage: "Use to exclude credential and password prompts. If you enable the prompt and you enter incorrect information, " +
@@ -351,7 +364,7 @@ var (
 		Usage: "Use to specify INI configuration file containing connection details instead\n" +
 			"\t\tFor TPP: url, access_token, tpp_zone\n" +
 			"\t\tFor Cloud: cloud_apikey, cloud_zone\n" +
-			"\t\tTPP & Cloud: trust_bundle, test_mode",
+			"\t\tTPP & Cloud: trust_bundle, test_mode, timeout, dial_timeout, tls_handshake_timeout, proxy_url, no_proxy",
 		Destination: &flags.config,
 		TakesFile:   true,
 	}
@@ -454,7 +467,14 @@ var (
 		Name:        "client-id",
 		Usage:       "Use to specify the application that will be using the token.",
 		Destination: &flags.clientId,
-		Value:       "vcert-cli",
+		Value:       credentials.DefaultClientID,
+	}
+
+	flagSaveCredentials = &cli.BoolFlag{
+		Name: "save",
+		Usage: "Use to save the obtained tokens for the TPP URL and client ID, so that other commands can run without -t.\n" +
+			"\tExpired access tokens are refreshed with the saved refresh token. voidcred removes the saved tokens.",
+		Destination: &flags.saveCredentials,
 	}
 
 	flagCustomField = &cli.StringSliceFlag{
@@ -490,7 +510,181 @@ var (
 		Destination: &flags.validDays,
 	}
 
-	commonFlags              = []cli.Flag{flagInsecure, flagVerbose, flagNoPrompt}
+	flagManifest = &cli.StringFlag{
+		Name: "manifest",
+		Usage: "Use to enroll every certificate listed in a YAML manifest file instead of a single one. " +
+			"Each entry accepts the keys cn, nickname, san-*, key-*, fields, format and the *-file output options. " +
+			"Other options act as defaults for all entries. Example: --manifest /path-to/certs.yaml",
+		Destination: &flags.manifest,
+		TakesFile:   true,
+	}
+
+	flagConcurrency = &cli.IntFlag{
+		Name:        "concurrency",
+		Usage:       "Use to specify how many certificates of a --manifest are requested and picked up at the same time.",
+		Value:       4,
+		Destination: &flags.concurrency,
+	}
+
+	flagAgentManifest = &cli.StringFlag{
+		Name: "manifest",
+		Usage: "REQUIRED. Use to specify the YAML file listing the certificates to keep renewed. " +
+			"Entries accept the keys of an enroll --manifest plus zone, renew-before and post-hook, which takes precedence over --post-hook. " +
+			"Example: --manifest /path-to/agent.yaml",
+		Destination: &flags.manifest,
+		TakesFile:   true,
+	}
+
+	flagStateFile = &cli.StringFlag{
+		Name: "state-file",
+		Usage: "Use to specify the file where the agent keeps track of submitted requests between runs. " +
+			"Defaults to the --manifest file name with .state appended.",
+		Destination: &flags.stateFile,
+		TakesFile:   true,
+	}
+
+	flagInterval = &cli.DurationFlag{
+		Name:        "interval",
+		Usage:       "Use to specify how often the certificates are checked. Example: --interval 30m",
+		Value:       time.Hour,
+		Destination: &flags.interval,
+	}
+
+	flagOnce = &cli.BoolFlag{
+		Name:        "once",
+		Usage:       "Use to check the certificates one time and exit, e.g. when the agent is run from cron.",
+		Destination: &flags.once,
+	}
+
+	flagAgentRenewBefore = &cli.StringFlag{
+		Name: "renew-before",
+		Usage: "Use to specify how long before expiration a certificate is renewed, unless its manifest entry sets renew-before. " +
+			"Accepts days, a duration or a percentage of the certificate lifetime. Example: --renew-before 30d",
+		Value:       "30d",
+		Destination: &flags.renewBefore,
+	}
+
+	flagRenewBefore = &cli.StringFlag{
+		Name: "renew-before",
+		Usage: "Use to renew the certificate only when it expires within the specified period, given in days, as a duration or as a percentage " +
+			"of the certificate lifetime. Otherwise vcert exits with code 3 without renewing. Example: --renew-before 30d or --renew-before 20%",
+		Destination: &flags.renewBefore,
+	}
+
+	flagReuseKey = &cli.BoolFlag{
+		Name: "reuse-key",
+		Usage: "Use to request the renewed certificate for the existing private key read from --key-file instead of generating a new one. " +
+			"The policy of the zone specified with -z must allow key reuse.",
+		Destination: &flags.reuseKey,
+	}
+
+	flagRenewZone = &cli.StringFlag{
+		Name:        "z",
+		Destination: &flags.zone,
+		Usage:       "Use to specify the zone whose policy is checked before the private key is reused with --reuse-key. Example: -z Corp\\Engineering",
+	}
+
+	flagBackup = &cli.BoolFlag{
+		Name:        "backup",
+		Usage:       "Use to keep the previous content of the output files, with .bak appended to their names, when they are replaced.",
+		Destination: &flags.backup,
+	}
+
+	flagFileMode = &cli.StringFlag{
+		Name:        "file-mode",
+		Usage:       "Use to specify the octal permissions of the output files. Example: --file-mode 0640 (default: 0600)",
+		Destination: &flags.fileModeString,
+	}
+
+	flagFileOwner = &cli.StringFlag{
+		Name:        "file-owner",
+		Usage:       "Use to specify the user name or ID that owns the output files. Example: --file-owner nginx",
+		Destination: &flags.fileOwner,
+	}
+
+	flagFileGroup = &cli.StringFlag{
+		Name:        "file-group",
+		Usage:       "Use to specify the group name or ID of the output files. Example: --file-group ssl-cert",
+		Destination: &flags.fileGroup,
+	}
+
+	flagSecretName = &cli.StringFlag{
+		Name:        "secret-name",
+		Usage:       "Use to specify the name of the Secret written with --format k8s-secret. Defaults to a name derived from the common name of the certificate.",
+		Destination: &flags.secret.Name,
+	}
+
+	flagSecretNamespace = &cli.StringFlag{
+		Name:        "secret-namespace",
+		Usage:       "Use to specify the namespace of the Secret written with --format k8s-secret.",
+		Destination: &flags.secret.Namespace,
+	}
+
+	flagSecretLabel = &cli.StringSliceFlag{
+		Name:  "secret-label",
+		Usage: "Use to add a label in format 'key=value' to the Secret written with --format k8s-secret. To specify more than one, repeat this parameter.",
+	}
+
+	flagSecretAnnotation = &cli.StringSliceFlag{
+		Name:  "secret-annotation",
+		Usage: "Use to add an annotation in format 'key=value' to the Secret written with --format k8s-secret. To specify more than one, repeat this parameter.",
+	}
+
+	flagSecretOutput = &cli.StringFlag{
+		Name:        "secret-output",
+		Usage:       "Use to specify whether the Secret written with --format k8s-secret is encoded as yaml or json.",
+		Value:       "yaml",
+		Destination: &flags.secret.Output,
+	}
+
+	flagPKCS12Profile = &cli.StringFlag{
+		Name: "pkcs12-profile",
+		Usage: "Use to specify the algorithms of PKCS#12 files. Options: legacy (3DES and SHA-1, for old Java and Windows versions), " +
+			"modern (AES-256, PBKDF2 and SHA-256). Example: --pkcs12-profile modern (default: legacy)",
+		Destination: &flags.pkcs12Profile,
+	}
+
+	flagPKCS12TrustStore = &cli.BoolFlag{
+		Name:        "pkcs12-truststore",
+		Usage:       "Use to write the certificate and the chain to the --format pkcs12 file as trusted entries, without the private key.",
+		Destination: &flags.pkcs12TrustStore,
+	}
+
+	flagLayout = &cli.StringFlag{
+		Name: "layout",
+		Usage: "Use to write the files expected by a server to the --output-dir directory instead of --file, --cert-file, --chain-file and --key-file. " +
+			"Options: nginx (fullchain.pem, privkey.pem), haproxy (combined.pem), apache (cert.pem, chain.pem, privkey.pem), " +
+			"truststore (truststore.p12, or truststore.jks with --format jks, holding only the chain). Example: --layout nginx",
+		Destination: &flags.layout,
+	}
+
+	flagOutputDir = &cli.StringFlag{
+		Name:        "output-dir",
+		Usage:       "Use to specify the directory of the files written with --layout. Example: --output-dir /etc/nginx/ssl (default: current directory)",
+		Destination: &flags.outputDir,
+	}
+
+	flagPostHook = &cli.StringFlag{
+		Name: "post-hook",
+		Usage: "Use to specify a command that is run by the shell after the certificate has been written, e.g. to reload a service. " +
+			"The command receives VCERT_CERT_FILE, VCERT_KEY_FILE, VCERT_CHAIN_FILE, VCERT_FILE, VCERT_CN, VCERT_SERIAL, " +
+			"VCERT_THUMBPRINT, VCERT_NOT_AFTER and VCERT_PICKUP_ID in its environment. A failing command makes vcert exit with an error. " +
+			"Example: --post-hook \"systemctl reload nginx\"",
+		Destination: &flags.postHook,
+	}
+
+	flagPostHookTimeout = &cli.DurationFlag{
+		Name:        "post-hook-timeout",
+		Usage:       "Use to specify how long the --post-hook command may run before it is stopped and considered failed. Example: --post-hook-timeout 30s",
+		Value:       5 * time.Minute,
+		Destination: &flags.postHookTimeout,
+	}
+
+	commonFlags              = []cli.Flag{flagInsecure, flagVerbose, flagTrace, flagNoPrompt, flagErrorFormat}
+	secretFlags              = []cli.Flag{flagSecretName, flagSecretNamespace, flagSecretLabel, flagSecretAnnotation, flagSecretOutput}
+	fileFlags                = []cli.Flag{flagBackup, flagFileMode, flagFileOwner, flagFileGroup}
+	layoutFlags              = []cli.Flag{flagLayout, flagOutputDir}
+	pkcs12Flags              = []cli.Flag{flagPKCS12Profile, flagPKCS12TrustStore}
 	keyFlags                 = []cli.Flag{flagKeyType, flagKeySize, flagKeyCurve, flagKeyFile, flagKeyPassword}
 	sansFlags                = []cli.Flag{flagDNSSans, flagEmailSans, flagIPSans, flagURISans, flagUPNSans}
 	subjectFlags             = flagsApppend(flagCommonName, flagCountry, flagState, flagLocality, flagOrg, flagOrgUnits)
@@ -516,6 +710,49 @@ var (
 		delimiter(" "),
 	}
 
+	flagInFile = &cli.StringFlag{
+		Name: "in",
+		Usage: "Use to specify the input file. PEM, DER, PKCS#7, PKCS#12 and JKS files are recognized by their content. " +
+			"Example: --in /path-to/bundle.p12",
+		Destination: &flags.inFile,
+		TakesFile:   true,
+	}
+
+	flagInKeyFile = &cli.StringFlag{
+		Name:        "in-key-file",
+		Usage:       "Use to specify a PEM private key file that belongs to the certificates or CSR of --in. Example: --in-key-file /path-to/key.pem",
+		Destination: &flags.inKeyFile,
+		TakesFile:   true,
+	}
+
+	flagInPassword = &cli.StringFlag{
+		Name: "in-password",
+		Usage: "Use to specify the password of the --in PKCS#12 or JKS file, which also decrypts its private key unless --in-key-password is given. " +
+			"Example: --in-password file:/path-to/passwd.txt",
+		Destination: &flags.inPassword,
+	}
+
+	flagInKeyPassword = &cli.StringFlag{
+		Name:        "in-key-password",
+		Usage:       "Use to specify the password of the input private key, if it differs from --in-password. Example: --in-key-password file:/path-to/passwd.txt",
+		Destination: &flags.inKeyPassword,
+	}
+
+	flagInspectFormat = &cli.StringFlag{
+		Name:        "format",
+		Usage:       "Use to print the inspected file in an alternate format. Options include: text | json",
+		Destination: &flags.inspectFormat,
+		Value:       "text",
+	}
+
+	flagErrorFormat = &cli.StringFlag{
+		Name: "error-format",
+		Usage: "Use to print errors to stderr in an alternate format. Options include: text | json. " +
+			"The json format prints an object with the code, category, message and pickupId of the error.",
+		Destination: &flags.errorFormat,
+		Value:       errorFormatText,
+	}
+
 	genCsrFlags = sortedFlags(flagsApppend(
 		subjectFlags,
 		sansFlags,
@@ -524,6 +761,7 @@ var (
 		flagNoPrompt,
 		flagVerbose,
 		flagCSRFormat,
+		flagErrorFormat,
 	))
 
 	enrollFlags = flagsApppend(
@@ -556,6 +794,14 @@ var (
 			flagReplace,
 			flagOmitSans,
 			flagValidDays,
+			flagManifest,
+			flagConcurrency,
+			flagPostHook,
+			flagPostHookTimeout,
+			fileFlags,
+			secretFlags,
+			layoutFlags,
+			pkcs12Flags,
 		)),
 	)
 
@@ -576,6 +822,12 @@ var (
 			flagPickupIDFile,
 			flagTimeout,
 			commonFlags,
+			flagPostHook,
+			flagPostHookTimeout,
+			fileFlags,
+			secretFlags,
+			layoutFlags,
+			pkcs12Flags,
 		)),
 	)
 
@@ -613,6 +865,73 @@ var (
 			sortableCredentialsFlags,
 			flagPickupIDFile,
 			flagOmitSans,
+			flagRenewBefore,
+			flagReuseKey,
+			flagRenewZone,
+			flagPostHook,
+			flagPostHookTimeout,
+			fileFlags,
+			secretFlags,
+			layoutFlags,
+			pkcs12Flags,
+		)),
+	)
+
+	agentFlags = flagsApppend(
+		flagAgentManifest,
+		flagZone,
+		credentialsFlags,
+		sortedFlags(flagsApppend(
+			sortableCredentialsFlags,
+			commonFlags,
+			flagAgentRenewBefore,
+			flagChainOption,
+			flagInterval,
+			flagKeyPassword,
+			flagOnce,
+			flagPostHook,
+			flagPostHookTimeout,
+			fileFlags,
+			flagStateFile,
+			flagTimeout,
+		)),
+	)
+
+	convertFlags = sortedFlags(flagsApppend(
+		flagInFile,
+		flagInKeyFile,
+		flagInPassword,
+		flagInKeyPassword,
+		flagCertFile,
+		flagChainFile,
+		flagChainOption,
+		flagFile,
+		flagFormat,
+		flagFriendlyName,
+		flagJKSAlias,
+		flagJKSPassword,
+		flagKeyFile,
+		flagKeyPassword,
+		flagNoPrompt,
+		flagVerbose,
+		flagErrorFormat,
+		fileFlags,
+		secretFlags,
+		layoutFlags,
+		pkcs12Flags,
+	))
+
+	inspectFlags = flagsApppend(
+		credentialsFlags,
+		sortedFlags(flagsApppend(
+			sortableCredentialsFlags,
+			flagInFile,
+			flagInKeyFile,
+			flagInPassword,
+			flagInKeyPassword,
+			flagInspectFormat,
+			flagZone,
+			commonFlags,
 		)),
 	)
 
@@ -627,17 +946,20 @@ var (
 		flagTPPUser,
 		flagScope,
 		flagClientId,
+		flagSaveCredentials,
 		commonFlags,
 	))
 
 	checkCredFlags = sortedFlags(flagsApppend(
 		commonCredFlags,
 		flagCredFormat,
+		flagClientId,
 		commonFlags,
 	))
 
 	voidCredFlags = sortedFlags(flagsApppend(
 		commonCredFlags,
+		flagClientId,
 		commonFlags,
 	))
 )
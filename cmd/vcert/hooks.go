@@ -0,0 +1,118 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// postHookEnv describes the output of a command to a post-hook. File variables are set to absolute paths,
+// or to an empty string when the corresponding file was not written.
+func postHookEnv(r *Result) []string {
+	env := []string{
+		"VCERT_COMMAND=" + r.Config.Command,
+		"VCERT_FORMAT=" + r.Config.Format,
+		"VCERT_PICKUP_ID=" + r.PickupId,
+		"VCERT_FILE=" + absPath(r.Config.AllFile),
+		"VCERT_CERT_FILE=" + absPath(r.Config.CertFile),
+		"VCERT_KEY_FILE=" + absPath(r.Config.KeyFile),
+		"VCERT_CHAIN_FILE=" + absPath(r.Config.ChainFile),
+	}
+
+	var serial, thumbprint, notAfter, commonName string
+	if r.Pcc != nil {
+		block, _ := pem.Decode([]byte(r.Pcc.Certificate))
+		if block != nil {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				serial = fmt.Sprintf("%X", cert.SerialNumber)
+				thumbprint = certificateThumbprint(cert)
+				notAfter = cert.NotAfter.UTC().Format(time.RFC3339)
+				commonName = cert.Subject.CommonName
+			}
+		}
+	}
+	return append(env,
+		"VCERT_CN="+commonName,
+		"VCERT_SERIAL="+serial,
+		"VCERT_THUMBPRINT="+thumbprint,
+		"VCERT_NOT_AFTER="+notAfter,
+	)
+}
+
+func absPath(fileName string) string {
+	if fileName == "" {
+		return ""
+	}
+	abs, err := filepath.Abs(fileName)
+	if err != nil {
+		return fileName
+	}
+	return abs
+}
+
+// runHook executes command with the system shell, adding env to the environment of the process.
+// The output of the command goes to STDERR, since STDOUT may carry the results of vcert.
+// A zero timeout lets the command run until it exits.
+func runHook(command string, env []string, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("post-hook %q did not finish within %s", command, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("post-hook %q failed: %s", command, err)
+	}
+	return nil
+}
+
+// runPostHook runs the --post-hook command, if any, after the files of result have been written
+func runPostHook(r *Result) error {
+	if flags.postHook == "" {
+		return nil
+	}
+	logf("Running post-hook %q", flags.postHook)
+	if err := runHook(flags.postHook, postHookEnv(r), flags.postHookTimeout); err != nil {
+		// the certificate is already written, so scripts must be able to tell this from a failed request
+		return &exitError{code: exitCodePostHook, err: err}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Venafi/vcert/v4"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+)
+
+func TestPostHookEnv(t *testing.T) {
+	conn, err := vcert.NewClient(&vcert.Config{ConnectorType: endpoint.ConnectorTypeFake})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &certificate.Request{Subject: pkix.Name{CommonName: "hook.example.com"}, CsrOrigin: certificate.LocalGeneratedCSR}
+	results := vcert.EnrollBatch(conn, []*certificate.Request{req}, vcert.BatchOptions{Concurrency: 1})
+	if results[0].Err != nil {
+		t.Fatal(results[0].Err)
+	}
+
+	result := &Result{
+		Pcc:      results[0].Certificates,
+		PickupId: results[0].PickupID,
+		Config:   &Config{Command: "enroll", Format: "pem", CertFile: "hook.crt"},
+	}
+	env := map[string]string{}
+	for _, kv := range postHookEnv(result) {
+		parts := strings.SplitN(kv, "=", 2)
+		env[parts[0]] = parts[1]
+	}
+	if env["VCERT_CN"] != "hook.example.com" || env["VCERT_PICKUP_ID"] != results[0].PickupID {
+		t.Fatalf("unexpected certificate variables: %v", env)
+	}
+	if env["VCERT_SERIAL"] == "" || len(env["VCERT_THUMBPRINT"]) != 40 {
+		t.Fatalf("serial and thumbprint should be set: %v", env)
+	}
+	if _, err := time.Parse(time.RFC3339, env["VCERT_NOT_AFTER"]); err != nil {
+		t.Fatalf("unexpected expiration date %q: %s", env["VCERT_NOT_AFTER"], err)
+	}
+	if !filepath.IsAbs(env["VCERT_CERT_FILE"]) || env["VCERT_KEY_FILE"] != "" {
+		t.Fatalf("unexpected file variables: %v", env)
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test commands require a POSIX shell")
+	}
+	dir, err := ioutil.TempDir("", "vcertHook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "hook.out")
+	err = runHook(`echo "$VCERT_SERIAL" > `+out, []string{"VCERT_SERIAL=0A1B"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "0A1B\n" {
+		t.Fatalf("environment was not passed to the hook, got %q", b)
+	}
+
+	err = runHook("exit 3", nil, time.Minute)
+	if err == nil {
+		t.Fatal("failing hook should return an error")
+	}
+
+	started := time.Now()
+	err = runHook("exec sleep 10", nil, 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "did not finish") {
+		t.Fatalf("hook should time out, got %v", err)
+	}
+	if time.Since(started) > 5*time.Second {
+		t.Fatal("hook was not stopped after its timeout")
+	}
+}
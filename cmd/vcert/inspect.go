@@ -0,0 +1,363 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/Venafi/vcert/v4"
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+)
+
+// inspection is what the inspect command found in a file, printed as text or with --format json
+type inspection struct {
+	Certificates []certificateInfo `json:"certificates,omitempty"`
+	CSR          *csrInfo          `json:"csr,omitempty"`
+	PrivateKey   *keyInfo          `json:"privateKey,omitempty"`
+	// KeyMatches tells whether the private key belongs to the first certificate, or else to the CSR
+	KeyMatches *bool `json:"keyMatches,omitempty"`
+	// Policy is the result of checking the CSR against the zone, if connection flags were given
+	Policy *policyCheck `json:"policy,omitempty"`
+
+	certs []*x509.Certificate
+	csr   *x509.CertificateRequest
+}
+
+type certificateInfo struct {
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serialNumber"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	IsCA         bool      `json:"isCA"`
+	sanInfo
+	Key keyInfo `json:"key"`
+	// SHA1Thumbprint is the form that --thumbprint of renew and revoke accepts
+	SHA1Thumbprint    string `json:"sha1Thumbprint"`
+	SHA256Fingerprint string `json:"sha256Fingerprint"`
+}
+
+type csrInfo struct {
+	Subject string `json:"subject"`
+	sanInfo
+	Key keyInfo `json:"key"`
+}
+
+type sanInfo struct {
+	DNSNames       []string `json:"sanDns,omitempty"`
+	IPAddresses    []string `json:"sanIp,omitempty"`
+	EmailAddresses []string `json:"sanEmail,omitempty"`
+	URIs           []string `json:"sanUri,omitempty"`
+	UPNs           []string `json:"sanUpn,omitempty"`
+}
+
+type keyInfo struct {
+	Type  string `json:"type"`
+	Size  int    `json:"size,omitempty"`
+	Curve string `json:"curve,omitempty"`
+}
+
+type policyCheck struct {
+	Zone      string `json:"zone"`
+	Compliant bool   `json:"compliant"`
+	Error     string `json:"error,omitempty"`
+}
+
+func doCommandInspect(c *cli.Context) error {
+	err := validateInspectFlags(c.Command.Name)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(flags.inFile)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s: %s", flags.inFile, err)
+	}
+	result, err := inspect(data, flags.inPassword, flags.inKeyPassword)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s: %s", flags.inFile, err)
+	}
+	if flags.inKeyFile != "" {
+		if result.PrivateKey != nil {
+			return fmt.Errorf("%s already contains a private key, so --in-key-file cannot be used", flags.inFile)
+		}
+		key, err := readPrivateKeyFile(flags.inKeyFile, flags.inKeyPassword)
+		if err != nil {
+			return fmt.Errorf("Failed to read %s: %s", flags.inKeyFile, err)
+		}
+		result.setPrivateKey(key)
+	}
+
+	if result.csr != nil && inspectUsesConnection() {
+		result.Policy, err = checkCSRPolicy(c, result.csr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if flags.inspectFormat == "json" {
+		if err := outputJSON(result); err != nil {
+			return err
+		}
+	} else {
+		fmt.Print(result.text())
+	}
+	if result.Policy != nil && !result.Policy.Compliant {
+		return fmt.Errorf("The CSR does not comply with the policy of zone %s: %s", result.Policy.Zone, result.Policy.Error)
+	}
+	return nil
+}
+
+// inspectUsesConnection tells whether connection flags were given, which inspect only needs to check a CSR against the zone
+func inspectUsesConnection() bool {
+	return flags.zone != "" || flags.config != "" || flags.testMode || flags.apiKey != "" || flags.url != "" || flags.tppToken != ""
+}
+
+// inspect reads a CSR, certificates and a private key from data. Certificates are ordered like convert does.
+func inspect(data []byte, password, keyPassword string) (*inspection, error) {
+	result := &inspection{}
+	csr, err := parseCSR(data)
+	if err != nil {
+		return nil, err
+	}
+	if csr != nil {
+		result.csr = csr
+		result.CSR = &csrInfo{Subject: csr.Subject.String(), sanInfo: newSANInfo(csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.URIs, csr.Extensions), Key: newKeyInfo(csr.PublicKey)}
+		if block, _ := pem.Decode(data); block == nil {
+			return result, nil // a DER CSR holds nothing else
+		}
+	}
+
+	b, err := parseBundle(data, password, keyPassword)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.certs) > 0 {
+		leaf, _ := (&bundle{certs: b.certs}).leafIndex()
+		result.certs = b.orderedCertificates(leaf)
+		for _, cert := range result.certs {
+			result.Certificates = append(result.Certificates, newCertificateInfo(cert))
+		}
+	}
+	if b.key != nil {
+		result.setPrivateKey(b.key)
+	}
+	if result.csr == nil && result.certs == nil && b.key == nil {
+		return nil, fmt.Errorf("no certificate, CSR or private key found")
+	}
+	return result, nil
+}
+
+// parseCSR returns the PEM or DER CSR in data, or nil if there is none
+func parseCSR(data []byte) (*x509.CertificateRequest, error) {
+	if block, _ := pem.Decode(data); block == nil {
+		if csr, err := x509.ParseCertificateRequest(data); err == nil {
+			return csr, nil
+		}
+		return nil, nil
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, nil
+		}
+		if strings.HasSuffix(block.Type, "CERTIFICATE REQUEST") {
+			csr, err := x509.ParseCertificateRequest(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("CSR parse error: %s", err)
+			}
+			return csr, nil
+		}
+	}
+}
+
+// setPrivateKey adds key and whether it matches the first certificate, or else the CSR
+func (i *inspection) setPrivateKey(key crypto.Signer) {
+	info := newKeyInfo(key.Public())
+	i.PrivateKey = &info
+	var matches bool
+	switch {
+	case len(i.certs) > 0:
+		matches = publicKeysEqual(key.Public(), i.certs[0].PublicKey)
+	case i.csr != nil:
+		matches = publicKeysEqual(key.Public(), i.csr.PublicKey)
+	default:
+		return
+	}
+	i.KeyMatches = &matches
+}
+
+func newCertificateInfo(cert *x509.Certificate) certificateInfo {
+	sha1Sum := sha1.Sum(cert.Raw)
+	sha256Sum := sha256.Sum256(cert.Raw)
+	return certificateInfo{
+		Subject:           cert.Subject.String(),
+		Issuer:            cert.Issuer.String(),
+		SerialNumber:      strings.ToUpper(cert.SerialNumber.Text(16)),
+		NotBefore:         cert.NotBefore,
+		NotAfter:          cert.NotAfter,
+		IsCA:              cert.IsCA,
+		sanInfo:           newSANInfo(cert.DNSNames, cert.IPAddresses, cert.EmailAddresses, cert.URIs, cert.Extensions),
+		Key:               newKeyInfo(cert.PublicKey),
+		SHA1Thumbprint:    strings.ToUpper(hex.EncodeToString(sha1Sum[:])),
+		SHA256Fingerprint: strings.ToUpper(hex.EncodeToString(sha256Sum[:])),
+	}
+}
+
+func newSANInfo(dnsNames []string, ips []net.IP, emails []string, uris []*url.URL, extensions []pkix.Extension) sanInfo {
+	info := sanInfo{DNSNames: dnsNames, EmailAddresses: emails}
+	for _, ip := range ips {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	for _, uri := range uris {
+		info.URIs = append(info.URIs, uri.String())
+	}
+	info.UPNs, _ = certificate.UserPrincipalNameSANs(extensions)
+	return info
+}
+
+func newKeyInfo(public crypto.PublicKey) keyInfo {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		return keyInfo{Type: "RSA", Size: key.N.BitLen()}
+	case *ecdsa.PublicKey:
+		return keyInfo{Type: "ECDSA", Size: key.Curve.Params().BitSize, Curve: key.Curve.Params().Name}
+	case ed25519.PublicKey:
+		return keyInfo{Type: "Ed25519"}
+	default:
+		return keyInfo{Type: fmt.Sprintf("%T", public)}
+	}
+}
+
+func (k keyInfo) String() string {
+	switch {
+	case k.Curve != "":
+		return fmt.Sprintf("%s %s", k.Type, k.Curve)
+	case k.Size > 0:
+		return fmt.Sprintf("%s %d", k.Type, k.Size)
+	default:
+		return k.Type
+	}
+}
+
+// checkCSRPolicy validates csr against the policy of the zone of the connection flags
+func checkCSRPolicy(c *cli.Context, csr *x509.CertificateRequest) (*policyCheck, error) {
+	validateOverWritingEnviromentVariables()
+	cfg, err := buildConfig(c, &flags)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to build vcert config: %s", err)
+	}
+	connector, err := vcert.NewClient(&cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to connect to %s: %w", cfg.ConnectorType, err)
+	}
+	zoneConfig, err := connector.ReadZoneConfiguration()
+	if err != nil {
+		return nil, err
+	}
+
+	req := &certificate.Request{}
+	err = req.SetCSR(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw}))
+	if err != nil {
+		return nil, err
+	}
+	check := &policyCheck{Zone: cfg.Zone, Compliant: true}
+	if err := zoneConfig.ValidateCertificateRequest(req); err != nil {
+		check.Compliant = false
+		check.Error = err.Error()
+	}
+	return check, nil
+}
+
+func (i *inspection) text() string {
+	var b strings.Builder
+	line := func(indent, name string, value interface{}) {
+		fmt.Fprintf(&b, "%s%s: %v\n", indent, name, value)
+	}
+	sans := func(s sanInfo) {
+		for _, san := range []struct {
+			name   string
+			values []string
+		}{{"DNS SANs", s.DNSNames}, {"IP SANs", s.IPAddresses}, {"Email SANs", s.EmailAddresses}, {"URI SANs", s.URIs}, {"UPN SANs", s.UPNs}} {
+			if len(san.values) > 0 {
+				line("  ", san.name, strings.Join(san.values, ", "))
+			}
+		}
+	}
+
+	for n, cert := range i.Certificates {
+		fmt.Fprintf(&b, "Certificate %d:\n", n+1)
+		line("  ", "Subject", cert.Subject)
+		line("  ", "Issuer", cert.Issuer)
+		line("  ", "Serial number", cert.SerialNumber)
+		line("  ", "Valid from", cert.NotBefore.UTC().Format(time.RFC3339))
+		validTo := cert.NotAfter.UTC().Format(time.RFC3339)
+		if time.Now().After(cert.NotAfter) {
+			validTo += " (expired)"
+		}
+		line("  ", "Valid to", validTo)
+		sans(cert.sanInfo)
+		line("  ", "Key", cert.Key)
+		line("  ", "CA", cert.IsCA)
+		line("  ", "SHA-1 thumbprint", cert.SHA1Thumbprint)
+		line("  ", "SHA-256 fingerprint", cert.SHA256Fingerprint)
+	}
+	if i.CSR != nil {
+		b.WriteString("CSR:\n")
+		line("  ", "Subject", i.CSR.Subject)
+		sans(i.CSR.sanInfo)
+		line("  ", "Key", i.CSR.Key)
+	}
+	if i.PrivateKey != nil {
+		b.WriteString("Private key:\n")
+		line("  ", "Key", *i.PrivateKey)
+		if i.KeyMatches != nil {
+			target := "certificate"
+			if len(i.Certificates) == 0 {
+				target = "CSR"
+			}
+			line("  ", "Matches the "+target, *i.KeyMatches)
+		}
+	}
+	if i.Policy != nil {
+		b.WriteString("Policy:\n")
+		line("  ", "Zone", i.Policy.Zone)
+		line("  ", "Compliant", i.Policy.Compliant)
+		if i.Policy.Error != "" {
+			line("  ", "Error", i.Policy.Error)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+)
+
+func TestInspectCertificates(t *testing.T) {
+	key, certs := newTestChain(t)
+	// root first, as a trust bundle would have it
+	data := certPEM(certs[2]) + certPEM(certs[1]) + certPEM(certs[0])
+	keyBlock, err := certificate.GetPrivateKeyPEMBock(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data += string(pem.EncodeToMemory(keyBlock))
+
+	result, err := inspect([]byte(data), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Certificates) != 3 || result.Certificates[0].Subject != "CN=web.example.com" || result.Certificates[2].Subject != "CN=Root CA" {
+		t.Fatalf("expected the certificates from the leaf to the root, got %+v", result.Certificates)
+	}
+	thumbprint := sha1.Sum(certs[0].Raw)
+	if result.Certificates[0].SHA1Thumbprint != strings.ToUpper(hex.EncodeToString(thumbprint[:])) {
+		t.Fatalf("unexpected thumbprint %s", result.Certificates[0].SHA1Thumbprint)
+	}
+	if result.Certificates[0].Key != (keyInfo{Type: "ECDSA", Size: 256, Curve: "P-256"}) {
+		t.Fatalf("unexpected key %+v", result.Certificates[0].Key)
+	}
+	if result.KeyMatches == nil || !*result.KeyMatches {
+		t.Fatal("the private key should match the certificate")
+	}
+
+	other, _ := newTestChain(t)
+	result.setPrivateKey(other)
+	if *result.KeyMatches {
+		t.Fatal("a different private key should not match the certificate")
+	}
+}
+
+func TestInspectCSR(t *testing.T) {
+	req := &certificate.Request{KeyType: certificate.KeyTypeECDSA, UPNs: []string{"user@example.com"}, DNSNames: []string{"www.example.com"}}
+	req.Subject.CommonName = "www.example.com"
+	if err := req.GeneratePrivateKey(); err != nil {
+		t.Fatal(err)
+	}
+	if err := certificate.GenerateRequest(req, req.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range [][]byte{req.GetCSR(), csrDER(t, req.GetCSR())} {
+		result, err := inspect(data, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.CSR == nil || result.CSR.Subject != "CN=www.example.com" {
+			t.Fatalf("unexpected CSR %+v", result.CSR)
+		}
+		if len(result.CSR.UPNs) != 1 || result.CSR.UPNs[0] != "user@example.com" || len(result.CSR.DNSNames) != 1 {
+			t.Fatalf("unexpected SANs %+v", result.CSR.sanInfo)
+		}
+		result.setPrivateKey(req.PrivateKey)
+		if result.KeyMatches == nil || !*result.KeyMatches {
+			t.Fatal("the private key should match the CSR")
+		}
+		if !strings.Contains(result.text(), "UPN SANs: user@example.com") {
+			t.Fatalf("the UPN is missing from the text output:\n%s", result.text())
+		}
+	}
+}
+
+func csrDER(t *testing.T, csrPEM []byte) []byte {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil {
+		t.Fatal("CSR PEM expected")
+	}
+	return block.Bytes
+}
@@ -0,0 +1,181 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+)
+
+const K8sSecretFormat = "k8s-secret"
+
+// k8sSecretConfig holds the settings of the Secret written with --format k8s-secret
+type k8sSecretConfig struct {
+	Name        string
+	Namespace   string
+	Labels      map[string]string
+	Annotations map[string]string
+	// Output is either yaml (default) or json
+	Output string
+}
+
+type k8sSecret struct {
+	APIVersion string            `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string            `json:"kind" yaml:"kind"`
+	Metadata   k8sObjectMeta     `json:"metadata" yaml:"metadata"`
+	Type       string            `json:"type" yaml:"type"`
+	Data       map[string]string `json:"data" yaml:"data"`
+}
+
+type k8sObjectMeta struct {
+	Name        string            `json:"name" yaml:"name"`
+	Namespace   string            `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+}
+
+// AsK8sSecret returns a kubernetes.io/tls Secret manifest. tls.crt holds the certificate followed by the chain,
+// ca.crt holds the chain alone and is omitted when there is no chain. The private key is stored unencrypted,
+// since Kubernetes cannot use an encrypted one.
+func (o *Output) AsK8sSecret(c *Config) ([]byte, error) {
+	if len(o.Certificate) == 0 && len(o.PrivateKey) == 0 {
+		// everything went to --file
+		return nil, nil
+	}
+	if len(o.Certificate) == 0 || len(o.PrivateKey) == 0 {
+		return nil, fmt.Errorf("at least certificate and private key are required")
+	}
+
+	key, err := decryptPrivateKeyPEM(o.PrivateKey, c.KeyPassword)
+	if err != nil {
+		return nil, err
+	}
+	chain := strings.Join(o.Chain, "")
+	data := map[string]string{
+		"tls.key": base64.StdEncoding.EncodeToString(key),
+	}
+	if c.ChainOption == certificate.ChainOptionRootFirst {
+		data["tls.crt"] = base64.StdEncoding.EncodeToString([]byte(chain + o.Certificate))
+	} else {
+		data["tls.crt"] = base64.StdEncoding.EncodeToString([]byte(o.Certificate + chain))
+	}
+	if chain != "" {
+		data["ca.crt"] = base64.StdEncoding.EncodeToString([]byte(chain))
+	}
+
+	name := c.Secret.Name
+	if name == "" {
+		name, err = secretNameFromCertificate(o.Certificate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	secret := k8sSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: k8sObjectMeta{
+			Name:        name,
+			Namespace:   c.Secret.Namespace,
+			Labels:      c.Secret.Labels,
+			Annotations: c.Secret.Annotations,
+		},
+		Type: "kubernetes.io/tls",
+		Data: data,
+	}
+
+	if strings.ToLower(c.Secret.Output) == "json" {
+		b, err := json.MarshalIndent(secret, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct JSON: %s", err)
+		}
+		return append(b, '\n'), nil
+	}
+	b, err := yaml.Marshal(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct YAML: %s", err)
+	}
+	return b, nil
+}
+
+// decryptPrivateKeyPEM returns keyPEM without encryption
+func decryptPrivateKeyPEM(keyPEM string, password string) ([]byte, error) {
+	p, _ := pem.Decode([]byte(keyPEM))
+	if p == nil {
+		return nil, fmt.Errorf("missing private key PEM")
+	}
+	//nolint:staticcheck
+	if !x509.IsEncryptedPEMBlock(p) {
+		return []byte(keyPEM), nil
+	}
+	//nolint:staticcheck
+	der, err := x509.DecryptPEMBlock(p, []byte(password))
+	if err != nil {
+		return nil, fmt.Errorf("private key PEM decryption error: %s", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: p.Type, Bytes: der}), nil
+}
+
+var invalidSecretNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// secretNameFromCertificate derives a valid Secret name from the common name of the certificate,
+// e.g. *.example.com becomes wildcard.example.com
+func secretNameFromCertificate(certPEM string) (string, error) {
+	p, _ := pem.Decode([]byte(certPEM))
+	if p == nil {
+		return "", fmt.Errorf("missing certificate PEM")
+	}
+	cert, err := x509.ParseCertificate(p.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("certificate parse error: %s", err)
+	}
+	name := strings.ToLower(cert.Subject.CommonName)
+	name = strings.Replace(name, "*", "wildcard", -1)
+	name = invalidSecretNameChars.ReplaceAllString(name, "-")
+	name = strings.Trim(name, ".-")
+	if len(name) > 253 {
+		name = strings.Trim(name[:253], ".-")
+	}
+	if name == "" {
+		return "", fmt.Errorf("unable to derive a Secret name from the certificate, specify it with --secret-name")
+	}
+	return name, nil
+}
+
+// parseKeyValues parses repeated key=value flags into a map
+func parseKeyValues(flagName string, values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string, len(values))
+	for _, s := range values {
+		key, value, err := parseCustomField(s)
+		if err != nil || key == "" {
+			return nil, fmt.Errorf("--%s %q should have format key=value", flagName, s)
+		}
+		m[key] = value
+	}
+	return m, nil
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+)
+
+func TestK8sSecret(t *testing.T) {
+	output := &Output{Certificate: cert, PrivateKey: encPK, Chain: chain}
+	config := &Config{
+		Format:      K8sSecretFormat,
+		KeyPassword: "asdf",
+		Secret: k8sSecretConfig{
+			Namespace: "prod",
+			Labels:    map[string]string{"app": "web"},
+			Output:    "json",
+		},
+	}
+	b, err := output.Format(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var secret k8sSecret
+	if err := json.Unmarshal(b, &secret); err != nil {
+		t.Fatalf("invalid JSON: %s", err)
+	}
+	if secret.Kind != "Secret" || secret.Type != "kubernetes.io/tls" {
+		t.Fatalf("unexpected object %s of type %s", secret.Kind, secret.Type)
+	}
+	if secret.Metadata.Name != "q" || secret.Metadata.Namespace != "prod" || secret.Metadata.Labels["app"] != "web" {
+		t.Fatalf("unexpected metadata: %+v", secret.Metadata)
+	}
+	key, err := base64.StdEncoding.DecodeString(secret.Data["tls.key"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(key), "ENCRYPTED") {
+		t.Fatal("private key should be stored unencrypted")
+	}
+	crt, _ := base64.StdEncoding.DecodeString(secret.Data["tls.crt"])
+	if !strings.HasPrefix(string(crt), cert) || !strings.HasSuffix(string(crt), strings.Join(chain, "")) {
+		t.Fatal("tls.crt should hold the certificate followed by the chain")
+	}
+	ca, _ := base64.StdEncoding.DecodeString(secret.Data["ca.crt"])
+	if string(ca) != strings.Join(chain, "") {
+		t.Fatal("ca.crt should hold the chain")
+	}
+
+	config.Secret = k8sSecretConfig{Name: "web-tls"}
+	config.ChainOption = certificate.ChainOptionRootFirst
+	output.Chain = nil
+	b, err = output.Format(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret = k8sSecret{}
+	if err := yaml.Unmarshal(b, &secret); err != nil {
+		t.Fatalf("invalid YAML: %s", err)
+	}
+	if secret.Metadata.Name != "web-tls" {
+		t.Fatalf("unexpected name %q", secret.Metadata.Name)
+	}
+	if _, ok := secret.Data["ca.crt"]; ok {
+		t.Fatal("ca.crt should be omitted without a chain")
+	}
+
+	if _, err := (&Output{Certificate: cert}).Format(config); err == nil {
+		t.Fatal("a Secret without a private key should be rejected")
+	}
+}
+
+func TestSecretNameFromCertificate(t *testing.T) {
+	name, err := secretNameFromCertificate(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "q" {
+		t.Fatalf("unexpected name %q", name)
+	}
+	if _, err := secretNameFromCertificate("garbage"); err == nil {
+		t.Fatal("invalid certificate should be rejected")
+	}
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pavel-v-chernykh/keystore-go/v4"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+)
+
+// Output layouts arrange the results into the files expected by a particular server
+const (
+	// LayoutNginx writes fullchain.pem with the certificate and the chain, and privkey.pem
+	LayoutNginx = "nginx"
+	// LayoutHAProxy writes combined.pem with the certificate, the chain and the private key
+	LayoutHAProxy = "haproxy"
+	// LayoutApache writes cert.pem, chain.pem and privkey.pem
+	LayoutApache = "apache"
+	// LayoutTruststore writes truststore.p12, or truststore.jks with --format jks, holding only the chain
+	LayoutTruststore = "truststore"
+)
+
+var layouts = []string{LayoutNginx, LayoutHAProxy, LayoutApache, LayoutTruststore}
+
+// addLayout adds the files of the layout from the config to files
+func (r *Result) addLayout(files *fileSet) error {
+	c := r.Config
+	if c.Layout == LayoutTruststore {
+		return r.addTruststore(files)
+	}
+	if r.Pcc.Certificate == "" || r.Pcc.PrivateKey == "" {
+		return fmt.Errorf("%s layout requires both the certificate and the private key", c.Layout)
+	}
+
+	var chain string
+	if c.ChainOption != certificate.ChainOptionIgnore {
+		chain = strings.Join(r.Pcc.Chain, "")
+	}
+	// the chain precedes the certificate with --chain root-first, as in the PEM output
+	certAndChain := r.Pcc.Certificate + chain
+	if c.ChainOption == certificate.ChainOptionRootFirst {
+		certAndChain = chain + r.Pcc.Certificate
+	}
+
+	switch c.Layout {
+	case LayoutNginx:
+		files.add(filepath.Join(c.OutputDir, "fullchain.pem"), []byte(certAndChain))
+		files.add(filepath.Join(c.OutputDir, "privkey.pem"), []byte(r.Pcc.PrivateKey))
+	case LayoutHAProxy:
+		files.add(filepath.Join(c.OutputDir, "combined.pem"), []byte(certAndChain+r.Pcc.PrivateKey))
+	case LayoutApache:
+		files.add(filepath.Join(c.OutputDir, "cert.pem"), []byte(r.Pcc.Certificate))
+		if chain != "" {
+			files.add(filepath.Join(c.OutputDir, "chain.pem"), []byte(chain))
+		}
+		files.add(filepath.Join(c.OutputDir, "privkey.pem"), []byte(r.Pcc.PrivateKey))
+	default:
+		return fmt.Errorf("unexpected layout %q", c.Layout)
+	}
+	return nil
+}
+
+// addTruststore adds a trust store holding the chain of the certificate, in the order given by the chain option
+func (r *Result) addTruststore(files *fileSet) error {
+	c := r.Config
+	if len(r.Pcc.Chain) == 0 {
+		return fmt.Errorf("truststore layout requires the certificate chain, but none was returned")
+	}
+
+	var certs []*x509.Certificate
+	var aliases []string
+	used := map[string]bool{}
+	for _, chainCert := range r.Pcc.Chain {
+		p, _ := pem.Decode([]byte(chainCert))
+		if p == nil {
+			return fmt.Errorf("chain certificate parse error")
+		}
+		cert, err := x509.ParseCertificate(p.Bytes)
+		if err != nil {
+			return fmt.Errorf("chain certificate parse error: %s", err)
+		}
+		certs = append(certs, cert)
+		aliases = append(aliases, truststoreAlias(cert, used))
+	}
+
+	if c.Format == JKSFormat {
+		b, err := encodeJKSTrustStore(certs, aliases, c.trustStorePassword())
+		if err != nil {
+			return err
+		}
+		files.add(filepath.Join(c.OutputDir, "truststore.jks"), b)
+		return nil
+	}
+	profile, err := getPKCS12Profile(c.PKCS12Profile)
+	if err != nil {
+		return err
+	}
+	b, err := encodePKCS12TrustStore(certs, aliases, c.trustStorePassword(), profile)
+	if err != nil {
+		return err
+	}
+	files.add(filepath.Join(c.OutputDir, "truststore.p12"), b)
+	return nil
+}
+
+// trustStorePassword follows the key store formats: --jks-password falls back to --key-password for JKS
+func (c *Config) trustStorePassword() string {
+	if c.Format == JKSFormat && c.JKSPassword != "" {
+		return c.JKSPassword
+	}
+	return c.KeyPassword
+}
+
+// truststoreAlias names an entry by the common name of the certificate, as keytool users would
+func truststoreAlias(cert *x509.Certificate, used map[string]bool) string {
+	base := strings.ToLower(cert.Subject.CommonName)
+	if base == "" {
+		base = "ca"
+	}
+	alias := base
+	for i := 1; used[alias]; i++ {
+		alias = fmt.Sprintf("%s-%d", base, i)
+	}
+	used[alias] = true
+	return alias
+}
+
+func encodeJKSTrustStore(certs []*x509.Certificate, aliases []string, password string) ([]byte, error) {
+	keyStore := keystore.New()
+	for i, cert := range certs {
+		entry := keystore.TrustedCertificateEntry{
+			CreationTime: time.Now(),
+			Certificate:  keystore.Certificate{Type: "X509", Content: cert.Raw},
+		}
+		if err := keyStore.SetTrustedCertificateEntry(aliases[i], entry); err != nil {
+			return nil, fmt.Errorf("JKS trusted certificate error: %s", err)
+		}
+	}
+	buffer := new(bytes.Buffer)
+	if err := keyStore.Store(buffer, []byte(password)); err != nil {
+		return nil, fmt.Errorf("JKS keystore error: %s", err)
+	}
+	return buffer.Bytes(), nil
+}
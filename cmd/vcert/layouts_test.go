@@ -0,0 +1,114 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pavel-v-chernykh/keystore-go/v4"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+)
+
+func TestLayouts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcertLayout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(layout string, chainOption certificate.ChainOption) string {
+		outputDir := filepath.Join(dir, layout)
+		if err := os.Mkdir(outputDir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		result := &Result{
+			Pcc:    &certificate.PEMCollection{Certificate: cert, PrivateKey: PK, Chain: chain},
+			Config: &Config{Command: "pickup", Layout: layout, OutputDir: outputDir, ChainOption: chainOption},
+		}
+		if err := result.Flush(); err != nil {
+			t.Fatalf("%s: %s", layout, err)
+		}
+		return outputDir
+	}
+	read := func(fileName string) string {
+		b, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	nginx := write(LayoutNginx, certificate.ChainOptionRootLast)
+	if read(filepath.Join(nginx, "fullchain.pem")) != cert+strings.Join(chain, "") {
+		t.Fatal("fullchain.pem should hold the certificate followed by the chain")
+	}
+	if read(filepath.Join(nginx, "privkey.pem")) != PK {
+		t.Fatal("privkey.pem should hold the private key")
+	}
+
+	haproxy := write(LayoutHAProxy, certificate.ChainOptionRootFirst)
+	if read(filepath.Join(haproxy, "combined.pem")) != strings.Join(chain, "")+cert+PK {
+		t.Fatal("combined.pem should follow the chain option")
+	}
+
+	apache := write(LayoutApache, certificate.ChainOptionIgnore)
+	if read(filepath.Join(apache, "cert.pem")) != cert {
+		t.Fatal("cert.pem should hold the certificate only")
+	}
+	if _, err := os.Stat(filepath.Join(apache, "chain.pem")); !os.IsNotExist(err) {
+		t.Fatal("chain.pem should not be written with --chain ignore")
+	}
+
+	truststore := write(LayoutTruststore, certificate.ChainOptionRootLast)
+	if _, err := os.Stat(filepath.Join(truststore, "truststore.p12")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJKSTruststore(t *testing.T) {
+	result := &Result{
+		Pcc:    &certificate.PEMCollection{Certificate: cert, PrivateKey: PK, Chain: chain},
+		Config: &Config{Format: JKSFormat, KeyPassword: "changeit", Layout: LayoutTruststore},
+	}
+	files := &fileSet{config: result.Config}
+	if err := result.addLayout(files); err != nil {
+		t.Fatal(err)
+	}
+	if len(files.names) != 1 || files.names[0] != "truststore.jks" {
+		t.Fatalf("unexpected files %v", files.names)
+	}
+
+	ks := keystore.New()
+	if err := ks.Load(bytes.NewReader(files.data[0]), []byte("changeit")); err != nil {
+		t.Fatal(err)
+	}
+	aliases := ks.Aliases()
+	if len(aliases) != len(chain) {
+		t.Fatalf("expected an entry for every chain certificate, got %v", aliases)
+	}
+	for _, alias := range aliases {
+		if !ks.IsTrustedCertificateEntry(alias) {
+			t.Fatalf("%s should be a trusted certificate entry", alias)
+		}
+	}
+}
@@ -70,6 +70,9 @@ func main() {
 			commandPickup,
 			commandRenew,
 			commandRevoke,
+			commandAgent,
+			commandConvert,
+			commandInspect,
 		},
 		EnableBashCompletion: true, //todo: write BashComplete function for options
 		//HideHelp:             true,
@@ -96,6 +99,9 @@ ACTIONS:
    pickup     To retrieve a certificate
    renew      To renew a certificate
    revoke     To revoke a certificate
+   agent      To keep certificates enrolled and renewed
+   convert    To convert a local certificate bundle to another format
+   inspect    To show the details of a certificate, CSR or private key file
 
    getcred    To obtain a new token for authentication
    checkcred  To check the validity of a token and grant
@@ -130,8 +136,12 @@ OPTIONS:
 `
 	err := app.Run(os.Args)
 	if err != nil {
-		//TODO: we need to make logger a global package
-		logger := log.New(os.Stderr, UtilityShortName+": ", log.LstdFlags)
-		logger.Panicf("%s", err)
+		code := exitCode(err)
+		if flags.errorFormat == errorFormatJSON {
+			writeJSONError(os.Stderr, err, code)
+		} else {
+			logger.Printf("%s", err)
+		}
+		exit(code)
 	}
 }
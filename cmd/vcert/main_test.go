@@ -22,6 +22,7 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/big"
@@ -34,6 +35,7 @@ import (
 
 	"github.com/Venafi/vcert/v4/pkg/certificate"
 	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/Venafi/vcert/v4/pkg/verror"
 )
 
 var testEmail = "test@vcert.test"
@@ -594,7 +596,10 @@ func TestGenerateCertRequest(t *testing.T) {
 	//cf := createFromCommandFlags(commandEnroll)
 
 	req := &certificate.Request{}
-	req = fillCertificateRequest(req, &flags)
+	req, err := fillCertificateRequest(req, &flags)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if req == nil {
 		t.Fatalf("generateCertificateRequest returned a nil request")
 	}
@@ -603,6 +608,20 @@ func TestGenerateCertRequest(t *testing.T) {
 	}
 }
 
+func TestFillCertificateRequestUserData(t *testing.T) {
+	cases := []commandFlags{
+		{customFields: []string{"no value"}},
+		{csrOption: "file:" + os.TempDir() + "/vcertTest.missing.csr"},
+	}
+	for _, flags := range cases {
+		flags := flags
+		_, err := fillCertificateRequest(&certificate.Request{}, &flags)
+		if !errors.Is(err, verror.UserDataError) {
+			t.Fatalf("expected a user data error for %+v, got %v", flags, err)
+		}
+	}
+}
+
 func TestGetFileWriter(t *testing.T) {
 	//set the pem file var so we get a file handle
 	temp, err := ioutil.TempFile(os.TempDir(), "vcertTest")
@@ -612,7 +631,10 @@ func TestGetFileWriter(t *testing.T) {
 	defer os.Remove(temp.Name())
 	fileName := temp.Name()
 	temp.Close()
-	writer := getFileWriter(fileName)
+	writer, err := getFileWriter(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
 	f, ok := writer.(*os.File)
 	if ok {
 		defer f.Close()
@@ -0,0 +1,253 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// manifest is the content of the file passed to enroll --manifest.
+// Keys of an entry mirror the names of the corresponding enroll flags.
+type manifest struct {
+	Certificates []manifestEntry `yaml:"certificates"`
+}
+
+type manifestEntry struct {
+	CommonName string   `yaml:"cn"`
+	Nickname   string   `yaml:"nickname"`
+	Org        string   `yaml:"o"`
+	OrgUnits   []string `yaml:"ou"`
+	Locality   string   `yaml:"l"`
+	State      string   `yaml:"st"`
+	Country    string   `yaml:"c"`
+
+	SanDNS   []string `yaml:"san-dns"`
+	SanIP    []string `yaml:"san-ip"`
+	SanEmail []string `yaml:"san-email"`
+	SanURI   []string `yaml:"san-uri"`
+	SanUPN   []string `yaml:"san-upn"`
+
+	CSR         string   `yaml:"csr"`
+	KeyType     string   `yaml:"key-type"`
+	KeySize     int      `yaml:"key-size"`
+	KeyCurve    string   `yaml:"key-curve"`
+	KeyPassword string   `yaml:"key-password"`
+	Fields      []string `yaml:"fields"`
+	ValidDays   string   `yaml:"valid-days"`
+
+	Format       string `yaml:"format"`
+	Chain        string `yaml:"chain"`
+	JKSAlias     string `yaml:"jks-alias"`
+	JKSPassword  string `yaml:"jks-password"`
+	File         string `yaml:"file"`
+	CertFile     string `yaml:"cert-file"`
+	KeyFile      string `yaml:"key-file"`
+	ChainFile    string `yaml:"chain-file"`
+	PickupIDFile string `yaml:"pickup-id-file"`
+}
+
+// manifestEntryResult is a line of the summary printed after a manifest was processed
+type manifestEntryResult struct {
+	CommonName string `json:"cn"`
+	PickupID   string `json:"pickupId,omitempty"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+type manifestSummary struct {
+	Succeeded    int                   `json:"succeeded"`
+	Failed       int                   `json:"failed"`
+	Certificates []manifestEntryResult `json:"certificates"`
+}
+
+func readManifest(fileName string) (*manifest, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %s", err)
+	}
+	m := &manifest{}
+	err = yaml.UnmarshalStrict(b, m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %s", fileName, err)
+	}
+	if len(m.Certificates) == 0 {
+		return nil, fmt.Errorf("manifest %s does not contain any certificates", fileName)
+	}
+	return m, nil
+}
+
+// commandFlags returns the flags for enrolling the entry. Settings that are missing in the entry,
+// like key type, format or key password, are taken from base.
+func (e *manifestEntry) commandFlags(base *commandFlags) (*commandFlags, error) {
+	cf := *base
+
+	cf.commonName = e.CommonName
+	cf.friendlyName = e.Nickname
+	cf.org = e.Org
+	cf.orgUnits = e.OrgUnits
+	cf.locality = e.Locality
+	cf.state = e.State
+	cf.country = e.Country
+
+	cf.dnsSans = e.SanDNS
+	cf.ipSans = nil
+	for _, s := range e.SanIP {
+		if err := cf.ipSans.Set(s); err != nil {
+			return nil, err
+		}
+	}
+	cf.emailSans = nil
+	for _, s := range e.SanEmail {
+		if err := cf.emailSans.Set(s); err != nil {
+			return nil, err
+		}
+	}
+	cf.uriSans = nil
+	for _, s := range e.SanURI {
+		if err := cf.uriSans.Set(s); err != nil {
+			return nil, err
+		}
+	}
+	cf.upnSans = nil
+	for _, s := range e.SanUPN {
+		if err := cf.upnSans.Set(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.CSR != "" {
+		cf.csrOption = e.CSR
+	}
+	if e.KeyType != "" {
+		kt, err := parseKeyType(e.KeyType)
+		if err != nil {
+			return nil, err
+		}
+		cf.keyTypeString = e.KeyType
+		cf.keyType = kt
+	}
+	if e.KeySize > 0 {
+		cf.keySize = e.KeySize
+	}
+	if e.KeyCurve != "" {
+		curve, err := parseKeyCurve(e.KeyCurve)
+		if err != nil {
+			return nil, err
+		}
+		cf.keyCurveString = e.KeyCurve
+		cf.keyCurve = curve
+	}
+	if e.KeyPassword != "" {
+		password, err := readPasswordsFromInputFlag(e.KeyPassword, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key password: %s", err)
+		}
+		cf.keyPassword = password
+	}
+	if len(e.Fields) > 0 {
+		cf.customFields = e.Fields
+	}
+	if e.ValidDays != "" {
+		cf.validDays = e.ValidDays
+	}
+
+	if e.Format != "" {
+		cf.format = e.Format
+	}
+	if e.Chain != "" {
+		cf.chainOption = e.Chain
+	}
+	cf.jksAlias = e.JKSAlias
+	if e.JKSPassword != "" {
+		cf.jksPassword = e.JKSPassword
+	}
+	cf.file = e.File
+	cf.certFile = e.CertFile
+	cf.keyFile = e.KeyFile
+	cf.chainFile = e.ChainFile
+	cf.pickupIDFile = e.PickupIDFile
+
+	return &cf, validateManifestEntryFlags(&cf)
+}
+
+// validateManifestEntryFlags checks the per-entry subset of validateEnrollFlags. Results of a manifest
+// must go to files, since STDOUT is reserved for the summary.
+func validateManifestEntryFlags(cf *commandFlags) error {
+	if cf.commonName == "" {
+		return fmt.Errorf("a common name (cn) is required")
+	}
+	if cf.csrOption != "" && cf.csrOption != "local" && cf.csrOption != "service" {
+		return fmt.Errorf("unexpected csr option %q; specify local or service", cf.csrOption)
+	}
+	for _, f := range cf.customFields {
+		if _, _, err := parseCustomField(f); err != nil {
+			return err
+		}
+	}
+	if cf.validDays != "" && !validDaysRegex.MatchString(cf.validDays) {
+		return fmt.Errorf("valid-days %q has an invalid format", cf.validDays)
+	}
+
+	switch cf.format {
+	case "", "pem", "json":
+		if cf.file == "" && cf.certFile == "" {
+			return fmt.Errorf("either file or cert-file is required")
+		}
+		if cf.file != "" && (cf.certFile != "" || cf.chainFile != "" || cf.keyFile != "") {
+			return fmt.Errorf("file cannot be combined with cert-file, key-file or chain-file")
+		}
+		if cf.file == "" && cf.keyFile == "" && cf.csrOption != "service" {
+			return fmt.Errorf("key-file is required when cert-file is used")
+		}
+	case "pkcs12", JKSFormat, K8sSecretFormat:
+		if cf.file == "" {
+			return fmt.Errorf("%s format requires certificate, private key, and chain to be written to a single file; specify using file", cf.format)
+		}
+		if cf.certFile != "" || cf.chainFile != "" || cf.keyFile != "" {
+			return fmt.Errorf("file cannot be combined with cert-file, key-file or chain-file when format is %s", cf.format)
+		}
+	case DERFormat, PKCS7Format:
+		if cf.file != "" || cf.certFile == "" {
+			return fmt.Errorf("%s format writes a binary certificate file; specify cert-file instead of file", cf.format)
+		}
+		if cf.keyFile == "" && cf.csrOption != "service" {
+			return fmt.Errorf("key-file is required when cert-file is used")
+		}
+	default:
+		return fmt.Errorf("unexpected output format: %s", cf.format)
+	}
+
+	if cf.format == JKSFormat {
+		if cf.jksAlias == "" {
+			return fmt.Errorf("jks-alias is required with format jks")
+		}
+		if len(cf.keyPassword) < JKSMinPasswordLen || (cf.jksPassword != "" && len(cf.jksPassword) < JKSMinPasswordLen) {
+			return fmt.Errorf("JKS format requires passwords that are at least %d characters long", JKSMinPasswordLen)
+		}
+	} else if cf.jksAlias != "" {
+		return fmt.Errorf("jks-alias may only be used with format jks")
+	}
+
+	if strings.ToLower(cf.chainOption) == "ignore" && cf.chainFile != "" {
+		return fmt.Errorf("chain ignore cannot be used with chain-file")
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+)
+
+const testManifest = `
+certificates:
+  - cn: one.example.com
+    san-dns: [one.example.com, www.one.example.com]
+    san-ip: [10.0.0.1]
+    key-type: ecdsa
+    key-curve: p384
+    fields: ["Cost Center=1234"]
+    cert-file: one.crt
+    key-file: one.key
+  - cn: two.example.com
+    nickname: two
+    format: pkcs12
+    key-password: pass:secret
+    file: two.p12
+`
+
+func writeTestManifest(t *testing.T, dir, content string) string {
+	fileName := filepath.Join(dir, "certs.yaml")
+	err := ioutil.WriteFile(fileName, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestReadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcertManifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := readManifest(writeTestManifest(t, dir, testManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Certificates) != 2 {
+		t.Fatalf("expected 2 certificates, got %d", len(m.Certificates))
+	}
+
+	base := commandFlags{format: "pem", chainOption: "root-last", keySize: 4096}
+	one, err := m.Certificates[0].commandFlags(&base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if one.commonName != "one.example.com" || len(one.dnsSans) != 2 || len(one.ipSans) != 1 {
+		t.Fatalf("unexpected subject of the first entry: %+v", one)
+	}
+	if one.keyType == nil || *one.keyType != certificate.KeyTypeECDSA || one.keyCurve != certificate.EllipticCurveP384 {
+		t.Fatalf("unexpected key settings of the first entry: %+v", one)
+	}
+	if one.certFile != "one.crt" || one.keyFile != "one.key" || one.format != "pem" {
+		t.Fatalf("unexpected output settings of the first entry: %+v", one)
+	}
+
+	two, err := m.Certificates[1].commandFlags(&base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if two.keyPassword != "secret" || two.friendlyName != "two" || two.keySize != 4096 {
+		t.Fatalf("unexpected settings of the second entry: %+v", two)
+	}
+	if len(one.customFields) != 1 || len(two.customFields) != 0 {
+		t.Fatalf("custom fields leaked between entries")
+	}
+}
+
+func TestReadManifestErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcertManifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := map[string]string{
+		"unknown key":   "certificates:\n  - cn: a.example.com\n    common-name: a\n    file: a.pem\n",
+		"no entries":    "certificates: []\n",
+		"no output":     "certificates:\n  - cn: a.example.com\n",
+		"no cn":         "certificates:\n  - file: a.pem\n",
+		"bad key type":  "certificates:\n  - cn: a.example.com\n    key-type: dsa\n    file: a.pem\n",
+		"bad san ip":    "certificates:\n  - cn: a.example.com\n    san-ip: [a.b.c.d]\n    file: a.pem\n",
+		"bad field":     "certificates:\n  - cn: a.example.com\n    fields: [novalue]\n    file: a.pem\n",
+		"pkcs12 split":  "certificates:\n  - cn: a.example.com\n    format: pkcs12\n    cert-file: a.crt\n",
+		"jks w/o alias": "certificates:\n  - cn: a.example.com\n    format: jks\n    key-password: pass:secret1\n    file: a.jks\n",
+		"csr from file": "certificates:\n  - cn: a.example.com\n    csr: file:a.csr\n    file: a.pem\n",
+	}
+	for name, content := range cases {
+		t.Run(name, func(t *testing.T) {
+			m, err := readManifest(writeTestManifest(t, dir, content))
+			if err != nil {
+				return
+			}
+			for _, e := range m.Certificates {
+				if _, err = e.commandFlags(&commandFlags{format: "pem"}); err != nil {
+					return
+				}
+			}
+			t.Fatal("manifest should be rejected")
+		})
+	}
+}
@@ -58,7 +58,7 @@ func readPasswordsFromInputFlags(commandName string, cf *commandFlags) error {
 		}
 	}
 
-	if commandName == commandEnrollName || commandName == commandGenCSRName || commandName == commandRenewName || commandName == commandPickupName && (cf.format == "pkcs12" || cf.format == JKSFormat) {
+	if commandName == commandEnrollName || commandName == commandGenCSRName || commandName == commandRenewName || (commandName == commandPickupName || commandName == commandConvertName) && (cf.format == "pkcs12" || cf.format == JKSFormat) {
 		var keyPasswordNotNeeded = false
 
 		keyPasswordNotNeeded = keyPasswordNotNeeded || (cf.csrOption == "service" && cf.noPickup)
@@ -81,7 +81,7 @@ func readPasswordsFromInputFlags(commandName string, cf *commandFlags) error {
 					return fmt.Errorf("Pass phrases don't match")
 				}
 				cf.keyPassword = string(input)
-			} else if cf.keyPassword == "" && cf.noPrompt && commandName == commandPickupName {
+			} else if cf.keyPassword == "" && cf.noPrompt && (commandName == commandPickupName || commandName == commandConvertName) {
 				//TODO: cover with test
 				return fmt.Errorf("key password must be provided")
 			} else {
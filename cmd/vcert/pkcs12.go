@@ -0,0 +1,727 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"hash"
+	"math/big"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// PKCS#12 files are built here rather than with go-pkcs12 (RFC 7292). The version vcert builds with only supports
+// legacy algorithms, and later ones, which require Go 1.19, still cannot give the private key a friendly name.
+
+const (
+	// PKCS12ProfileLegacy encrypts with 3DES and authenticates with SHA-1, which old Java and Windows versions require
+	PKCS12ProfileLegacy = "legacy"
+	// PKCS12ProfileModern encrypts with AES-256 and PBKDF2 and authenticates with SHA-256, like OpenSSL 3
+	PKCS12ProfileModern = "modern"
+)
+
+var (
+	oidDataContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidShroudedKeyBag           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag                  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidJavaTrustedKeyUsage      = asn1.ObjectIdentifier{2, 16, 840, 1, 113894, 746875, 1, 1}
+	oidAnyExtendedKeyUsage      = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+	oidSHA1                     = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256                   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidPBEWithSHAAnd3KeyTDES    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBES2                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2                   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1             = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256           = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC                = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+const (
+	pkcs12SaltLen          = 16
+	pkcs12EncKeyDerivation = 1
+	pkcs12EncIVDerivation  = 2
+	pkcs12MacKeyDerivation = 3
+)
+
+type pkcs12Profile struct {
+	iterations int
+	macHash    func() hash.Hash
+	macOID     asn1.ObjectIdentifier
+	modern     bool
+}
+
+var pkcs12Profiles = map[string]pkcs12Profile{
+	PKCS12ProfileLegacy: {iterations: 2048, macHash: sha1.New, macOID: oidSHA1},
+	PKCS12ProfileModern: {iterations: 10000, macHash: sha256.New, macOID: oidSHA256, modern: true},
+}
+
+// getPKCS12Profile returns the named profile, the legacy one by default for compatibility with earlier versions
+func getPKCS12Profile(name string) (pkcs12Profile, error) {
+	if name == "" {
+		name = PKCS12ProfileLegacy
+	}
+	profile, ok := pkcs12Profiles[name]
+	if !ok {
+		return profile, fmt.Errorf("unknown PKCS#12 profile %q, specify %s or %s", name, PKCS12ProfileLegacy, PKCS12ProfileModern)
+	}
+	return profile, nil
+}
+
+type pkcs12ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type pkcs12EncryptedData struct {
+	Version              int
+	EncryptedContentInfo pkcs12EncryptedContentInfo
+}
+
+type pkcs12EncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type pkcs12EncryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pkcs12SafeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type pkcs12CertBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type pkcs12DigestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type pkcs12MacData struct {
+	Mac        pkcs12DigestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type pkcs12PFX struct {
+	Version  int
+	AuthSafe pkcs12ContentInfo
+	MacData  pkcs12MacData `asn1:"optional"`
+}
+
+type pkcs12PBEParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pkcs12PBES2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pkcs12PBKDF2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// encodePKCS12 returns a PKCS#12 file with the private key and cert, followed by the chain. The key and cert
+// are tied to each other by a localKeyId attribute and carry friendlyName, which is what most tools show.
+func encodePKCS12(key interface{}, cert *x509.Certificate, chain []*x509.Certificate, friendlyName, password string, profile pkcs12Profile) ([]byte, error) {
+	localKeyID := sha1.Sum(cert.Raw)
+	attributes, err := pkcs12KeyAttributes(friendlyName, localKeyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	certBag, err := pkcs12NewCertBag(cert, attributes)
+	if err != nil {
+		return nil, err
+	}
+	certBags := []pkcs12SafeBag{certBag}
+	for _, chainCert := range chain {
+		bag, err := pkcs12NewCertBag(chainCert, nil)
+		if err != nil {
+			return nil, err
+		}
+		certBags = append(certBags, bag)
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("private key error: %s", err)
+	}
+	algorithm, encrypted, err := pkcs12Encrypt(profile, password, pkcs8)
+	if err != nil {
+		return nil, err
+	}
+	shroudedKey, err := asn1.Marshal(pkcs12EncryptedPrivateKeyInfo{Algorithm: algorithm, EncryptedData: encrypted})
+	if err != nil {
+		return nil, err
+	}
+	keyBag := pkcs12SafeBag{
+		ID:         oidShroudedKeyBag,
+		Value:      asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: shroudedKey},
+		Attributes: attributes,
+	}
+
+	// like OpenSSL, the certificates are encrypted as a whole and the already shrouded key is not
+	certSafe, err := pkcs12EncryptedContentInfoFor(profile, password, certBags)
+	if err != nil {
+		return nil, err
+	}
+	keySafeContents, err := asn1.Marshal([]pkcs12SafeBag{keyBag})
+	if err != nil {
+		return nil, err
+	}
+	keySafe, err := pkcs12DataContentInfo(keySafeContents)
+	if err != nil {
+		return nil, err
+	}
+	authSafe, err := asn1.Marshal([]pkcs12ContentInfo{certSafe, keySafe})
+	if err != nil {
+		return nil, err
+	}
+	return pkcs12Seal(authSafe, password, profile)
+}
+
+// encodePKCS12TrustStore returns a PKCS#12 file with certs as trusted certificate entries, named by aliases.
+// The entries are not encrypted, since they hold no secrets, but the file is protected by a MAC.
+func encodePKCS12TrustStore(certs []*x509.Certificate, aliases []string, password string, profile pkcs12Profile) ([]byte, error) {
+	var bags []pkcs12SafeBag
+	for i, cert := range certs {
+		friendlyName, err := pkcs12FriendlyName(aliases[i])
+		if err != nil {
+			return nil, err
+		}
+		trustedUsage, err := asn1.Marshal(oidAnyExtendedKeyUsage)
+		if err != nil {
+			return nil, err
+		}
+		bag, err := pkcs12NewCertBag(cert, []pkcs12Attribute{
+			friendlyName,
+			{ID: oidJavaTrustedKeyUsage, Value: pkcs12AttributeValue(trustedUsage)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		bags = append(bags, bag)
+	}
+	safeContents, err := asn1.Marshal(bags)
+	if err != nil {
+		return nil, err
+	}
+	safe, err := pkcs12DataContentInfo(safeContents)
+	if err != nil {
+		return nil, err
+	}
+	authSafe, err := asn1.Marshal([]pkcs12ContentInfo{safe})
+	if err != nil {
+		return nil, err
+	}
+	return pkcs12Seal(authSafe, password, profile)
+}
+
+// pkcs12Bundle is the content of a PKCS#12 file read by decodePKCS12
+type pkcs12Bundle struct {
+	key interface{}
+	// certs starts with the certificate of the key, if there is one
+	certs        []*x509.Certificate
+	friendlyName string
+}
+
+// decodePKCS12 reads the key and certificates written by encodePKCS12 or encodePKCS12TrustStore,
+// and by other tools as long as they use the algorithms of either profile
+func decodePKCS12(data []byte, password string) (*pkcs12Bundle, error) {
+	var pfx pkcs12PFX
+	if rest, err := asn1.Unmarshal(data, &pfx); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("PKCS#12 parse error: not a PKCS#12 file")
+	}
+	if !pfx.AuthSafe.ContentType.Equal(oidDataContentType) {
+		return nil, fmt.Errorf("PKCS#12 parse error: only password integrity is supported")
+	}
+	var authSafe []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		return nil, fmt.Errorf("PKCS#12 parse error: %s", err)
+	}
+	if len(pfx.MacData.Mac.Digest) > 0 {
+		if err := pkcs12VerifyMac(&pfx.MacData, authSafe, password); err != nil {
+			return nil, err
+		}
+	}
+
+	var contents []pkcs12ContentInfo
+	if _, err := asn1.Unmarshal(authSafe, &contents); err != nil {
+		return nil, fmt.Errorf("PKCS#12 parse error: %s", err)
+	}
+	var bags []pkcs12SafeBag
+	for _, ci := range contents {
+		var safeContents []byte
+		switch {
+		case ci.ContentType.Equal(oidDataContentType):
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &safeContents); err != nil {
+				return nil, fmt.Errorf("PKCS#12 parse error: %s", err)
+			}
+		case ci.ContentType.Equal(oidEncryptedDataContentType):
+			var encryptedData pkcs12EncryptedData
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &encryptedData); err != nil {
+				return nil, fmt.Errorf("PKCS#12 parse error: %s", err)
+			}
+			info := encryptedData.EncryptedContentInfo
+			var err error
+			safeContents, err = pkcs12Decrypt(info.ContentEncryptionAlgorithm, password, info.EncryptedContent)
+			if err != nil {
+				return nil, fmt.Errorf("PKCS#12 certificates: %s", err)
+			}
+		default:
+			return nil, fmt.Errorf("PKCS#12 parse error: unsupported content type %s", ci.ContentType)
+		}
+		var safe []pkcs12SafeBag
+		if _, err := asn1.Unmarshal(safeContents, &safe); err != nil {
+			return nil, fmt.Errorf("PKCS#12 parse error: %s", err)
+		}
+		bags = append(bags, safe...)
+	}
+
+	bundle := &pkcs12Bundle{}
+	var keyID []byte
+	var certIDs [][]byte
+	var certNames []string
+	for _, bag := range bags {
+		name, localKeyID := pkcs12BagAttributes(bag.Attributes)
+		switch {
+		case bag.ID.Equal(oidShroudedKeyBag):
+			if bundle.key != nil {
+				return nil, fmt.Errorf("PKCS#12 files with more than one private key are not supported")
+			}
+			var shroudedKey pkcs12EncryptedPrivateKeyInfo
+			if _, err := asn1.Unmarshal(bag.Value.Bytes, &shroudedKey); err != nil {
+				return nil, fmt.Errorf("PKCS#12 parse error: %s", err)
+			}
+			pkcs8, err := pkcs12Decrypt(shroudedKey.Algorithm, password, shroudedKey.EncryptedData)
+			if err != nil {
+				return nil, fmt.Errorf("PKCS#12 private key: %s", err)
+			}
+			if bundle.key, err = x509.ParsePKCS8PrivateKey(pkcs8); err != nil {
+				return nil, fmt.Errorf("PKCS#12 private key: %s", err)
+			}
+			keyID = localKeyID
+			bundle.friendlyName = name
+		case bag.ID.Equal(oidCertBag):
+			var certBag pkcs12CertBag
+			if _, err := asn1.Unmarshal(bag.Value.Bytes, &certBag); err != nil {
+				return nil, fmt.Errorf("PKCS#12 parse error: %s", err)
+			}
+			if !certBag.ID.Equal(oidCertTypeX509) {
+				continue
+			}
+			cert, err := x509.ParseCertificate(certBag.Data)
+			if err != nil {
+				return nil, fmt.Errorf("PKCS#12 certificate: %s", err)
+			}
+			bundle.certs = append(bundle.certs, cert)
+			certIDs = append(certIDs, localKeyID)
+			certNames = append(certNames, name)
+		}
+	}
+
+	// the certificate of the key goes first
+	for i := range bundle.certs {
+		if keyID != nil && bytes.Equal(certIDs[i], keyID) {
+			bundle.certs[0], bundle.certs[i] = bundle.certs[i], bundle.certs[0]
+			certNames[0], certNames[i] = certNames[i], certNames[0]
+			break
+		}
+	}
+	if bundle.friendlyName == "" && len(certNames) > 0 {
+		bundle.friendlyName = certNames[0]
+	}
+	return bundle, nil
+}
+
+func pkcs12VerifyMac(macData *pkcs12MacData, authSafe []byte, password string) error {
+	var h func() hash.Hash
+	switch {
+	case macData.Mac.Algorithm.Algorithm.Equal(oidSHA1):
+		h = sha1.New
+	case macData.Mac.Algorithm.Algorithm.Equal(oidSHA256):
+		h = sha256.New
+	default:
+		return fmt.Errorf("PKCS#12 parse error: unsupported MAC algorithm %s", macData.Mac.Algorithm.Algorithm)
+	}
+	key := pkcs12KDF(h, pkcs12MacKeyDerivation, bmpPassword(password), macData.MacSalt, macData.Iterations, h().Size())
+	mac := hmac.New(h, key)
+	mac.Write(authSafe)
+	if !hmac.Equal(mac.Sum(nil), macData.Mac.Digest) {
+		return fmt.Errorf("PKCS#12 MAC verification failed, the password may be wrong")
+	}
+	return nil
+}
+
+// pkcs12BagAttributes returns the friendlyName and localKeyId of a bag, if present
+func pkcs12BagAttributes(attributes []pkcs12Attribute) (friendlyName string, localKeyID []byte) {
+	for _, attribute := range attributes {
+		switch {
+		case attribute.ID.Equal(oidFriendlyName):
+			var value asn1.RawValue
+			if _, err := asn1.Unmarshal(attribute.Value.Bytes, &value); err == nil && value.Tag == asn1.TagBMPString {
+				friendlyName = decodeBMPString(value.Bytes)
+			}
+		case attribute.ID.Equal(oidLocalKeyID):
+			_, _ = asn1.Unmarshal(attribute.Value.Bytes, &localKeyID)
+		}
+	}
+	return friendlyName, localKeyID
+}
+
+func pkcs12NewCertBag(cert *x509.Certificate, attributes []pkcs12Attribute) (bag pkcs12SafeBag, err error) {
+	certBag, err := asn1.Marshal(pkcs12CertBag{ID: oidCertTypeX509, Data: cert.Raw})
+	if err != nil {
+		return bag, err
+	}
+	bag.ID = oidCertBag
+	bag.Value = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certBag}
+	bag.Attributes = attributes
+	return bag, nil
+}
+
+func pkcs12KeyAttributes(friendlyName string, localKeyID []byte) ([]pkcs12Attribute, error) {
+	keyID, err := asn1.Marshal(localKeyID)
+	if err != nil {
+		return nil, err
+	}
+	attributes := []pkcs12Attribute{{ID: oidLocalKeyID, Value: pkcs12AttributeValue(keyID)}}
+	if friendlyName != "" {
+		name, err := pkcs12FriendlyName(friendlyName)
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, name)
+	}
+	return attributes, nil
+}
+
+func pkcs12FriendlyName(name string) (pkcs12Attribute, error) {
+	value, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: bmpString(name)})
+	if err != nil {
+		return pkcs12Attribute{}, err
+	}
+	return pkcs12Attribute{ID: oidFriendlyName, Value: pkcs12AttributeValue(value)}, nil
+}
+
+// pkcs12AttributeValue wraps a single encoded value into the SET of attribute values
+func pkcs12AttributeValue(value []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: value}
+}
+
+func pkcs12DataContentInfo(data []byte) (ci pkcs12ContentInfo, err error) {
+	content, err := asn1.Marshal(data)
+	if err != nil {
+		return ci, err
+	}
+	ci.ContentType = oidDataContentType
+	ci.Content = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content}
+	return ci, nil
+}
+
+func pkcs12EncryptedContentInfoFor(profile pkcs12Profile, password string, bags []pkcs12SafeBag) (ci pkcs12ContentInfo, err error) {
+	safeContents, err := asn1.Marshal(bags)
+	if err != nil {
+		return ci, err
+	}
+	algorithm, encrypted, err := pkcs12Encrypt(profile, password, safeContents)
+	if err != nil {
+		return ci, err
+	}
+	content, err := asn1.Marshal(pkcs12EncryptedData{
+		EncryptedContentInfo: pkcs12EncryptedContentInfo{
+			ContentType:                oidDataContentType,
+			ContentEncryptionAlgorithm: algorithm,
+			EncryptedContent:           encrypted,
+		},
+	})
+	if err != nil {
+		return ci, err
+	}
+	ci.ContentType = oidEncryptedDataContentType
+	ci.Content = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content}
+	return ci, nil
+}
+
+// pkcs12Seal wraps the encoded AuthenticatedSafe into a PFX with an HMAC over it
+func pkcs12Seal(authSafe []byte, password string, profile pkcs12Profile) ([]byte, error) {
+	salt := make([]byte, pkcs12SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key := pkcs12KDF(profile.macHash, pkcs12MacKeyDerivation, bmpPassword(password), salt, profile.iterations, profile.macHash().Size())
+	mac := hmac.New(profile.macHash, key)
+	mac.Write(authSafe)
+
+	content, err := pkcs12DataContentInfo(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	pfx := pkcs12PFX{
+		Version:  3,
+		AuthSafe: content,
+		MacData: pkcs12MacData{
+			Mac: pkcs12DigestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: profile.macOID, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    salt,
+			Iterations: profile.iterations,
+		},
+	}
+	b, err := asn1.Marshal(pfx)
+	if err != nil {
+		return nil, fmt.Errorf("PKCS#12 encoding error: %s", err)
+	}
+	return b, nil
+}
+
+// pkcs12Encrypt encrypts data with a random salt, and IV for the modern profile, returning the algorithm with its parameters
+func pkcs12Encrypt(profile pkcs12Profile, password string, data []byte) (pkix.AlgorithmIdentifier, []byte, error) {
+	var algorithm pkix.AlgorithmIdentifier
+	salt := make([]byte, pkcs12SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return algorithm, nil, err
+	}
+
+	var block cipher.Block
+	var iv []byte
+	var err error
+	if profile.modern {
+		iv = make([]byte, aes.BlockSize)
+		if _, err = rand.Read(iv); err != nil {
+			return algorithm, nil, err
+		}
+		block, err = aes.NewCipher(pbkdf2.Key([]byte(password), salt, profile.iterations, 32, sha256.New))
+		if err != nil {
+			return algorithm, nil, err
+		}
+		algorithm, err = pbes2Algorithm(salt, iv, profile.iterations)
+	} else {
+		var key []byte
+		key, iv = pbeTripleDESKey(password, salt, profile.iterations)
+		block, err = des.NewTripleDESCipher(key)
+		if err != nil {
+			return algorithm, nil, err
+		}
+		algorithm.Algorithm = oidPBEWithSHAAnd3KeyTDES
+		algorithm.Parameters.FullBytes, err = asn1.Marshal(pkcs12PBEParams{Salt: salt, Iterations: profile.iterations})
+	}
+	if err != nil {
+		return algorithm, nil, err
+	}
+
+	padding := block.BlockSize() - len(data)%block.BlockSize()
+	encrypted := append(append([]byte{}, data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+	return algorithm, encrypted, nil
+}
+
+// pkcs12Decrypt reverses pkcs12Encrypt for either profile
+func pkcs12Decrypt(algorithm pkix.AlgorithmIdentifier, password string, encrypted []byte) ([]byte, error) {
+	var block cipher.Block
+	var iv []byte
+	switch {
+	case algorithm.Algorithm.Equal(oidPBEWithSHAAnd3KeyTDES):
+		var params pkcs12PBEParams
+		if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		var key []byte
+		key, iv = pbeTripleDESKey(password, params.Salt, params.Iterations)
+		var err error
+		if block, err = des.NewTripleDESCipher(key); err != nil {
+			return nil, err
+		}
+	case algorithm.Algorithm.Equal(oidPBES2):
+		var params pkcs12PBES2Params
+		if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		var kdf pkcs12PBKDF2Params
+		if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+			return nil, err
+		}
+		if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) || !params.EncryptionScheme.Algorithm.Equal(oidAES256CBC) {
+			return nil, fmt.Errorf("unsupported PBES2 algorithms")
+		}
+		// the PRF defaults to hmacWithSHA1, which DER therefore leaves out (RFC 8018, appendix A.2)
+		var prf func() hash.Hash
+		switch {
+		case len(kdf.PRF.Algorithm) == 0, kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+			prf = sha1.New
+		case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+			prf = sha256.New
+		default:
+			return nil, fmt.Errorf("unsupported PBKDF2 PRF %s", kdf.PRF.Algorithm)
+		}
+		if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+			return nil, err
+		}
+		var err error
+		if block, err = aes.NewCipher(pbkdf2.Key([]byte(password), kdf.Salt, kdf.Iterations, 32, prf)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encryption algorithm %s", algorithm.Algorithm)
+	}
+
+	if len(encrypted) == 0 || len(encrypted)%block.BlockSize() != 0 || len(iv) != block.BlockSize() {
+		return nil, fmt.Errorf("invalid encrypted data")
+	}
+	data := make([]byte, len(encrypted))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, encrypted)
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > block.BlockSize() || !bytes.Equal(data[len(data)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, fmt.Errorf("decryption failed, the password may be wrong")
+	}
+	return data[:len(data)-padding], nil
+}
+
+func pbes2Algorithm(salt, iv []byte, iterations int) (algorithm pkix.AlgorithmIdentifier, err error) {
+	kdfParams, err := asn1.Marshal(pkcs12PBKDF2Params{
+		Salt:       salt,
+		Iterations: iterations,
+		PRF:        pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return algorithm, err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return algorithm, err
+	}
+	params, err := asn1.Marshal(pkcs12PBES2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return algorithm, err
+	}
+	algorithm.Algorithm = oidPBES2
+	algorithm.Parameters.FullBytes = params
+	return algorithm, nil
+}
+
+func pbeTripleDESKey(password string, salt []byte, iterations int) (key, iv []byte) {
+	key = pkcs12KDF(sha1.New, pkcs12EncKeyDerivation, bmpPassword(password), salt, iterations, 24)
+	iv = pkcs12KDF(sha1.New, pkcs12EncIVDerivation, bmpPassword(password), salt, iterations, des.BlockSize)
+	return key, iv
+}
+
+// pkcs12KDF derives size bytes of key material for the given purpose as described in RFC 7292, appendix B.2
+func pkcs12KDF(h func() hash.Hash, id byte, password, salt []byte, iterations, size int) []byte {
+	v := h().BlockSize()
+
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	d := bytes.Repeat([]byte{id}, v)
+	in := append(fill(salt), fill(password)...)
+
+	one := big.NewInt(1)
+	var out []byte
+	for len(out) < size {
+		a := h()
+		a.Write(d)
+		a.Write(in)
+		sum := a.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			a = h()
+			a.Write(sum)
+			sum = a.Sum(nil)
+		}
+		out = append(out, sum...)
+
+		// I_j = (I_j + B + 1) mod 2^(v*8) for every v byte block of I
+		b := new(big.Int).SetBytes(fill(sum))
+		b.Add(b, one)
+		for j := 0; j < len(in); j += v {
+			block := new(big.Int).SetBytes(in[j : j+v])
+			block.Add(block, b)
+			sumBytes := block.Bytes()
+			if len(sumBytes) > v {
+				sumBytes = sumBytes[len(sumBytes)-v:]
+			}
+			copy(in[j:j+v], make([]byte, v))
+			copy(in[j+v-len(sumBytes):j+v], sumBytes)
+		}
+	}
+	return out[:size]
+}
+
+func bmpString(s string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(r>>8), byte(r))
+	}
+	return b
+}
+
+func decodeBMPString(b []byte) string {
+	var s []uint16
+	for i := 0; i+1 < len(b); i += 2 {
+		s = append(s, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(s))
+}
+
+// bmpPassword encodes a password for key derivation, which includes the terminating zero
+func bmpPassword(password string) []byte {
+	return append(bmpString(password), 0, 0)
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+)
+
+const (
+	DERFormat   = "der"
+	PKCS7Format = "pkcs7"
+)
+
+var oidSignedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+// pkcs7SignedData is a SignedData without signers, which only serves as a container of certificates (RFC 2315)
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      pkcs12ContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos      asn1.RawValue
+}
+
+// AsDER returns the certificate alone in DER encoding
+func (o *Output) AsDER() ([]byte, error) {
+	return pemToDER(o.Certificate)
+}
+
+// AsPKCS7 returns the certificate and the chain in a degenerate PKCS#7 SignedData, ordered by the chain option.
+// Either of them may be empty.
+func (o *Output) AsPKCS7(c *Config) ([]byte, error) {
+	var pems []string
+	if o.Certificate != "" {
+		pems = append(pems, o.Certificate)
+	}
+	if c.ChainOption == certificate.ChainOptionRootFirst {
+		pems = append(o.Chain[:len(o.Chain):len(o.Chain)], pems...)
+	} else if c.ChainOption != certificate.ChainOptionIgnore {
+		pems = append(pems, o.Chain...)
+	}
+
+	var certs []byte
+	for _, p := range pems {
+		der, err := pemToDER(p)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, der...)
+	}
+	emptySet := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      pkcs12ContentInfo{ContentType: oidDataContentType},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos:      emptySet,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("PKCS#7 encoding error: %s", err)
+	}
+	b, err := asn1.Marshal(pkcs12ContentInfo{
+		ContentType: oidSignedDataContentType,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("PKCS#7 encoding error: %s", err)
+	}
+	return b, nil
+}
+
+func pemToDER(certPEM string) ([]byte, error) {
+	p, _ := pem.Decode([]byte(certPEM))
+	if p == nil || p.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("certificate parse error")
+	}
+	return p.Bytes, nil
+}
+
+// parseCertificates reads all certificates from data, which may be PEM, DER or PKCS#7, either DER or PEM encoded
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	if block, _ := pem.Decode(data); block == nil {
+		if isPKCS7(data) {
+			return parsePKCS7(data)
+		}
+		return x509.ParseCertificates(data)
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+		case "PKCS7":
+			p7, err := parsePKCS7(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, p7...)
+		}
+	}
+	return certs, nil
+}
+
+func isPKCS7(data []byte) bool {
+	var ci pkcs12ContentInfo
+	_, err := asn1.Unmarshal(data, &ci)
+	return err == nil && ci.ContentType.Equal(oidSignedDataContentType)
+}
+
+func parsePKCS7(data []byte) ([]*x509.Certificate, error) {
+	var ci pkcs12ContentInfo
+	if _, err := asn1.Unmarshal(data, &ci); err != nil {
+		return nil, fmt.Errorf("PKCS#7 parse error: %s", err)
+	}
+	if !ci.ContentType.Equal(oidSignedDataContentType) {
+		return nil, fmt.Errorf("PKCS#7 parse error: unexpected content type %s", ci.ContentType)
+	}
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &signedData); err != nil {
+		return nil, fmt.Errorf("PKCS#7 parse error: %s", err)
+	}
+	return x509.ParseCertificates(signedData.Certificates.Bytes)
+}
+
+// numberedFileName inserts n before the extension of fileName, e.g. chain.cer becomes chain-1.cer
+func numberedFileName(fileName string, n int) string {
+	ext := filepath.Ext(fileName)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(fileName, ext), n, ext)
+}
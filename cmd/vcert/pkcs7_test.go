@@ -0,0 +1,97 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+)
+
+func TestPKCS7RoundTrip(t *testing.T) {
+	output := &Output{Certificate: cert, Chain: chain}
+	b, err := output.Format(&Config{Format: PKCS7Format, ChainOption: certificate.ChainOptionRootFirst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := parseCertificates(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 3 || certs[2].Subject.CommonName != "q" {
+		t.Fatalf("expected the chain followed by the certificate, got %d certificates", len(certs))
+	}
+
+	// the same bundle in PEM, as written by openssl crl2pkcs7
+	certs, err = parseCertificates(pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: b}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 3 {
+		t.Fatalf("expected 3 certificates, got %d", len(certs))
+	}
+}
+
+func TestDERFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcertDER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	result := &Result{
+		Pcc: &certificate.PEMCollection{Certificate: cert, PrivateKey: PK, Chain: chain},
+		Config: &Config{
+			Command:   "pickup",
+			Format:    DERFormat,
+			CertFile:  filepath.Join(dir, "cert.cer"),
+			ChainFile: filepath.Join(dir, "chain.cer"),
+			KeyFile:   filepath.Join(dir, "key.pem"),
+		},
+	}
+	if err := result.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := readLeafCertificate(filepath.Join(dir, "cert.cer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Subject.CommonName != "q" {
+		t.Fatalf("unexpected certificate %s", leaf.Subject)
+	}
+	for _, name := range []string{"chain-1.cer", "chain-2.cer"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseCertificates(b); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+	}
+	key, err := ioutil.ReadFile(filepath.Join(dir, "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(key) != PK {
+		t.Fatal("private key should remain PEM")
+	}
+}
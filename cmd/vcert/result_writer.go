@@ -18,7 +18,6 @@ package main
 
 import (
 	"bytes"
-	"crypto/rand"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
@@ -27,7 +26,9 @@ import (
 	"github.com/pavel-v-chernykh/keystore-go/v4"
 	"io/ioutil"
 	"os"
-	"software.sslmate.com/src/go-pkcs12"
+	"os/user"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -39,6 +40,13 @@ type Config struct {
 	JKSPassword string
 	ChainOption certificate.ChainOption
 
+	// PKCS12Profile selects the algorithms of PKCS#12 files, see pkcs12Profiles
+	PKCS12Profile string
+	// PKCS12TrustStore writes the certificate and the chain to a PKCS#12 file as trusted entries, without the private key
+	PKCS12TrustStore bool
+	// FriendlyName names the entry of the PKCS#12 file, defaults to the common name of the certificate
+	FriendlyName string
+
 	AllFile      string
 	KeyFile      string
 	CertFile     string
@@ -47,6 +55,45 @@ type Config struct {
 	PickupIdFile string
 
 	KeyPassword string
+	// Backup keeps the previous content of the output files with .bak appended to their names
+	Backup bool
+	// FileMode defaults to 0600, FileOwner and FileGroup are user and group names or IDs
+	FileMode  os.FileMode
+	FileOwner string
+	FileGroup string
+
+	Secret k8sSecretConfig
+
+	// Layout is one of layouts, its files are written to OutputDir
+	Layout    string
+	OutputDir string
+}
+
+// resultConfig returns the config of the files and formats a command writes its results with, from its flags
+func resultConfig(cf *commandFlags, command string) *Config {
+	return &Config{
+		Command:          command,
+		Format:           cf.format,
+		JKSAlias:         cf.jksAlias,
+		JKSPassword:      cf.jksPassword,
+		PKCS12Profile:    cf.pkcs12Profile,
+		PKCS12TrustStore: cf.pkcs12TrustStore,
+		FriendlyName:     cf.friendlyName,
+		ChainOption:      certificate.ChainOptionFromString(cf.chainOption),
+		AllFile:          cf.file,
+		KeyFile:          cf.keyFile,
+		CertFile:         cf.certFile,
+		ChainFile:        cf.chainFile,
+		PickupIdFile:     cf.pickupIDFile,
+		KeyPassword:      cf.keyPassword,
+		Backup:           cf.backup,
+		FileMode:         cf.fileMode,
+		FileOwner:        cf.fileOwner,
+		FileGroup:        cf.fileGroup,
+		Secret:           cf.secret,
+		Layout:           cf.layout,
+		OutputDir:        cf.outputDir,
+	}
 }
 
 type Result struct {
@@ -64,6 +111,13 @@ type Output struct {
 }
 
 func (o *Output) AsPKCS12(c *Config) ([]byte, error) {
+	profile, err := getPKCS12Profile(c.PKCS12Profile)
+	if err != nil {
+		return nil, err
+	}
+	if c.PKCS12TrustStore {
+		return o.asPKCS12TrustStore(c, profile)
+	}
 	if len(o.Certificate) == 0 || len(o.PrivateKey) == 0 {
 		return nil, fmt.Errorf("at least certificate and private key are required")
 	}
@@ -114,7 +168,11 @@ func (o *Output) AsPKCS12(c *Config) ([]byte, error) {
 		return nil, fmt.Errorf("private key error(3): %s", err)
 	}
 
-	bytes, err := pkcs12.Encode(rand.Reader, privKey, cert, chain_list, c.KeyPassword)
+	friendlyName := c.FriendlyName
+	if friendlyName == "" {
+		friendlyName = cert.Subject.CommonName
+	}
+	bytes, err := encodePKCS12(privKey, cert, chain_list, friendlyName, c.KeyPassword, profile)
 	if err != nil {
 		return nil, fmt.Errorf("encode error: %s", err)
 	}
@@ -122,6 +180,38 @@ func (o *Output) AsPKCS12(c *Config) ([]byte, error) {
 	return bytes, nil
 }
 
+// asPKCS12TrustStore returns the certificate, if any, and the chain as trusted entries of a PKCS#12 file
+func (o *Output) asPKCS12TrustStore(c *Config, profile pkcs12Profile) ([]byte, error) {
+	pems := o.Chain
+	if o.Certificate != "" {
+		pems = append([]string{o.Certificate}, o.Chain...)
+	}
+	if len(pems) == 0 {
+		return nil, fmt.Errorf("at least certificate is required")
+	}
+	var certs []*x509.Certificate
+	var aliases []string
+	used := map[string]bool{}
+	for i, p := range pems {
+		der, err := pemToDER(p)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("certificate parse error: %s", err)
+		}
+		certs = append(certs, cert)
+		if i == 0 && c.FriendlyName != "" {
+			used[c.FriendlyName] = true
+			aliases = append(aliases, c.FriendlyName)
+		} else {
+			aliases = append(aliases, truststoreAlias(cert, used))
+		}
+	}
+	return encodePKCS12TrustStore(certs, aliases, c.KeyPassword, profile)
+}
+
 func (o *Output) AsJKS(c *Config) ([]byte, error) {
 
 	var err interface{}
@@ -231,6 +321,21 @@ func (o *Output) Format(c *Config) ([]byte, error) {
 		}
 		return b, nil
 
+	case K8sSecretFormat:
+		return o.AsK8sSecret(c)
+
+	case DERFormat, PKCS7Format:
+		if o.Certificate == "" && len(o.Chain) == 0 {
+			// the private key, CSR and pickup ID have no binary form of their own
+			pemConfig := *c
+			pemConfig.Format = "pem"
+			return o.Format(&pemConfig)
+		}
+		if c.Format == DERFormat {
+			return o.AsDER()
+		}
+		return o.AsPKCS7(c)
+
 	default: // pem
 		res := ""
 		switch c.ChainOption {
@@ -277,17 +382,40 @@ func (r *Result) formatChain(xs []string) string {
 	}
 }
 
+// Flush writes the results to the files from the config and the rest to STDOUT
 func (r *Result) Flush() error {
+	stdOut, err := r.writeFiles()
+	if stdOut == nil {
+		return err
+	}
+
+	// and flush the rest to STDOUT
+	bytes, formatErr := stdOut.Format(r.Config)
+	if formatErr != nil {
+		return formatErr // something worse than file permission problem
+	}
+	fmt.Fprint(os.Stdout, string(bytes))
+	return err
+}
+
+// writeFiles writes the results to the files from the config and returns what is left for STDOUT.
+// The returned output is nil if the results could not be encoded at all.
+func (r *Result) writeFiles() (*Output, error) {
 	var err error
-	var errors []error
+	files := &fileSet{config: r.Config}
 
 	if r.Pcc == nil {
-		return fmt.Errorf("couldn't construct output: certificate collection is null")
+		return nil, fmt.Errorf("couldn't construct output: certificate collection is null")
 	}
 
 	stdOut := &Output{}
 
-	if r.Config.AllFile != "" {
+	if r.Config.Layout != "" && r.Config.Layout != LayoutTruststore {
+		err = r.addLayout(files)
+		if err != nil {
+			return nil, err
+		}
+	} else if r.Config.AllFile != "" {
 		allFileOutput := &Output{}
 		allFileOutput.PrivateKey = r.Pcc.PrivateKey
 		allFileOutput.Certificate = r.Pcc.Certificate
@@ -298,21 +426,20 @@ func (r *Result) Flush() error {
 		if r.Config.Format == "pkcs12" {
 			bytes, err = allFileOutput.AsPKCS12(r.Config)
 			if err != nil {
-				return fmt.Errorf("failed to encode pkcs12: %s", err)
+				return nil, fmt.Errorf("failed to encode pkcs12: %s", err)
 			}
 		} else if r.Config.Format == JKSFormat {
 			bytes, err = allFileOutput.AsJKS(r.Config)
 			if err != nil {
-				return err
+				return nil, err
 			}
 		} else {
 			bytes, err = allFileOutput.Format(r.Config)
 			if err != nil {
-				return err
+				return nil, err
 			}
 		}
-		err = ioutil.WriteFile(r.Config.AllFile, bytes, 0600)
-		errors = append(errors, err)
+		files.add(r.Config.AllFile, bytes)
 	} else {
 
 		if r.Config.CertFile != "" && r.Pcc.Certificate != "" {
@@ -321,8 +448,10 @@ func (r *Result) Flush() error {
 			if r.Config.ChainFile == "" {
 				certFileOutput.Chain = r.Pcc.Chain
 			}
-			err = writeFile(certFileOutput, r, r.Config.CertFile)
-			errors = append(errors, err)
+			err = files.addOutput(certFileOutput, r, r.Config.CertFile)
+			if err != nil {
+				return nil, err
+			}
 		} else {
 			stdOut.Certificate = r.Pcc.Certificate
 		}
@@ -330,8 +459,10 @@ func (r *Result) Flush() error {
 		if r.Config.CSRFile != "" && r.Pcc.CSR != "" {
 			csrFileOutput := &Output{}
 			csrFileOutput.CSR = r.Pcc.CSR
-			err = writeFile(csrFileOutput, r, r.Config.CSRFile)
-			errors = append(errors, err)
+			err = files.addOutput(csrFileOutput, r, r.Config.CSRFile)
+			if err != nil {
+				return nil, err
+			}
 		} else {
 			stdOut.CSR = r.Pcc.CSR
 		}
@@ -339,67 +470,260 @@ func (r *Result) Flush() error {
 		if r.Config.KeyFile != "" && r.Pcc.PrivateKey != "" {
 			keyFileOutput := &Output{}
 			keyFileOutput.PrivateKey = r.Pcc.PrivateKey
-			err = writeFile(keyFileOutput, r, r.Config.KeyFile)
-			errors = append(errors, err)
+			err = files.addOutput(keyFileOutput, r, r.Config.KeyFile)
+			if err != nil {
+				return nil, err
+			}
 		} else {
 			stdOut.PrivateKey = r.Pcc.PrivateKey
 		}
 
-		if r.Config.ChainFile != "" && len(r.Pcc.Chain) > 0 {
+		if r.Config.ChainFile != "" && len(r.Pcc.Chain) > 0 && r.Config.Format == DERFormat {
+			// DER holds a single certificate, so every chain certificate gets a file of its own
+			for i, chainCert := range r.Pcc.Chain {
+				err = files.addOutput(&Output{Certificate: chainCert}, r, numberedFileName(r.Config.ChainFile, i+1))
+				if err != nil {
+					return nil, err
+				}
+			}
+		} else if r.Config.ChainFile != "" && len(r.Pcc.Chain) > 0 {
 			chainFileOutput := &Output{}
 			chainFileOutput.Chain = r.Pcc.Chain
-			err = writeFile(chainFileOutput, r, r.Config.ChainFile)
-			errors = append(errors, err)
+			err = files.addOutput(chainFileOutput, r, r.Config.ChainFile)
+			if err != nil {
+				return nil, err
+			}
 		} else if r.Config.CertFile == "" {
 			stdOut.Chain = r.Pcc.Chain
 		}
 	}
+	if r.Config.Layout == LayoutTruststore {
+		err = r.addLayout(files)
+		if err != nil {
+			return nil, err
+		}
+	}
 	// PickupId is special -- it wasn't supposed to be written to -file
 	if r.Config.Command == commandEnrollName || r.Config.Command == commandRenewName {
 		if r.Config.PickupIdFile != "" && r.PickupId != "" {
 			pickupFileOutput := &Output{}
 			pickupFileOutput.PickupId = r.PickupId
-			err = writeFile(pickupFileOutput, r, r.Config.PickupIdFile)
-			errors = append(errors, err)
+			err = files.addOutput(pickupFileOutput, r, r.Config.PickupIdFile)
+			if err != nil {
+				return nil, err
+			}
 		} else {
 			stdOut.PickupId = r.PickupId
 		}
 	}
 
-	// and flush the rest to STDOUT
-	bytes, err := stdOut.Format(r.Config)
+	err = files.commit()
 	if err != nil {
-		return err // something worse than file permission problem
+		return stdOut, fmt.Errorf("error happened on results output stage: %s", err)
+	}
+	return stdOut, nil
+}
+
+// fileSet collects the output files of a result, so that they are either all written or none of them is.
+// Otherwise a failure could leave a new certificate next to the key of the previous one.
+type fileSet struct {
+	config *Config
+	names  []string
+	data   [][]byte
+}
+
+func (s *fileSet) add(fileName string, data []byte) {
+	s.names = append(s.names, fileName)
+	s.data = append(s.data, data)
+}
+
+func (s *fileSet) addOutput(output *Output, result *Result, filePath string) error {
+	if output.Certificate != "" || output.PrivateKey != "" || output.CSR != "" || len(output.Chain) > 0 {
+		bytes, err := output.Format(result.Config)
+		if err != nil {
+			return err // something worse than file permission problem
+		}
+		s.add(filePath, bytes)
+	} else if output.PickupId != "" {
+		s.add(filePath, []byte(result.PickupId+"\n"))
+	}
+	return nil
+}
+
+// commit writes all files to temporary files next to their destinations first, and only then renames
+// them over the destinations. Destinations that were already replaced when a rename fails are restored.
+func (s *fileSet) commit() error {
+	perm := s.config.FileMode
+	if perm == 0 {
+		perm = 0600
+	}
+	uid, gid, err := lookupFileOwner(s.config.FileOwner, s.config.FileGroup)
+	if err != nil {
+		return err
+	}
+
+	tmpNames := make([]string, len(s.names))
+	defer func() {
+		for _, tmpName := range tmpNames {
+			if tmpName != "" {
+				os.Remove(tmpName)
+			}
+		}
+	}()
+	for i, fileName := range s.names {
+		tmpNames[i], err = writeTempFile(fileName, s.data[i], perm, uid, gid)
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %s", fileName, err)
+		}
 	}
-	fmt.Fprint(os.Stdout, string(bytes))
 
-	var finalError error
-	for _, e := range errors {
-		if e != nil {
-			if finalError == nil {
-				finalError = fmt.Errorf("error(s) happened on results output stage: ")
+	previous := make([]previousFile, len(s.names))
+	for i, fileName := range s.names {
+		previous[i], err = readPreviousFile(fileName)
+		if err != nil {
+			return s.rollback(i, previous, err)
+		}
+		if previous[i].data != nil && s.config.Backup {
+			err = writeFileAtomic(fileName+".bak", previous[i].data, previous[i].mode)
+			if err != nil {
+				return s.rollback(i, previous, fmt.Errorf("failed to back up %s: %s", fileName, err))
 			}
-			finalError = fmt.Errorf("%s%s; ", finalError, e)
 		}
+		err = os.Rename(tmpNames[i], fileName)
+		if err != nil {
+			return s.rollback(i, previous, err)
+		}
+		tmpNames[i] = ""
+		syncDir(filepath.Dir(fileName))
 	}
-	return finalError
+	return nil
 }
 
-func writeFile(output *Output, result *Result, filePath string) (err error) {
-	if output.Certificate != "" || output.PrivateKey != "" || output.CSR != "" || len(output.Chain) > 0 {
-		var bytes []byte
-		bytes, err = output.Format(result.Config)
+// previousFile is the content of a file before it was replaced, data is nil if the file did not exist
+type previousFile struct {
+	data []byte
+	mode os.FileMode
+}
+
+func readPreviousFile(fileName string) (previousFile, error) {
+	info, err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		return previousFile{}, nil
+	}
+	if err != nil {
+		return previousFile{}, err
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return previousFile{}, err
+	}
+	return previousFile{data: data, mode: info.Mode().Perm()}, nil
+}
+
+// rollback restores the first n files of the set to their previous content
+func (s *fileSet) rollback(n int, previous []previousFile, cause error) error {
+	for i := 0; i < n; i++ {
+		var err error
+		if previous[i].data == nil {
+			err = os.Remove(s.names[i])
+		} else {
+			err = writeFileAtomic(s.names[i], previous[i].data, previous[i].mode)
+		}
 		if err != nil {
-			return // something worse than file permission problem
+			return fmt.Errorf("%s; restoring %s failed as well: %s", cause, s.names[i], err)
 		}
-		err = ioutil.WriteFile(filePath, bytes, 0600)
+	}
+	if n > 0 {
+		return fmt.Errorf("%s; the files written before were restored", cause)
+	}
+	return cause
+}
 
-	} else {
-		if output.PickupId != "" {
-			err = ioutil.WriteFile(result.Config.PickupIdFile, []byte(result.PickupId+"\n"), 0600)
+// lookupFileOwner resolves user and group names or IDs, -1 means the owner or group is kept
+func lookupFileOwner(owner, group string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if owner != "" {
+		id := owner
+		if _, err = strconv.Atoi(owner); err != nil {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return 0, 0, err
+			}
+			id = u.Uid
+		}
+		uid, err = strconv.Atoi(id)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unexpected user ID %s of %s", id, owner)
+		}
+	}
+	if group != "" {
+		id := group
+		if _, err = strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return 0, 0, err
+			}
+			id = g.Gid
+		}
+		gid, err = strconv.Atoi(id)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unexpected group ID %s of %s", id, group)
 		}
 	}
-	return
+	return uid, gid, nil
+}
+
+// writeTempFile writes data with its final permissions to a new temporary file in the directory of fileName
+// and flushes it to disk, so that it can be renamed over fileName
+func writeTempFile(fileName string, data []byte, perm os.FileMode, uid, gid int) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(fileName), "."+filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err == nil && (uid != -1 || gid != -1) {
+		err = f.Chown(uid, gid)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// writeFileAtomic writes data to a temporary file next to fileName and renames it over fileName,
+// so that services reading the file never see it half-written
+func writeFileAtomic(fileName string, data []byte, perm os.FileMode) error {
+	tmpName, err := writeTempFile(fileName, data, perm, -1, -1)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpName, fileName)
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	syncDir(filepath.Dir(fileName))
+	return nil
+}
+
+// syncDir makes a rename in dir durable. It is best effort, directories cannot be synced on every platform.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	d.Close()
 }
 
 func outputJSON(resp interface{}) error {
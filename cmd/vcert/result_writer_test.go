@@ -17,6 +17,10 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/Venafi/vcert/v4/pkg/certificate"
@@ -122,18 +126,11 @@ func TestPKCS12withEncPK(t *testing.T) {
 		},
 		"==pickup-id==",
 		&Config{
-			"enroll",
-			"pkcs12",
-			"",
-			"",
-			certificate.ChainOptionFromString(""),
-			"/tmp/TestPKCS12withEncPK",
-			"",
-			"",
-			"",
-			"",
-			"",
-			"asdf",
+			Command:     "enroll",
+			Format:      "pkcs12",
+			ChainOption: certificate.ChainOptionFromString(""),
+			AllFile:     "/tmp/TestPKCS12withEncPK",
+			KeyPassword: "asdf",
 		},
 	}
 	err := result.Flush()
@@ -152,18 +149,10 @@ func TestPKCS12withPlainPK(t *testing.T) {
 		},
 		"==pickup-id==",
 		&Config{
-			"enroll",
-			"pkcs12",
-			"",
-			"",
-			certificate.ChainOptionFromString(""),
-			"/tmp/TestPKCS12withPlainPK",
-			"",
-			"",
-			"",
-			"",
-			"",
-			"",
+			Command:     "enroll",
+			Format:      "pkcs12",
+			ChainOption: certificate.ChainOptionFromString(""),
+			AllFile:     "/tmp/TestPKCS12withPlainPK",
 		},
 	}
 	err := result.Flush()
@@ -182,18 +171,10 @@ func TestPKCS12withPlainEcPK(t *testing.T) {
 		},
 		"==pickup-id==",
 		&Config{
-			"enroll",
-			"pkcs12",
-			"",
-			"",
-			certificate.ChainOptionFromString(""),
-			"/tmp/TestPKCS12withPlainEcPK",
-			"",
-			"",
-			"",
-			"",
-			"",
-			"",
+			Command:     "enroll",
+			Format:      "pkcs12",
+			ChainOption: certificate.ChainOptionFromString(""),
+			AllFile:     "/tmp/TestPKCS12withPlainEcPK",
 		},
 	}
 	err := result.Flush()
@@ -212,18 +193,12 @@ func TestJKSWithEncPKWithoutJKSPass(t *testing.T) {
 		},
 		"==pickup-id==",
 		&Config{
-			"enroll",
-			"jks",
-			"jksAlias",
-			"",
-			certificate.ChainOptionFromString(""),
-			"/tmp/TestJKSWithEncPKAndJKSPass",
-			"",
-			"",
-			"",
-			"",
-			"",
-			"password",
+			Command:     "enroll",
+			Format:      "jks",
+			JKSAlias:    "jksAlias",
+			ChainOption: certificate.ChainOptionFromString(""),
+			AllFile:     "/tmp/TestJKSWithEncPKAndJKSPass",
+			KeyPassword: "password",
 		},
 	}
 	err := result.Flush()
@@ -242,18 +217,13 @@ func TestJKSWithEncPKAndJKSPass(t *testing.T) {
 		},
 		"==pickup-id==",
 		&Config{
-			"enroll",
-			"jks",
-			"jksAlias",
-			"123456",
-			certificate.ChainOptionFromString(""),
-			"/tmp/TestJKSWithEncPKAndJKSPass",
-			"",
-			"",
-			"",
-			"",
-			"",
-			"password",
+			Command:     "enroll",
+			Format:      "jks",
+			JKSAlias:    "jksAlias",
+			JKSPassword: "123456",
+			ChainOption: certificate.ChainOptionFromString(""),
+			AllFile:     "/tmp/TestJKSWithEncPKAndJKSPass",
+			KeyPassword: "password",
 		},
 	}
 	err := result.Flush()
@@ -262,3 +232,92 @@ func TestJKSWithEncPKAndJKSPass(t *testing.T) {
 		t.Fatal("Failed to output the results: ", err)
 	}
 }
+
+func TestFileSetBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcertBackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "cert.pem")
+	c := &Config{Backup: true, FileMode: 0640}
+	files := &fileSet{config: c}
+	files.add(fileName, []byte("first"))
+	err = files.commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fileName + ".bak"); !os.IsNotExist(err) {
+		t.Fatal("backup should not be created for a new file")
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0640 {
+		t.Fatalf("expected mode 0640, got %o", info.Mode().Perm())
+	}
+
+	files = &fileSet{config: c}
+	files.add(fileName, []byte("second"))
+	err = files.commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(fileName + ".bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "first" {
+		t.Fatalf("backup should keep the previous content, got %q", b)
+	}
+}
+
+func TestFileSetRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcertRollback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "key.pem")
+	newFile := filepath.Join(dir, "chain.pem")
+	certFile := filepath.Join(dir, "cert.pem")
+	err = ioutil.WriteFile(keyFile, []byte("old key"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a non-empty directory cannot be replaced by a file, so the last rename fails
+	err = os.MkdirAll(filepath.Join(certFile, "sub"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := &fileSet{config: &Config{}}
+	files.add(keyFile, []byte("new key"))
+	files.add(newFile, []byte("new chain"))
+	files.add(certFile, []byte("new cert"))
+	err = files.commit()
+	if err == nil {
+		t.Fatal("commit should fail")
+	}
+
+	b, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "old key" {
+		t.Fatalf("replaced file should be restored, got %q", b)
+	}
+	if _, err := os.Stat(newFile); !os.IsNotExist(err) {
+		t.Fatal("file that did not exist before should be removed")
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("temporary files were left behind: %d entries", len(entries))
+	}
+}
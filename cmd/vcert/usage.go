@@ -82,4 +82,5 @@ func showvcertUsage() {
 	fmt.Printf("\tTo retrieve a certificate, use the 'pickup' action.\n")
 	fmt.Printf("\tTo renew a certificate, use the 'renew' action.\n")
 	fmt.Printf("\tTo revoke a certificate, use the 'revoke' action.\n")
+	fmt.Printf("\tTo keep certificates enrolled and renewed, use the 'agent' action.\n")
 }
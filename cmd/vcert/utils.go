@@ -17,10 +17,13 @@
 package main
 
 import (
+	"crypto"
 	"crypto/sha1"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/hex"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"github.com/spf13/viper"
 	"io"
@@ -34,6 +37,7 @@ import (
 	"github.com/Venafi/vcert/v4/pkg/certificate"
 	"github.com/Venafi/vcert/v4/pkg/endpoint"
 	"github.com/Venafi/vcert/v4/pkg/util"
+	"github.com/Venafi/vcert/v4/pkg/verror"
 )
 
 const (
@@ -44,6 +48,7 @@ const (
 	/* #nosec */
 	vCertApiKey      = "VCERT_APIKEY"
 	vCertTrustBundle = "VCERT_TRUST_BUNDLE"
+	vCertTrace       = "VCERT_TRACE"
 
 	JKSFormat = "jks"
 )
@@ -60,7 +65,7 @@ func parseCustomField(s string) (key, value string, err error) {
 }
 
 // fillCertificateRequest populates the certificate request payload with values from command flags
-func fillCertificateRequest(req *certificate.Request, cf *commandFlags) *certificate.Request {
+func fillCertificateRequest(req *certificate.Request, cf *commandFlags) (*certificate.Request, error) {
 	if cf.caDN != "" {
 		req.CADN = cf.caDN
 	}
@@ -104,7 +109,7 @@ func fillCertificateRequest(req *certificate.Request, cf *commandFlags) *certifi
 	for _, f := range cf.customFields {
 		k, v, err := parseCustomField(f)
 		if err != nil {
-			logger.Panic(err)
+			return nil, fmt.Errorf("%w: %s", verror.UserDataError, err)
 		}
 		req.CustomFields = append(req.CustomFields, certificate.CustomField{Name: k, Value: v})
 	}
@@ -133,11 +138,11 @@ func fillCertificateRequest(req *certificate.Request, cf *commandFlags) *certifi
 		csrFileName := cf.csrOption[5:]
 		csr, err := readCSRfromFile(csrFileName)
 		if err != nil {
-			logger.Panicf("Failed to read CSR from file %s: %s", csrFileName, err)
+			return nil, fmt.Errorf("%w: failed to read CSR from file %s: %s", verror.UserDataError, csrFileName, err)
 		}
 		err = req.SetCSR(csr)
 		if err != nil {
-			logger.Panicf("Failed to set CSR %s", err)
+			return nil, fmt.Errorf("%w: failed to set CSR: %s", verror.UserDataError, err)
 		}
 		req.CsrOrigin = certificate.UserProvidedCSR
 
@@ -199,7 +204,7 @@ func fillCertificateRequest(req *certificate.Request, cf *commandFlags) *certifi
 		req.IssuerHint = issuerHint
 	}
 
-	return req
+	return req, nil
 }
 
 func generateRenewalRequest(cf *commandFlags, certReq *certificate.Request) *certificate.RenewalRequest {
@@ -212,6 +217,76 @@ func generateRenewalRequest(cf *commandFlags, certReq *certificate.Request) *cer
 	return req
 }
 
+// readPrivateKeyFile reads a PEM private key, decrypting it with password when it is encrypted
+func readPrivateKeyFile(fileName string, password string) (crypto.Signer, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKeyPEM(b, password)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("no private key found in %s", fileName)
+	}
+	return key, nil
+}
+
+// parsePrivateKeyPEM returns the first private key in data, or nil if there is none. Besides the
+// legacy PEM encryption, it decrypts PKCS#8 keys with the algorithms of the PKCS#12 profiles.
+func parsePrivateKeyPEM(data []byte, password string) (crypto.Signer, error) {
+	var block *pem.Block
+	for {
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, nil
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			break
+		}
+	}
+	der := block.Bytes
+	var err error
+	//nolint:staticcheck
+	if x509.IsEncryptedPEMBlock(block) {
+		der, err = x509.DecryptPEMBlock(block, []byte(password))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt private key: %s", err)
+		}
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(der)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(der)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	case "ENCRYPTED PRIVATE KEY":
+		var encrypted pkcs12EncryptedPrivateKeyInfo
+		if _, err = asn1.Unmarshal(der, &encrypted); err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %s", err)
+		}
+		der, err = pkcs12Decrypt(encrypted.Algorithm, password, encrypted.EncryptedData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt private key: %s", err)
+		}
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	default:
+		return nil, fmt.Errorf("unexpected private key PEM type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %s", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
 func readThumbprintFromFile(fname string) (string, error) {
 	var err error
 	bytes, err := ioutil.ReadFile(fname)
@@ -228,26 +303,13 @@ func readThumbprintFromFile(fname string) (string, error) {
 		return s, nil
 	}
 
-	// check if there's a PEM certificate in the file
-	var block *pem.Block
-	var rest []byte
-	for {
-		block, rest = pem.Decode(bytes)
-		if block != nil && block.Type == "CERTIFICATE" {
-			break
-		}
-		if block == nil || len(rest) == 0 {
-			break
-		}
-		bytes = rest
+	// check if there's a PEM, DER or PKCS#7 certificate in the file
+	certs, err := parseCertificates(bytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to read certificate from file: %s: %s", fname, err)
 	}
-
-	if block != nil {
-		cert, err := x509.ParseCertificate(block.Bytes)
-		if err != nil {
-			return "", fmt.Errorf("failed to read certificate from file: %s: %s", fname, err)
-		}
-		fp := sha1.Sum(cert.Raw)
+	if len(certs) > 0 {
+		fp := sha1.Sum(certs[0].Raw)
 		return strings.ToUpper(hex.EncodeToString(fp[:])), nil
 	}
 
@@ -276,10 +338,10 @@ func retrieveCertificate(connector endpoint.Connector, req *certificate.Request,
 	for {
 		certificates, err = connector.RetrieveCertificate(req)
 		if err != nil {
-			_, ok := err.(endpoint.ErrCertificatePending)
-			if ok && timeout > 0 {
+			var pending endpoint.ErrCertificatePending
+			if errors.As(err, &pending) && timeout > 0 {
 				if time.Now().After(startTime.Add(timeout)) {
-					return nil, endpoint.ErrRetrieveCertificateTimeout{CertificateID: req.PickupID}
+					return nil, endpoint.ErrRetrieveCertificateTimeout{CertificateID: req.PickupID, Status: pending.Status, Elapsed: time.Since(startTime)}
 				}
 				if timeout > 0 {
 					logger.Printf("Issuance of certificate is pending...")
@@ -310,19 +372,15 @@ func retrieveCertificateNew(connector endpoint.Connector, req *certificate.Reque
 	return certificates, nil
 }
 
-func getFileWriter(fileName string) io.Writer {
-	var writer io.Writer
-	if fileName != "" {
-		f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-		writer = f
-		if err != nil {
-			logger.Panicf("%s", err)
-		}
-	} else {
-		writer = os.Stdout
+func getFileWriter(fileName string) (io.Writer, error) {
+	if fileName == "" {
+		return os.Stdout, nil
 	}
-
-	return writer
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", verror.UserDataError, err)
+	}
+	return f, nil
 }
 
 func doValuesMatch(value1 []byte, value2 []byte) bool {
@@ -19,7 +19,9 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/Venafi/vcert/v4/pkg/certificate"
@@ -38,6 +40,8 @@ var RevocationReasonOptions = []string{
 //taken from keystore.minPasswordLen constant
 const JKSMinPasswordLen = 6
 
+var validDaysRegex = regexp.MustCompile("[1-9]+[0-9]*(#[DdEeMm])?")
+
 func readData(commandName string) error {
 	if strings.HasPrefix(flags.distinguishedName, "file:") {
 		fileName := flags.distinguishedName[5:]
@@ -71,13 +75,36 @@ func readData(commandName string) error {
 }
 
 func validateCommonFlags(commandName string) error {
-	if flags.format != "" && flags.format != "pem" && flags.format != "json" && flags.format != "pkcs12" && flags.format != JKSFormat {
+	if flags.format != "" && flags.format != "pem" && flags.format != "json" && flags.format != "pkcs12" && flags.format != JKSFormat && flags.format != K8sSecretFormat &&
+		flags.format != DERFormat && flags.format != PKCS7Format {
 		return fmt.Errorf("Unexpected output format: %s", flags.format)
 	}
+	if (flags.format == DERFormat || flags.format == PKCS7Format) && (flags.file != "" || flags.certFile == "") {
+		return fmt.Errorf(`The --format %q writes a binary certificate file; specify it using --cert-file instead of --file or STDOUT`, flags.format)
+	}
+	err := validateK8sSecretFlags()
+	if err != nil {
+		return err
+	}
+	err = validateLayoutFlags()
+	if err != nil {
+		return err
+	}
 	if flags.file != "" && (flags.certFile != "" || flags.chainFile != "" || flags.keyFile != "") {
 		return fmt.Errorf("The '-file' option cannot be used used with any other -*-file flags. Either all data goes into one file or individual files must be specified using the appropriate flags")
 	}
 
+	if flags.postHook != "" && flags.noPickup {
+		return fmt.Errorf("The --post-hook option cannot be used with --no-pickup since no certificate is written")
+	}
+	if flags.postHookTimeout < 0 {
+		return fmt.Errorf("--post-hook-timeout cannot be negative")
+	}
+	err = validateFileFlags()
+	if err != nil {
+		return err
+	}
+
 	csrOptionRegex := regexp.MustCompile(`(^file:).*$|^local$|^service$|^$`)
 	if !csrOptionRegex.MatchString(flags.csrOption) {
 		return fmt.Errorf("unexpected --csr option provided: %s; specify one of the following options: %s, %s, or %s", flags.csrOption, "'file:<filename>'", "'local'", "'service'")
@@ -89,31 +116,48 @@ func validateCommonFlags(commandName string) error {
 		return fmt.Errorf("the '--keytype','--keycurve' and '--key-size' options cannot be used when '--csr file:' option is provided")
 	}
 
-	switch flags.keyTypeString {
+	if flags.keyTypeString != "" {
+		kt, err := parseKeyType(flags.keyTypeString)
+		if err != nil {
+			return err
+		}
+		flags.keyType = kt
+	}
+
+	if flags.keyCurveString != "" {
+		curve, err := parseKeyCurve(flags.keyCurveString)
+		if err != nil {
+			return err
+		}
+		flags.keyCurve = curve
+	}
+	return nil
+}
+
+func parseKeyType(s string) (*certificate.KeyType, error) {
+	var kt certificate.KeyType
+	switch s {
 	case "rsa":
-		kt := certificate.KeyTypeRSA
-		flags.keyType = &kt
+		kt = certificate.KeyTypeRSA
 	case "ecdsa":
-		kt := certificate.KeyTypeECDSA
-		flags.keyType = &kt
-	case "":
+		kt = certificate.KeyTypeECDSA
 	default:
-		return fmt.Errorf("unknown key type: %s", flags.keyTypeString)
+		return nil, fmt.Errorf("unknown key type: %s", s)
 	}
+	return &kt, nil
+}
 
-	switch strings.ToLower(flags.keyCurveString) {
+func parseKeyCurve(s string) (certificate.EllipticCurve, error) {
+	switch strings.ToLower(s) {
 	case "p256":
-		flags.keyCurve = certificate.EllipticCurveP256
+		return certificate.EllipticCurveP256, nil
 	case "p384":
-		flags.keyCurve = certificate.EllipticCurveP384
+		return certificate.EllipticCurveP384, nil
 	case "p521":
-		flags.keyCurve = certificate.EllipticCurveP521
-	case "":
+		return certificate.EllipticCurveP521, nil
 	default:
-		return fmt.Errorf("unknown EC key curve: %s", flags.keyTypeString)
-
+		return certificate.EllipticCurveNotSet, fmt.Errorf("unknown EC key curve: %s", s)
 	}
-	return nil
 }
 
 func validateConnectionFlags(commandName string) error {
@@ -140,8 +184,8 @@ func validateConnectionFlags(commandName string) error {
 			return nil
 		}
 		if flags.tppUser == "" && tppToken == "" {
-			// should be SaaS endpoint
-			if flags.apiKey == "" && getPropertyFromEnvironment(vCertApiKey) == "" {
+			// should be SaaS endpoint, or TPP with credentials saved by getcred --save
+			if flags.apiKey == "" && getPropertyFromEnvironment(vCertApiKey) == "" && lookupSavedCredential(&flags) == nil {
 				return fmt.Errorf("An API key is required for communicating with Venafi Cloud")
 			}
 		} else {
@@ -163,7 +207,7 @@ func validateConnectionFlags(commandName string) error {
 }
 
 func validatePKCS12Flags(commandName string) error {
-	if flags.format == "pkcs12" {
+	if flags.format == "pkcs12" && flags.layout != LayoutTruststore {
 		if commandName == commandEnrollName {
 			if flags.file == "" && flags.csrOption != "service" {
 				return fmt.Errorf("PKCS#12 format requires certificate, private key, and chain to be written to a single file; specify using --file")
@@ -176,17 +220,30 @@ func validatePKCS12Flags(commandName string) error {
 		if flags.certFile != "" || flags.chainFile != "" || flags.keyFile != "" {
 			return fmt.Errorf(`The --file parameter may not be combined with the --cert-file, --key-file, or --chain-file parameters when --format is "pkcs12"`)
 		}
-		if strings.HasPrefix(flags.csrOption, "file:") {
+		if strings.HasPrefix(flags.csrOption, "file:") && !flags.pkcs12TrustStore {
 			return fmt.Errorf(`The --csr "file" option may not be used with the enroll or renew actions when --format is "pkcs12"`)
 		}
 		if (flags.csrOption == "" || flags.csrOption == "local") && flags.noPickup {
 			return fmt.Errorf(`The --csr "local" option may not be used with the enroll or renew actions when --format is "pkcs12" and --no-pickup is specified`)
 		}
+	} else if flags.pkcs12TrustStore {
+		return fmt.Errorf("The --pkcs12-truststore option may only be used with --format pkcs12")
+	}
+	if flags.pkcs12Profile != "" {
+		if flags.format != "pkcs12" && flags.layout != LayoutTruststore {
+			return fmt.Errorf("The --pkcs12-profile option may only be used with --format pkcs12 or --layout truststore")
+		}
+		if _, err := getPKCS12Profile(flags.pkcs12Profile); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 func validateJKSFlags(commandName string) error {
+	if flags.layout == LayoutTruststore && flags.format == JKSFormat {
+		return nil // validated by validateLayoutFlags
+	}
 	if flags.format == JKSFormat {
 
 		if commandName == commandEnrollName {
@@ -247,7 +304,12 @@ func validateEnrollFlags(commandName string) error {
 	if err != nil {
 		return err
 	}
-	if strings.Index(flags.csrOption, "file:") == 0 {
+	if flags.manifest != "" {
+		err = validateManifestFlags()
+		if err != nil {
+			return err
+		}
+	} else if strings.Index(flags.csrOption, "file:") == 0 {
 		if flags.commonName != "" {
 			return fmt.Errorf("The '-cn' option cannot be used in -csr file: provided mode")
 		}
@@ -278,7 +340,7 @@ func validateEnrollFlags(commandName string) error {
 			zone = getPropertyFromEnvironment(vCertZone)
 		}
 
-		if flags.tppUser == "" && tppToken == "" {
+		if flags.tppUser == "" && tppToken == "" && lookupSavedCredential(&flags) == nil {
 			// should be SaaS endpoint
 			if apiKey == "" {
 				return fmt.Errorf("An API key is required for enrollment with Venafi Cloud")
@@ -287,11 +349,8 @@ func validateEnrollFlags(commandName string) error {
 				return fmt.Errorf("A zone is required for requesting a certificate from Venafi Cloud")
 			}
 		} else {
-			// should be TPP service
-			if flags.tppUser == "" && tppToken == "" {
-				return fmt.Errorf("An access token or username is required for communicating with Trust Protection Platform")
-			}
-			if flags.noPrompt && flags.tppPassword == "" && tppToken == "" {
+			// should be TPP service, possibly with the credentials saved by getcred --save
+			if flags.noPrompt && flags.tppUser != "" && flags.tppPassword == "" && tppToken == "" {
 				return fmt.Errorf("An access token or password is required for communicating with Trust Protection Platform")
 			}
 
@@ -325,14 +384,17 @@ func validateEnrollFlags(commandName string) error {
 			return fmt.Errorf("-key-password cannot be empty in -csr service mode unless -no-pickup specified")
 		}
 	}
-	err = validatePKCS12Flags(commandName)
-	if err != nil {
-		return err
-	}
+	// with --manifest the output options are validated for every entry
+	if flags.manifest == "" {
+		err = validatePKCS12Flags(commandName)
+		if err != nil {
+			return err
+		}
 
-	err = validateJKSFlags(commandName)
-	if err != nil {
-		return err
+		err = validateJKSFlags(commandName)
+		if err != nil {
+			return err
+		}
 	}
 
 	if flags.tppUser != "" || flags.tppPassword != "" {
@@ -350,6 +412,162 @@ func validateEnrollFlags(commandName string) error {
 	return nil
 }
 
+func validateK8sSecretFlags() error {
+	if flags.format != K8sSecretFormat {
+		if flags.secret.Name != "" || flags.secret.Namespace != "" || len(flags.secretLabels) > 0 || len(flags.secretAnnotations) > 0 {
+			return fmt.Errorf("The --secret-* options may only be used with --format k8s-secret")
+		}
+		return nil
+	}
+	if flags.certFile != "" || flags.chainFile != "" || flags.keyFile != "" {
+		return fmt.Errorf(`The --cert-file, --key-file, and --chain-file parameters may not be used when --format is "k8s-secret"; use --file or STDOUT`)
+	}
+	if strings.HasPrefix(flags.csrOption, "file:") {
+		return fmt.Errorf(`The --csr "file" option may not be used when --format is "k8s-secret" since the Secret requires the private key`)
+	}
+	if flags.noPickup {
+		return fmt.Errorf(`The --no-pickup option may not be used when --format is "k8s-secret"`)
+	}
+	if flags.secret.Output != "" && flags.secret.Output != "yaml" && flags.secret.Output != "json" {
+		return fmt.Errorf("Unexpected --secret-output: %s; specify yaml or json", flags.secret.Output)
+	}
+	var err error
+	flags.secret.Labels, err = parseKeyValues("secret-label", flags.secretLabels)
+	if err != nil {
+		return err
+	}
+	flags.secret.Annotations, err = parseKeyValues("secret-annotation", flags.secretAnnotations)
+	return err
+}
+
+func validateLayoutFlags() error {
+	if flags.layout == "" {
+		if flags.outputDir != "" {
+			return fmt.Errorf("The --output-dir option may only be used with --layout")
+		}
+		return nil
+	}
+	known := false
+	for _, layout := range layouts {
+		known = known || flags.layout == layout
+	}
+	if !known {
+		return fmt.Errorf("Unexpected --layout: %s; specify one of %s", flags.layout, strings.Join(layouts, ", "))
+	}
+	if flags.noPickup {
+		return fmt.Errorf("The --layout option cannot be used with --no-pickup since no certificate is written")
+	}
+
+	if flags.layout == LayoutTruststore {
+		if flags.file != "" || flags.chainFile != "" {
+			return fmt.Errorf("The --file and --chain-file options cannot be used with --layout truststore")
+		}
+		if flags.chainOption == "ignore" {
+			return fmt.Errorf("--layout truststore holds the chain, so it cannot be used with --chain ignore")
+		}
+		switch flags.format {
+		case "", "pem", "pkcs12":
+		case JKSFormat:
+			password := flags.jksPassword
+			if password == "" {
+				password = flags.keyPassword
+			}
+			if len(password) < JKSMinPasswordLen {
+				return fmt.Errorf("JKS format requires passwords that are at least %d characters long", JKSMinPasswordLen)
+			}
+		default:
+			return fmt.Errorf("--layout truststore writes a PKCS#12 or JKS file, so --format must be pkcs12 or jks")
+		}
+		return nil
+	}
+
+	if flags.file != "" || flags.certFile != "" || flags.chainFile != "" || flags.keyFile != "" {
+		return fmt.Errorf("The --layout option cannot be used with --file, --cert-file, --chain-file or --key-file; the files of the layout are written to --output-dir")
+	}
+	if flags.format != "" && flags.format != "pem" {
+		return fmt.Errorf("--layout %s writes PEM files, so it cannot be used with --format %s", flags.layout, flags.format)
+	}
+	if strings.HasPrefix(flags.csrOption, "file:") {
+		return fmt.Errorf("--layout %s requires the private key, so it cannot be used with --csr file:", flags.layout)
+	}
+	return nil
+}
+
+func validateFileFlags() error {
+	if flags.fileModeString != "" {
+		mode, err := strconv.ParseUint(flags.fileModeString, 8, 32)
+		if err != nil || mode > 0777 {
+			return fmt.Errorf("--file-mode %s is not valid; specify octal permissions such as 0640", flags.fileModeString)
+		}
+		flags.fileMode = os.FileMode(mode)
+	}
+	_, _, err := lookupFileOwner(flags.fileOwner, flags.fileGroup)
+	if err != nil {
+		return fmt.Errorf("unable to set the owner of the output files: %s", err)
+	}
+	return nil
+}
+
+func validateManifestFlags() error {
+	if flags.commonName != "" || flags.friendlyName != "" ||
+		len(flags.dnsSans) > 0 ||
+		len(flags.ipSans) > 0 ||
+		len(flags.emailSans) > 0 ||
+		len(flags.uriSans) > 0 ||
+		len(flags.upnSans) > 0 {
+		return fmt.Errorf("--manifest cannot be combined with --cn, --nickname or --san-* options; specify them for each certificate in the manifest")
+	}
+	if flags.file != "" || flags.certFile != "" || flags.keyFile != "" || flags.chainFile != "" || flags.pickupIDFile != "" || flags.jksAlias != "" {
+		return fmt.Errorf("--manifest cannot be combined with --file, --cert-file, --key-file, --chain-file, --pickup-id-file or --jks-alias options; specify them for each certificate in the manifest")
+	}
+	if strings.HasPrefix(flags.csrOption, "file:") {
+		return fmt.Errorf("--csr file: cannot be used with --manifest")
+	}
+	if flags.layout != "" {
+		return fmt.Errorf("--layout cannot be used with --manifest, since the certificates would overwrite each other's files")
+	}
+	if flags.secret.Name != "" {
+		return fmt.Errorf("--secret-name cannot be used with --manifest; Secrets are named after the common names of the certificates")
+	}
+	if flags.concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1")
+	}
+	return nil
+}
+
+func validateAgentFlags(commandName string) error {
+	err := validateConnectionFlags(commandName)
+	if err != nil {
+		return err
+	}
+	err = readData(commandName)
+	if err != nil {
+		return err
+	}
+	if flags.manifest == "" {
+		return fmt.Errorf("--manifest is required to specify the certificates managed by the agent")
+	}
+	if flags.interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+	if flags.postHookTimeout < 0 {
+		return fmt.Errorf("--post-hook-timeout cannot be negative")
+	}
+	err = validateFileFlags()
+	if err != nil {
+		return err
+	}
+	_, err = certificate.ParseRenewalWindow(flags.renewBefore)
+	if err != nil {
+		return err
+	}
+	flags.keyPassword, err = readPasswordsFromInputFlag(flags.keyPassword, 0)
+	if err != nil {
+		return fmt.Errorf("Failed to read key password: %s", err)
+	}
+	return nil
+}
+
 func validateValidDaysFlag(cn string) bool {
 	if cn != "enroll" {
 		return false
@@ -359,9 +577,7 @@ func validateValidDaysFlag(cn string) bool {
 
 		validDays := flags.validDays
 
-		var regex = regexp.MustCompile("[1-9]+[0-9]*(#[DdEeMm])?")
-
-		return regex.MatchString(validDays)
+		return validDaysRegex.MatchString(validDays)
 
 	}
 
@@ -392,12 +608,13 @@ func validateCredMgmtFlags1(commandName string) error {
 		if flags.testMode {
 			return fmt.Errorf("There is no test mode for %s command", commandName)
 		}
+		saved := lookupSavedCredential(&flags)
 		if commandName == commandGetCredName {
-			if flags.tppUser == "" && tppTokenS == "" && flags.clientP12 == "" {
+			if flags.tppUser == "" && tppTokenS == "" && flags.clientP12 == "" && (saved == nil || saved.RefreshToken == "") {
 				return fmt.Errorf("either --username, --p12-file, or -t must be specified")
 			}
 		} else {
-			if tppTokenS == "" {
+			if tppTokenS == "" && saved == nil {
 				return fmt.Errorf("missing -t (access token) parameter")
 			}
 		}
@@ -406,7 +623,7 @@ func validateCredMgmtFlags1(commandName string) error {
 			return fmt.Errorf("missing -u (URL) parameter")
 		}
 
-		if flags.noPrompt && flags.tppPassword == "" && tppTokenS == "" {
+		if flags.noPrompt && flags.tppPassword == "" && tppTokenS == "" && saved == nil {
 			return fmt.Errorf("An access token or password is required for communicating with Trust Protection Platform")
 		}
 
@@ -460,15 +677,29 @@ func validateRenewFlags1(commandName string) error {
 		return err
 	}
 
-	if flags.distinguishedName == "" && flags.thumbprint == "" {
-		return fmt.Errorf("-id or -thumbprint required to identify the certificate to renew")
+	if flags.distinguishedName == "" && flags.thumbprint == "" && flags.certFile == "" {
+		return fmt.Errorf("-id, -thumbprint or -cert-file required to identify the certificate to renew")
 	}
 	if flags.distinguishedName != "" && flags.thumbprint != "" {
 		return fmt.Errorf("-id and -thumbprint cannot be used at the same time")
 	}
+	if flags.renewBefore != "" {
+		_, err = certificate.ParseRenewalWindow(flags.renewBefore)
+		if err != nil {
+			return err
+		}
+	}
 	if flags.chainOption == "ignore" && flags.chainFile != "" {
 		return fmt.Errorf("The `-chain ignore` option cannot be used with -chain-file option")
 	}
+	if flags.reuseKey {
+		if flags.keyFile == "" {
+			return fmt.Errorf("-reuse-key requires -key-file with the private key of the certificate to renew")
+		}
+		if flags.csrOption != "" && flags.csrOption != "local" {
+			return fmt.Errorf("-reuse-key can only be used with -csr local")
+		}
+	}
 
 	if flags.csrOption == "service" {
 		if !(flags.noPickup) && flags.noPrompt && len(flags.keyPassword) == 0 && (flags.tppUser != "" || flags.tppToken != "") {
@@ -563,6 +794,60 @@ func validatePickupFlags1(commandName string) error {
 	return nil
 }
 
+func validateConvertFlags(commandName string) error {
+	if flags.inFile == "" {
+		return fmt.Errorf("The --in option is required to specify the file to convert")
+	}
+	err := validateCommonFlags(commandName)
+	if err != nil {
+		return err
+	}
+	err = readData(commandName)
+	if err != nil {
+		return err
+	}
+	err = readInputPasswords()
+	if err != nil {
+		return err
+	}
+
+	err = validatePKCS12Flags(commandName)
+	if err != nil {
+		return err
+	}
+	return validateJKSFlags(commandName)
+}
+
+func validateInspectFlags(commandName string) error {
+	if flags.inFile == "" {
+		return fmt.Errorf("The --in option is required to specify the file to inspect")
+	}
+	if flags.inspectFormat != "text" && flags.inspectFormat != "json" {
+		return fmt.Errorf("Unexpected --format: %s; specify text or json", flags.inspectFormat)
+	}
+	if inspectUsesConnection() {
+		if err := validateConnectionFlags(commandName); err != nil {
+			return err
+		}
+	}
+	return readInputPasswords()
+}
+
+// readInputPasswords reads the passwords of the files read by convert and inspect
+func readInputPasswords() error {
+	var err error
+	flags.inPassword, err = readPasswordsFromInputFlag(flags.inPassword, 0)
+	if err != nil {
+		return fmt.Errorf("Failed to read --in-password: %s", err)
+	}
+	if flags.inKeyPassword == "" {
+		flags.inKeyPassword = flags.inPassword
+	} else if flags.inKeyPassword, err = readPasswordsFromInputFlag(flags.inKeyPassword, 0); err != nil {
+		return fmt.Errorf("Failed to read --in-key-password: %s", err)
+	}
+	return nil
+}
+
 func validateRevokeFlags1(commandName string) error {
 
 	err := validateConnectionFlags(commandName)
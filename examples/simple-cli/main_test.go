@@ -17,14 +17,12 @@
 package main
 
 import (
-	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"github.com/Venafi/vcert/v4"
 	"github.com/Venafi/vcert/v4/pkg/certificate"
 	"github.com/Venafi/vcert/v4/test"
-	"net/http"
 	"testing"
 	"time"
 )
@@ -33,7 +31,7 @@ var effectiveConfig *vcert.Config
 
 func init() {
 	effectiveConfig = tppConfig
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	effectiveConfig.InsecureSkipVerify = true
 }
 
 func TestRequestCertificate(t *testing.T) {
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"github.com/Venafi/vcert/v4/pkg/certificate"
 	"github.com/Venafi/vcert/v4/pkg/endpoint"
-	"log"
 	"net"
 	"time"
 )
@@ -28,6 +27,7 @@ import (
 // handshake has completed.
 func (cfg *Config) NewListener(domains ...string) net.Listener {
 	l := listener{}
+	logger := cfg.logger()
 	conn, err := cfg.NewClient()
 	if err != nil {
 		l.e = err
@@ -46,7 +46,7 @@ func (cfg *Config) NewListener(domains ...string) net.Listener {
 			port = parsedPort
 			d = parsedHost
 		}
-		log.Println("Retrieving certificate for domain", d)
+		logger.Info("Retrieving certificate", "domain", d)
 		cert, err := getSimpleCertificate(conn, d)
 		if err != nil {
 			l.e = err
@@ -65,7 +65,7 @@ func (cfg *Config) NewListener(domains ...string) net.Listener {
 		NameToCertificate: certsMap,
 	}
 	l.Listener, l.e = net.Listen("tcp", ":"+port)
-	log.Println("Starting server on port", port)
+	logger.Info("Starting server", "port", port)
 	return &l
 }
 
@@ -294,7 +294,10 @@ func (request *Request) GenerateCSR() error {
 		certificateRequest.URIs = request.URIs
 
 		if len(request.UPNs) > 0 {
-			addUserPrincipalNameSANs(&certificateRequest, request.UPNs)
+			err := addUserPrincipalNameSANs(&certificateRequest, request.UPNs)
+			if err != nil {
+				return fmt.Errorf("%w: %s", verror.UserDataError, err)
+			}
 		}
 	}
 	certificateRequest.Attributes = request.Attributes
@@ -509,7 +512,7 @@ func NewRequest(cert *x509.Certificate) *Request {
 	case *ecdsa.PublicKey:
 		req.KeyType = KeyTypeECDSA
 		req.KeyLength = pub.Curve.Params().BitSize
-		// TODO: req.KeyCurve = pub.Curve.Params().Name ...
+		_ = req.KeyCurve.Set(pub.Curve.Params().Name)
 	default: // case *dsa.PublicKey
 		// vcert only works with RSA & ECDSA
 	}
@@ -569,3 +569,33 @@ func pemRSADecode(priv string) *rsa.PrivateKey {
 	}
 	return parsedKey.(*rsa.PrivateKey)
 }
+
+func TestNewRequestECDSA(t *testing.T) {
+	pk, err := GenerateECDSAPrivateKey(EllipticCurveP384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"ecdsa.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	template.Subject.CommonName = "ecdsa.example.com"
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pk.Public(), pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := NewRequest(cert)
+	if req.KeyType != KeyTypeECDSA || req.KeyCurve != EllipticCurveP384 {
+		t.Fatalf("expected ECDSA P384 key settings, got %s %s", req.KeyType.String(), req.KeyCurve.String())
+	}
+	if req.Subject.CommonName != "ecdsa.example.com" || len(req.DNSNames) != 1 {
+		t.Fatalf("unexpected subject of the request: %+v", req)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2018 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RenewalWindow is the period before expiration in which a certificate should be renewed.
+// It is either a fixed duration or a percentage of the certificate lifetime.
+type RenewalWindow struct {
+	Before  time.Duration
+	Percent float64
+}
+
+// ParseRenewalWindow parses a duration, such as 720h, a number of days, such as 30d,
+// or a percentage of the certificate lifetime, such as 20%
+func ParseRenewalWindow(s string) (RenewalWindow, error) {
+	if strings.HasSuffix(s, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return RenewalWindow{}, fmt.Errorf("invalid renewal window %q: percentage must be between 0 and 100", s)
+		}
+		return RenewalWindow{Percent: percent}, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return RenewalWindow{}, fmt.Errorf("invalid renewal window %q: expected a number of days", s)
+		}
+		return RenewalWindow{Before: time.Duration(days) * 24 * time.Hour}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return RenewalWindow{}, fmt.Errorf("invalid renewal window %q: expected a duration, days or a percentage", s)
+	}
+	return RenewalWindow{Before: d}, nil
+}
+
+// Start returns the time from which cert should be renewed
+func (w RenewalWindow) Start(cert *x509.Certificate) time.Time {
+	if w.Percent > 0 {
+		lifetime := cert.NotAfter.Sub(cert.NotBefore)
+		return cert.NotAfter.Add(-time.Duration(float64(lifetime) * w.Percent / 100))
+	}
+	return cert.NotAfter.Add(-w.Before)
+}
+
+// NeedsRenewal reports whether cert is inside the renewal window at the time now
+func (w RenewalWindow) NeedsRenewal(cert *x509.Certificate, now time.Time) bool {
+	return !now.Before(w.Start(cert))
+}
+
+func (w RenewalWindow) String() string {
+	if w.Percent > 0 {
+		return strconv.FormatFloat(w.Percent, 'f', -1, 64) + "%"
+	}
+	return w.Before.String()
+}
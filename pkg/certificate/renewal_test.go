@@ -0,0 +1,72 @@
+/*
+ * Copyright 2018 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestParseRenewalWindow(t *testing.T) {
+	cases := map[string]RenewalWindow{
+		"30d":   {Before: 30 * 24 * time.Hour},
+		"0d":    {},
+		"720h":  {Before: 720 * time.Hour},
+		"90m":   {Before: 90 * time.Minute},
+		"20%":   {Percent: 20},
+		"33.5%": {Percent: 33.5},
+	}
+	for s, expected := range cases {
+		w, err := ParseRenewalWindow(s)
+		if err != nil {
+			t.Fatalf("%s: %s", s, err)
+		}
+		if w != expected {
+			t.Fatalf("%s: expected %+v, got %+v", s, expected, w)
+		}
+	}
+	for _, s := range []string{"", "d", "-1d", "thirty days", "-5h", "0%", "101%", "x%"} {
+		if _, err := ParseRenewalWindow(s); err == nil {
+			t.Fatalf("%q should be rejected", s)
+		}
+	}
+}
+
+func TestRenewalWindowNeedsRenewal(t *testing.T) {
+	notBefore := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := &x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.Add(100 * 24 * time.Hour)}
+
+	days := RenewalWindow{Before: 30 * 24 * time.Hour}
+	if days.NeedsRenewal(cert, notBefore.Add(69*24*time.Hour)) {
+		t.Fatal("certificate with 31 days left is outside of a 30 day window")
+	}
+	if !days.NeedsRenewal(cert, notBefore.Add(70*24*time.Hour)) {
+		t.Fatal("certificate with 30 days left is inside of a 30 day window")
+	}
+
+	percent := RenewalWindow{Percent: 20}
+	if !percent.Start(cert).Equal(notBefore.Add(80 * 24 * time.Hour)) {
+		t.Fatalf("unexpected start of the window: %s", percent.Start(cert))
+	}
+	if percent.NeedsRenewal(cert, notBefore.Add(79*24*time.Hour)) {
+		t.Fatal("certificate with 21% of its lifetime left is outside of a 20% window")
+	}
+	if !percent.NeedsRenewal(cert, notBefore.Add(101*24*time.Hour)) {
+		t.Fatal("expired certificate should be renewed")
+	}
+}
@@ -21,7 +21,6 @@ import (
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"fmt"
-	"log"
 	"net"
 	"net/url"
 )
@@ -51,10 +50,10 @@ const (
 )
 
 // Workaround for lack of User Principal Name SAN support in crypto/x509 package
-func addUserPrincipalNameSANs(req *x509.CertificateRequest, upNames []string) {
+func addUserPrincipalNameSANs(req *x509.CertificateRequest, upNames []string) error {
 	sanBytes, err := marshalSANs(req.DNSNames, req.EmailAddresses, req.IPAddresses, req.URIs, upNames)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	extSubjectAltName := pkix.Extension{
@@ -78,6 +77,7 @@ func addUserPrincipalNameSANs(req *x509.CertificateRequest, upNames []string) {
 	req.EmailAddresses = nil
 	req.IPAddresses = nil
 	req.URIs = nil
+	return nil
 }
 
 // Enhance crypto/x509 marshalSANs method to additionally support User Principal Name SANs
@@ -121,7 +121,12 @@ func marshalSANs(dnsNames, emailAddresses []string, ipAddresses []net.IP, uris [
 
 // Since crypto/x509 package is not aware of UPN SANs, implement our own parsing method
 func getUserPrincipalNameSANs(cert *x509.Certificate) (ret []string, err error) {
-	for _, ext := range cert.Extensions {
+	return UserPrincipalNameSANs(cert.Extensions)
+}
+
+// UserPrincipalNameSANs returns the UPN SANs found in the extensions of a certificate or CSR
+func UserPrincipalNameSANs(extensions []pkix.Extension) (ret []string, err error) {
+	for _, ext := range extensions {
 		if !ext.Id.Equal(oidExtensionSubjectAltName) {
 			continue
 		}
@@ -0,0 +1,277 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package credentials
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/Venafi/vcert/v4/pkg/venafi/tpp"
+	"github.com/Venafi/vcert/v4/pkg/verror"
+)
+
+// DefaultClientID is the client ID the vcert CLI requests tokens for
+const DefaultClientID = "vcert-cli"
+
+const (
+	cacheDirName  = "vcert"
+	cacheFileName = "credentials.json"
+)
+
+// SavedCredential are the TPP tokens saved for a URL and client ID by vcert getcred --save
+type SavedCredential struct {
+	URL                string    `json:"url"`
+	ClientID           string    `json:"clientId"`
+	AccessToken        string    `json:"accessToken"`
+	AccessTokenExpires time.Time `json:"accessTokenExpires"`
+	RefreshToken       string    `json:"refreshToken,omitempty"`
+}
+
+// Expired reports whether the access token expires within a minute. Tokens without a known expiration never expire.
+func (c *SavedCredential) Expired() bool {
+	if c.AccessTokenExpires.IsZero() {
+		return false
+	}
+	return time.Now().Add(time.Minute).After(c.AccessTokenExpires)
+}
+
+// Cache is the file that keeps the tokens saved by vcert getcred --save, vcert/credentials.json in the user
+// configuration directory. It holds secrets, so it is only readable by the user.
+type Cache struct {
+	path        string
+	lock        *os.File
+	Credentials []SavedCredential `json:"credentials"`
+}
+
+// CachePath returns the path of the credential cache of the user
+func CachePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the user configuration directory: %w", err)
+	}
+	return filepath.Join(dir, cacheDirName, cacheFileName), nil
+}
+
+// LoadCache reads the credential cache of the user. A missing file is an empty cache. Use LockCache to update it.
+func LoadCache() (*Cache, error) {
+	path, err := CachePath()
+	if err != nil {
+		return nil, err
+	}
+	return loadCache(path)
+}
+
+// LockCache reads the credential cache of the user like LoadCache, holding an exclusive lock on it until Unlock is
+// called. Other processes locking the cache wait for it, so that the credentials one of them reads, refreshes and
+// saves are not overwritten by another one, which would lose a rotated refresh token.
+func LockCache() (*Cache, error) {
+	path, err := CachePath()
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock saved credentials: %w", err)
+	}
+	// the cache file is replaced when it is saved, so a separate file is locked
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock saved credentials: %w", err)
+	}
+	err = lockFile(lock)
+	if err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to lock saved credentials: %w", err)
+	}
+	// read only now, another process may have saved the cache while this one waited for the lock
+	cache, err := loadCache(path)
+	if err != nil {
+		unlockFile(lock)
+		lock.Close()
+		return nil, err
+	}
+	cache.lock = lock
+	return cache, nil
+}
+
+func loadCache(path string) (*Cache, error) {
+	cache := &Cache{path: path}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read saved credentials: %w", err)
+	}
+	err = json.Unmarshal(data, cache)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse saved credentials in %s: %w", path, err)
+	}
+	return cache, nil
+}
+
+// Path returns the file the cache is saved to
+func (c *Cache) Path() string {
+	return c.path
+}
+
+// Unlock releases the lock taken by LockCache. It does nothing for a cache read with LoadCache.
+func (c *Cache) Unlock() error {
+	if c.lock == nil {
+		return nil
+	}
+	err := unlockFile(c.lock)
+	if closeErr := c.lock.Close(); err == nil {
+		err = closeErr
+	}
+	c.lock = nil
+	return err
+}
+
+// Save writes the cache to a temporary file and renames it over the cache file, so that concurrent readers
+// never see it half-written. Caches that are read to be updated should be read with LockCache.
+func (c *Cache) Save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(c.path)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return fmt.Errorf("failed to save credentials: %w", err)
+	}
+	f, err := ioutil.TempFile(dir, "."+cacheFileName+".")
+	if err != nil {
+		return fmt.Errorf("failed to save credentials: %w", err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to save credentials: %w", err)
+	}
+	return nil
+}
+
+// Find returns the credential saved for url and clientID, or nil
+func (c *Cache) Find(url, clientID string) *SavedCredential {
+	url = NormalizeURL(url)
+	for i := range c.Credentials {
+		if c.Credentials[i].URL == url && c.Credentials[i].ClientID == clientID {
+			return &c.Credentials[i]
+		}
+	}
+	return nil
+}
+
+// Put adds cred to the cache, replacing the credential saved for the same URL and client ID
+func (c *Cache) Put(cred SavedCredential) {
+	cred.URL = NormalizeURL(cred.URL)
+	if saved := c.Find(cred.URL, cred.ClientID); saved != nil {
+		*saved = cred
+		return
+	}
+	c.Credentials = append(c.Credentials, cred)
+}
+
+// Remove deletes the credential saved for url and clientID and reports whether there was one
+func (c *Cache) Remove(url, clientID string) bool {
+	url = NormalizeURL(url)
+	for i, saved := range c.Credentials {
+		if saved.URL == url && saved.ClientID == clientID {
+			c.Credentials = append(c.Credentials[:i], c.Credentials[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeURL reduces the ways a TPP URL can be written to the one the cache is keyed by. The scheme is kept, so
+// that tokens saved for an https URL are never sent to the http one; a URL without scheme is an https URL.
+func NormalizeURL(url string) string {
+	url = strings.ToLower(strings.TrimSpace(url))
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		url = "https://" + url
+	}
+	url = strings.TrimSuffix(url, "/")
+	url = strings.TrimSuffix(url, "/vedsdk")
+	return url + "/"
+}
+
+// Saved returns the tokens saved by vcert getcred --save for URL and ClientID, or DefaultClientID if it is empty.
+// With a saved refresh token, the connector refreshes the access token when it authenticates and whenever it
+// expires. TPP may rotate the refresh token on each refresh, so set Config.OnTokenRefresh to the OnTokenRefresh
+// method to save the new one. Without a refresh token the saved access token is returned until it expires.
+type Saved struct {
+	URL      string
+	ClientID string
+}
+
+func (p Saved) clientID() string {
+	if p.ClientID == "" {
+		return DefaultClientID
+	}
+	return p.ClientID
+}
+
+func (p Saved) Credentials() (*endpoint.Authentication, error) {
+	clientID := p.clientID()
+	cache, err := LoadCache()
+	if err != nil {
+		return nil, err
+	}
+	saved := cache.Find(p.URL, clientID)
+	if saved == nil {
+		return nil, fmt.Errorf("%w: no saved credentials for %s", verror.NoCredentialsError, NormalizeURL(p.URL))
+	}
+	if saved.RefreshToken != "" {
+		return &endpoint.Authentication{RefreshToken: saved.RefreshToken, ClientId: clientID}, nil
+	}
+	if saved.Expired() {
+		return nil, fmt.Errorf("%w: the saved access token for %s expired", verror.NoCredentialsError, saved.URL)
+	}
+	return &endpoint.Authentication{AccessToken: saved.AccessToken, ClientId: clientID}, nil
+}
+
+// OnTokenRefresh saves the tokens of a refresh done by a connector authenticated with p, see tpp.TokenRefreshCallback.
+// The connector keeps working with the tokens it holds if they can not be saved.
+func (p Saved) OnTokenRefresh(resp tpp.OauthRefreshAccessTokenResponse) {
+	cache, err := LockCache()
+	if err != nil {
+		return
+	}
+	defer cache.Unlock()
+	cred := SavedCredential{URL: p.URL, ClientID: p.clientID(), AccessToken: resp.Access_token, RefreshToken: resp.Refresh_token}
+	if resp.Expires > 0 {
+		cred.AccessTokenExpires = time.Unix(int64(resp.Expires), 0).UTC()
+	}
+	if saved := cache.Find(p.URL, cred.ClientID); saved != nil && cred.RefreshToken == "" {
+		cred.RefreshToken = saved.RefreshToken
+	}
+	cache.Put(cred)
+	_ = cache.Save()
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package credentials
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/venafi/tpp"
+	"github.com/Venafi/vcert/v4/pkg/verror"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	for _, url := range []string{"tpp.example.com", "https://TPP.example.com/", "https://tpp.example.com/vedsdk/", "https://tpp.example.com/vedsdk"} {
+		if key := NormalizeURL(url); key != "https://tpp.example.com/" {
+			t.Errorf("%s: expected https://tpp.example.com/, got %s", url, key)
+		}
+	}
+	if key := NormalizeURL("http://tpp.example.com/vedsdk"); key != "http://tpp.example.com/" {
+		t.Errorf("expected the http scheme to be kept, got %s", key)
+	}
+}
+
+func TestCache(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the configuration directory can only be redirected with XDG_CONFIG_HOME")
+	}
+	dir, err := ioutil.TempDir("", "vcertCredentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	previous, had := os.LookupEnv("XDG_CONFIG_HOME")
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	defer func() {
+		if had {
+			os.Setenv("XDG_CONFIG_HOME", previous)
+		} else {
+			os.Unsetenv("XDG_CONFIG_HOME")
+		}
+	}()
+
+	cache, err := LoadCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Put(SavedCredential{URL: "tpp.example.com", ClientID: DefaultClientID, AccessToken: "access-1", AccessTokenExpires: time.Now().Add(time.Hour)})
+	cache.Put(SavedCredential{URL: "https://tpp.example.com/vedsdk", ClientID: DefaultClientID, AccessToken: "access-2", AccessTokenExpires: time.Now().Add(time.Hour)})
+	cache.Put(SavedCredential{URL: "tpp2.example.com", ClientID: DefaultClientID, AccessToken: "access-3", AccessTokenExpires: time.Now().Add(-time.Hour)})
+	if err = cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(cache.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected the credential cache to be readable only by the user, got %s", info.Mode())
+	}
+
+	auth, err := Saved{URL: "TPP.example.com"}.Credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.AccessToken != "access-2" {
+		t.Fatalf("expected the last saved access token, got %+v", auth)
+	}
+	if _, err = (Saved{URL: "tpp2.example.com"}).Credentials(); !errors.Is(err, verror.NoCredentialsError) {
+		t.Fatalf("expected expired tokens not to be returned, got %v", err)
+	}
+	if _, err = (Saved{URL: "tpp.example.com", ClientID: "other"}).Credentials(); !errors.Is(err, verror.NoCredentialsError) {
+		t.Fatalf("expected no credentials for another client ID, got %v", err)
+	}
+
+	// with a refresh token the connector refreshes the access token, and the rotated tokens are saved
+	cache, err = LoadCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Put(SavedCredential{URL: "tpp2.example.com", ClientID: DefaultClientID, AccessToken: "access-3", AccessTokenExpires: time.Now().Add(-time.Hour), RefreshToken: "refresh-3"})
+	if err = cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+	saved := Saved{URL: "tpp2.example.com"}
+	auth, err = saved.Credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.RefreshToken != "refresh-3" || auth.ClientId != DefaultClientID {
+		t.Fatalf("expected the saved refresh token, got %+v", auth)
+	}
+	saved.OnTokenRefresh(tpp.OauthRefreshAccessTokenResponse{Access_token: "access-4", Refresh_token: "refresh-4", Expires: int(time.Now().Add(time.Hour).Unix())})
+	cache, err = LoadCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred := cache.Find("tpp2.example.com", DefaultClientID); cred == nil || cred.AccessToken != "access-4" || cred.RefreshToken != "refresh-4" || cred.Expired() {
+		t.Fatalf("expected the refreshed tokens to be saved, got %+v", cred)
+	}
+
+	// a locked cache is read after the lock is taken and keeps other processes waiting until it is unlocked
+	cache, err = LockCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked := make(chan *Cache)
+	go func() {
+		c, err := LockCache()
+		if err != nil {
+			t.Error(err)
+		}
+		locked <- c
+	}()
+	cache.Put(SavedCredential{URL: "tpp3.example.com", ClientID: DefaultClientID, AccessToken: "access-4"})
+	if err = cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-locked:
+		t.Fatal("expected the cache to stay locked until Unlock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err = cache.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	cache = <-locked
+	if cache == nil {
+		t.FailNow()
+	}
+	if cache.Find("tpp3.example.com", DefaultClientID) == nil {
+		t.Fatal("expected the cache to be read after the lock was taken")
+	}
+	if !cache.Remove("tpp3.example.com", DefaultClientID) {
+		t.Fatal("expected the credential to be removed")
+	}
+	if err = cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err = cache.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = LoadCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cache.Remove("tpp.example.com", DefaultClientID) || cache.Remove("tpp.example.com", DefaultClientID) {
+		t.Fatal("expected the credential to be removed once")
+	}
+	if len(cache.Credentials) != 1 {
+		t.Fatalf("expected one credential left, got %d", len(cache.Credentials))
+	}
+}
@@ -0,0 +1,204 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package credentials provides the endpoint.CredentialProvider implementations used with vcert.Config
+package credentials
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/Venafi/vcert/v4/pkg/verror"
+)
+
+// Keys of the credentials read by File and Command. They are the keys of the vcert INI configuration file.
+const (
+	KeyAccessToken  = "access_token"
+	KeyRefreshToken = "refresh_token"
+	KeyUser         = "tpp_user"
+	KeyPassword     = "tpp_password"
+	KeyAPIKey       = "cloud_apikey"
+)
+
+// Environment variables read by Env
+const (
+	EnvAccessToken  = "VCERT_TOKEN"
+	EnvRefreshToken = "VCERT_REFRESH_TOKEN"
+	EnvUser         = "VCERT_USER"
+	EnvPassword     = "VCERT_PASSWORD"
+	EnvAPIKey       = "VCERT_APIKEY"
+)
+
+// Static always returns the same Authentication
+type Static struct {
+	Authentication *endpoint.Authentication
+}
+
+func (p Static) Credentials() (*endpoint.Authentication, error) {
+	if p.Authentication == nil {
+		return nil, fmt.Errorf("%w: no static credentials", verror.NoCredentialsError)
+	}
+	auth := *p.Authentication
+	return &auth, nil
+}
+
+// Env reads the credentials from the VCERT_TOKEN, VCERT_REFRESH_TOKEN, VCERT_USER, VCERT_PASSWORD and VCERT_APIKEY
+// environment variables
+type Env struct{}
+
+func (Env) Credentials() (*endpoint.Authentication, error) {
+	values := map[string]string{
+		KeyAccessToken:  os.Getenv(EnvAccessToken),
+		KeyRefreshToken: os.Getenv(EnvRefreshToken),
+		KeyUser:         os.Getenv(EnvUser),
+		KeyPassword:     os.Getenv(EnvPassword),
+		KeyAPIKey:       os.Getenv(EnvAPIKey),
+	}
+	auth := authenticationFromValues(values)
+	if auth == nil {
+		return nil, fmt.Errorf("%w: no credentials in the environment", verror.NoCredentialsError)
+	}
+	return auth, nil
+}
+
+// File reads the credentials from Path every time they are requested, so that rotated secrets are picked up.
+// Path is either a directory with a file per key, the way Kubernetes mounts a Secret, or a file with a key=value
+// pair per line.
+type File struct {
+	Path string
+}
+
+func (p File) Credentials() (*endpoint.Authentication, error) {
+	info, err := os.Stat(p.Path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s does not exist", verror.NoCredentialsError, p.Path)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	values := map[string]string{}
+	if info.IsDir() {
+		for _, key := range []string{KeyAccessToken, KeyRefreshToken, KeyUser, KeyPassword, KeyAPIKey} {
+			data, err := ioutil.ReadFile(filepath.Join(p.Path, key))
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to read credentials: %w", err)
+			}
+			values[key] = strings.TrimSpace(string(data))
+		}
+	} else {
+		data, err := ioutil.ReadFile(p.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read credentials: %w", err)
+		}
+		values, err = parseKeyValues(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read credentials from %s: %w", p.Path, err)
+		}
+	}
+
+	auth := authenticationFromValues(values)
+	if auth == nil {
+		return nil, fmt.Errorf("%w: no credentials in %s", verror.NoCredentialsError, p.Path)
+	}
+	return auth, nil
+}
+
+// Command runs an external program every time the credentials are requested, e.g. to fetch them from a vault.
+// The program writes the credentials to stdout as key=value pairs, one per line.
+type Command struct {
+	Path string
+	Args []string
+}
+
+func (p Command) Credentials() (*endpoint.Authentication, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(p.Path, p.Args...) // #nosec
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("credential command %s failed: %w: %s", p.Path, err, strings.TrimSpace(stderr.String()))
+	}
+	values, err := parseKeyValues(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the output of credential command %s: %w", p.Path, err)
+	}
+	auth := authenticationFromValues(values)
+	if auth == nil {
+		return nil, fmt.Errorf("%w: credential command %s returned no credentials", verror.NoCredentialsError, p.Path)
+	}
+	return auth, nil
+}
+
+// Chain returns the credentials of the first of its providers that has some. A provider that fails is skipped,
+// its error is only returned if no provider has credentials.
+type Chain []endpoint.CredentialProvider
+
+func (c Chain) Credentials() (*endpoint.Authentication, error) {
+	var errs []string
+	for _, p := range c {
+		auth, err := p.Credentials()
+		if err == nil {
+			return auth, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("%w: no credential providers", verror.NoCredentialsError)
+	}
+	return nil, fmt.Errorf("%w: %s", verror.NoCredentialsError, strings.Join(errs, "; "))
+}
+
+// parseKeyValues parses key=value lines. Empty lines and lines starting with # are ignored.
+func parseKeyValues(data []byte) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d is not a key=value pair", n)
+		}
+		values[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return values, scanner.Err()
+}
+
+// authenticationFromValues builds the Authentication of values, or returns nil if values hold no credentials
+func authenticationFromValues(values map[string]string) *endpoint.Authentication {
+	auth := &endpoint.Authentication{
+		AccessToken:  values[KeyAccessToken],
+		RefreshToken: values[KeyRefreshToken],
+		User:         values[KeyUser],
+		Password:     values[KeyPassword],
+		APIKey:       values[KeyAPIKey],
+	}
+	if auth.AccessToken == "" && auth.RefreshToken == "" && auth.User == "" && auth.APIKey == "" {
+		return nil
+	}
+	return auth
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2020-2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package credentials
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/Venafi/vcert/v4/pkg/verror"
+)
+
+func TestStatic(t *testing.T) {
+	static := &endpoint.Authentication{AccessToken: "token"}
+	auth, err := Static{Authentication: static}.Credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.ClientId = "changed"
+	if static.ClientId != "" {
+		t.Fatal("Static should return a copy of its Authentication")
+	}
+	if _, err = (Static{}).Credentials(); !errors.Is(err, verror.NoCredentialsError) {
+		t.Fatalf("expected a NoCredentialsError, got %v", err)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	for _, name := range []string{EnvAccessToken, EnvRefreshToken, EnvUser, EnvPassword, EnvAPIKey} {
+		if previous, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, previous)
+		} else {
+			defer os.Unsetenv(name)
+		}
+		os.Unsetenv(name)
+	}
+	if _, err := (Env{}).Credentials(); !errors.Is(err, verror.NoCredentialsError) {
+		t.Fatalf("expected a NoCredentialsError, got %v", err)
+	}
+
+	os.Setenv(EnvUser, "admin")
+	os.Setenv(EnvPassword, "secret")
+	auth, err := Env{}.Credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.User != "admin" || auth.Password != "secret" || auth.AccessToken != "" {
+		t.Fatalf("unexpected credentials %+v", auth)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcertCredentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a directory with a file per key, as Kubernetes mounts a Secret
+	secret := filepath.Join(dir, "secret")
+	if err = os.Mkdir(secret, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(secret, KeyAccessToken), []byte("token-1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	provider := File{Path: secret}
+	auth, err := provider.Credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.AccessToken != "token-1" {
+		t.Fatalf("expected token-1, got %q", auth.AccessToken)
+	}
+	// the secret rotates
+	if err = ioutil.WriteFile(filepath.Join(secret, KeyAccessToken), []byte("token-2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if auth, err = provider.Credentials(); err != nil || auth.AccessToken != "token-2" {
+		t.Fatalf("expected the rotated token-2, got %+v, %v", auth, err)
+	}
+
+	// a file with key=value pairs
+	file := filepath.Join(dir, "credentials")
+	if err = ioutil.WriteFile(file, []byte("# Venafi Cloud\ncloud_apikey = key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if auth, err = (File{Path: file}).Credentials(); err != nil || auth.APIKey != "key" {
+		t.Fatalf("expected the API key, got %+v, %v", auth, err)
+	}
+	if err = ioutil.WriteFile(file, []byte("cloud_apikey\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = (File{Path: file}).Credentials(); err == nil || errors.Is(err, verror.NoCredentialsError) {
+		t.Fatalf("expected a parse error, got %v", err)
+	}
+
+	if _, err = (File{Path: filepath.Join(dir, "missing")}).Credentials(); !errors.Is(err, verror.NoCredentialsError) {
+		t.Fatalf("expected a NoCredentialsError, got %v", err)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test command needs a POSIX shell")
+	}
+	auth, err := Command{Path: "sh", Args: []string{"-c", "echo refresh_token=refresh"}}.Credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.RefreshToken != "refresh" {
+		t.Fatalf("expected the refresh token, got %+v", auth)
+	}
+
+	_, err = Command{Path: "sh", Args: []string{"-c", "echo vault is sealed >&2; exit 1"}}.Credentials()
+	if err == nil || !strings.Contains(err.Error(), "vault is sealed") {
+		t.Fatalf("expected the error of the command, got %v", err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	chain := Chain{
+		File{Path: "/nonexistent/vcert/credentials"},
+		Static{Authentication: &endpoint.Authentication{APIKey: "first"}},
+		Static{Authentication: &endpoint.Authentication{APIKey: "second"}},
+	}
+	auth, err := chain.Credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.APIKey != "first" {
+		t.Fatalf("expected the credentials of the first provider that has some, got %+v", auth)
+	}
+
+	_, err = Chain{File{Path: "/nonexistent/vcert/credentials"}, Static{}}.Credentials()
+	if !errors.Is(err, verror.NoCredentialsError) || !strings.Contains(err.Error(), "/nonexistent/vcert/credentials") {
+		t.Fatalf("expected a NoCredentialsError listing the providers, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright 2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package endpoint
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"golang.org/x/crypto/pkcs12"
+
+	"github.com/Venafi/vcert/v4/pkg/verror"
+)
+
+// ClientCertificate is the certificate a Connector presents to servers that require client certificate
+// authentication (mutual TLS). Exactly one of the PEM pair, the PKCS#12 archive or the Signer should be set.
+type ClientCertificate struct {
+	// CertificatePEM is the PEM encoded certificate, optionally followed by its chain, and KeyPEM its private key
+	CertificatePEM []byte
+	KeyPEM         []byte
+	// PKCS12 is an archive holding the certificate, its chain and its private key, encrypted with PKCS12Password
+	PKCS12         []byte
+	PKCS12Password string
+	// Certificate is the DER encoded certificate, optionally followed by its chain, whose private key is Signer.
+	// It is meant for keys that can not be exported, e.g. kept in an HSM.
+	Certificate [][]byte
+	Signer      crypto.Signer
+}
+
+// TLSCertificate returns the client certificate in the form used by crypto/tls
+func (c *ClientCertificate) TLSCertificate() (tls.Certificate, error) {
+	switch {
+	case len(c.CertificatePEM) > 0:
+		cert, err := tls.X509KeyPair(c.CertificatePEM, c.KeyPEM)
+		if err != nil {
+			return cert, fmt.Errorf("%w: failed to load client certificate: %s", verror.UserDataError, err)
+		}
+		return cert, nil
+
+	case len(c.PKCS12) > 0:
+		blocks, err := pkcs12.ToPEM(c.PKCS12, c.PKCS12Password)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("%w: failed to read client PKCS#12 archive: %s", verror.UserDataError, err)
+		}
+		var pemData []byte
+		for _, b := range blocks {
+			pemData = append(pemData, pem.EncodeToMemory(b)...)
+		}
+		cert, err := tls.X509KeyPair(pemData, pemData)
+		if err != nil {
+			return cert, fmt.Errorf("%w: failed to load client certificate from PKCS#12 archive: %s", verror.UserDataError, err)
+		}
+		return cert, nil
+
+	case c.Signer != nil:
+		if len(c.Certificate) == 0 {
+			return tls.Certificate{}, fmt.Errorf("%w: client certificate signer has no certificate", verror.UserDataError)
+		}
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("%w: failed to parse client certificate: %s", verror.UserDataError, err)
+		}
+		certKey, err := x509.MarshalPKIXPublicKey(leaf.PublicKey)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("%w: failed to read client certificate public key: %s", verror.UserDataError, err)
+		}
+		signerKey, err := x509.MarshalPKIXPublicKey(c.Signer.Public())
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("%w: failed to read client certificate signer public key: %s", verror.UserDataError, err)
+		}
+		if !bytes.Equal(certKey, signerKey) {
+			return tls.Certificate{}, fmt.Errorf("%w: client certificate does not match the public key of its signer", verror.UserDataError)
+		}
+		return tls.Certificate{Certificate: c.Certificate, PrivateKey: c.Signer, Leaf: leaf}, nil
+	}
+	return tls.Certificate{}, fmt.Errorf("%w: client certificate is empty", verror.UserDataError)
+}
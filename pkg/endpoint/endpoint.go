@@ -22,16 +22,19 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
-	"log"
-	"net"
 	"net/http"
 	"regexp"
+	"time"
 
 	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/verror"
 )
 
 const SDKName = "Venafi VCert-Go"
 
+// LocalIP is no longer set.
+//
+// Deprecated: connectors resolve the identifier of the client when they need it, see ClientIdentity.
 var LocalIP string
 
 // ConnectorType represents the available connectors
@@ -47,11 +50,6 @@ const (
 	ConnectorTypeTPP
 )
 
-func init() {
-	log.SetPrefix("vCert: ")
-	LocalIP = getPrimaryNetAddr()
-}
-
 func (t ConnectorType) String() string {
 	switch t {
 	case ConnectorTypeUndefined:
@@ -110,24 +108,52 @@ type Authentication struct {
 	Scope        string
 	ClientId     string
 	AccessToken  string
+	// ClientPKCS12 authenticates to TPP with the client certificate of the connector, see Config.ClientCertificate
 	ClientPKCS12 bool
 }
 
-//todo: replace with verror
-// ErrRetrieveCertificateTimeout provides a common error structure for a timeout while retrieving a certificate
+// CredentialProvider supplies the Authentication of a Connector. Connectors given one query it again when the server
+// rejects their credentials, so that providers backed by rotating secrets can return the current ones.
+type CredentialProvider interface {
+	// Credentials returns the current credentials, or an error matching verror.NoCredentialsError if the provider
+	// has none
+	Credentials() (*Authentication, error)
+}
+
+// ErrRetrieveCertificateTimeout is returned by RetrieveCertificate when the certificate was not issued within
+// the Timeout of the request. It matches verror.RetrieveCertificateTimeoutError with errors.Is.
 type ErrRetrieveCertificateTimeout struct {
 	CertificateID string
+	// Status is the last status of the request reported by the server, if any
+	Status string
+	// Elapsed is how long RetrieveCertificate waited for the certificate
+	Elapsed time.Duration
 }
 
 func (err ErrRetrieveCertificateTimeout) Error() string {
-	return fmt.Sprintf("Operation timed out. You may try retrieving the certificate later using Pickup ID: %s", err.CertificateID)
+	msg := "Operation timed out"
+	if err.Elapsed > 0 {
+		msg += fmt.Sprintf(" after %s", err.Elapsed.Round(time.Second))
+	}
+	msg += fmt.Sprintf(". You may try retrieving the certificate later using Pickup ID: %s", err.CertificateID)
+	if err.Status != "" {
+		msg += fmt.Sprintf("\n\tStatus: %s", err.Status)
+	}
+	return msg
+}
+
+func (err ErrRetrieveCertificateTimeout) Unwrap() error {
+	return verror.RetrieveCertificateTimeoutError
 }
 
-//todo: replace with verror
-// ErrCertificatePending provides a common error structure for a timeout while retrieving a certificate
+// ErrCertificatePending is returned by RetrieveCertificate when the certificate is not issued yet and the request
+// has no Timeout to wait for it. It matches verror.CertificatePendingError with errors.Is.
 type ErrCertificatePending struct {
 	CertificateID string
-	Status        string
+	// Status is the status of the request reported by the server, if any
+	Status string
+	// Elapsed is how long RetrieveCertificate waited for the certificate
+	Elapsed time.Duration
 }
 
 func (err ErrCertificatePending) Error() string {
@@ -137,6 +163,10 @@ func (err ErrCertificatePending) Error() string {
 	return fmt.Sprintf("Issuance is pending. You may try retrieving the certificate later using Pickup ID: %s\n\tStatus: %s", err.CertificateID, err.Status)
 }
 
+func (err ErrCertificatePending) Unwrap() error {
+	return verror.CertificatePendingError
+}
+
 // Policy is struct that contains restrictions for certificates. Most of the fields contains list of regular expression.
 // For satisfying policies, all values in the certificate field must match AT LEAST ONE regular expression in corresponding policy field.
 type Policy struct {
@@ -435,12 +465,3 @@ func (z *ZoneConfiguration) UpdateCertificateRequest(request *certificate.Reques
 		}
 	}
 }
-
-func getPrimaryNetAddr() string {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
-	if err != nil {
-		return "0.0.0.0"
-	}
-	defer conn.Close()
-	return conn.LocalAddr().(*net.UDPAddr).IP.String()
-}
@@ -18,10 +18,13 @@ package endpoint
 
 import (
 	"crypto/x509"
+	"errors"
 	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/verror"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewZoneConfiguration(t *testing.T) {
@@ -31,6 +34,22 @@ func TestNewZoneConfiguration(t *testing.T) {
 	}
 }
 
+func TestRetrieveCertificateErrors(t *testing.T) {
+	var err error = ErrRetrieveCertificateTimeout{CertificateID: "id", Status: "Pending", Elapsed: 90*time.Second + 300*time.Millisecond}
+	if !errors.Is(err, verror.RetrieveCertificateTimeoutError) || !errors.Is(err, verror.VcertError) {
+		t.Fatalf("expected %q to match RetrieveCertificateTimeoutError", err)
+	}
+	expected := "Operation timed out after 1m30s. You may try retrieving the certificate later using Pickup ID: id\n\tStatus: Pending"
+	if err.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, err)
+	}
+
+	err = ErrCertificatePending{CertificateID: "id", Status: "Pending"}
+	if !errors.Is(err, verror.CertificatePendingError) || errors.Is(err, verror.RetrieveCertificateTimeoutError) {
+		t.Fatalf("expected %q to match CertificatePendingError only", err)
+	}
+}
+
 func TestUpdateRequestSubject(t *testing.T) {
 	req := certificate.Request{}
 	req.Subject.CommonName = "vcert.test.vfidev.com"
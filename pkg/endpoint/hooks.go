@@ -0,0 +1,227 @@
+/*
+ * Copyright 2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package endpoint
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/verror"
+)
+
+// Operation names a Connector operation observed by Hooks
+type Operation string
+
+const (
+	OperationPing         Operation = "ping"
+	OperationAuthenticate Operation = "authenticate"
+	OperationReadPolicy   Operation = "read_policy"
+	OperationReadZone     Operation = "read_zone"
+	OperationRequest      Operation = "request"
+	OperationRetrieve     Operation = "retrieve"
+	OperationRenew        Operation = "renew"
+	OperationRevoke       Operation = "revoke"
+	OperationImport       Operation = "import"
+	OperationList         Operation = "list"
+)
+
+// OperationInfo describes an operation of a Connector
+type OperationInfo struct {
+	Operation     Operation
+	ConnectorType ConnectorType
+	Zone          string
+}
+
+// HTTPRequestInfo describes an HTTP request sent by a Connector. RetrieveCertificate sends one per poll.
+type HTTPRequestInfo struct {
+	ConnectorType ConnectorType
+	Zone          string
+	Method        string
+	URL           string
+}
+
+// Outcome is the result of an operation or an HTTP request
+type Outcome struct {
+	Duration time.Duration
+	// StatusCode is the status code of the response of an HTTP request, 0 if none was received
+	StatusCode int
+	// Err is the error of a failed operation, or of an HTTP request that received no response
+	Err error
+}
+
+// ErrorCategory returns the verror.Category of the error of o, "" on success. HTTP requests whose response has an
+// error status are categorized by it.
+func (o Outcome) ErrorCategory() string {
+	if o.Err != nil {
+		return verror.Category(o.Err)
+	}
+	switch {
+	case o.StatusCode == http.StatusUnauthorized || o.StatusCode == http.StatusForbidden:
+		return verror.CategoryAuth
+	case o.StatusCode == http.StatusBadGateway || o.StatusCode == http.StatusServiceUnavailable || o.StatusCode == http.StatusGatewayTimeout:
+		return verror.CategoryServerUnavailable
+	case o.StatusCode >= 500:
+		return verror.CategoryServer
+	case o.StatusCode >= 400:
+		return verror.CategoryUserData
+	}
+	return ""
+}
+
+// Hooks observes the operations of a Connector and the HTTP requests they send, to export metrics or traces. The
+// Start methods are called when an operation or a request starts and return the function called with its Outcome
+// when it ends. They are called concurrently by concurrent operations.
+type Hooks interface {
+	StartOperation(op OperationInfo) func(Outcome)
+	StartHTTPRequest(req HTTPRequestInfo) func(Outcome)
+}
+
+// MultiHooks returns Hooks that call all of hooks, e.g. to export both metrics and traces
+func MultiHooks(hooks ...Hooks) Hooks {
+	return multiHooks(hooks)
+}
+
+type multiHooks []Hooks
+
+func (m multiHooks) StartOperation(op OperationInfo) func(Outcome) {
+	ends := make([]func(Outcome), len(m))
+	for i, h := range m {
+		ends[i] = h.StartOperation(op)
+	}
+	return func(o Outcome) {
+		for _, end := range ends {
+			end(o)
+		}
+	}
+}
+
+func (m multiHooks) StartHTTPRequest(req HTTPRequestInfo) func(Outcome) {
+	ends := make([]func(Outcome), len(m))
+	for i, h := range m {
+		ends[i] = h.StartHTTPRequest(req)
+	}
+	return func(o Outcome) {
+		for _, end := range ends {
+			end(o)
+		}
+	}
+}
+
+// WithHooks returns a Connector that calls hooks around the operations of c. The HTTP requests are observed by the
+// connectors themselves, see the SetHooks methods of the TPP and Cloud connectors. vcert.Config sets up both.
+// The returned Connector has an Unwrap method returning c, for the methods of c that are not part of Connector:
+//
+//	if u, ok := connector.(interface{ Unwrap() endpoint.Connector }); ok {
+//		tppConnector, ok := u.Unwrap().(*tpp.Connector)
+//	}
+func WithHooks(c Connector, hooks Hooks) Connector {
+	return &hookedConnector{Connector: c, hooks: hooks}
+}
+
+// hookedConnector tracks the zone of the Connector it wraps, which the Connector interface does not expose
+type hookedConnector struct {
+	Connector
+	hooks Hooks
+
+	zoneLock sync.RWMutex
+	zone     string
+}
+
+// Unwrap returns the Connector passed to WithHooks
+func (c *hookedConnector) Unwrap() Connector {
+	return c.Connector
+}
+
+func (c *hookedConnector) start(op Operation) func(error) {
+	start := time.Now()
+	c.zoneLock.RLock()
+	zone := c.zone
+	c.zoneLock.RUnlock()
+	end := c.hooks.StartOperation(OperationInfo{Operation: op, ConnectorType: c.GetType(), Zone: zone})
+	return func(err error) {
+		end(Outcome{Duration: time.Since(start), Err: err})
+	}
+}
+
+func (c *hookedConnector) SetZone(z string) {
+	c.zoneLock.Lock()
+	c.zone = z
+	c.zoneLock.Unlock()
+	c.Connector.SetZone(z)
+}
+
+func (c *hookedConnector) Ping() (err error) {
+	end := c.start(OperationPing)
+	defer func() { end(err) }()
+	return c.Connector.Ping()
+}
+
+func (c *hookedConnector) Authenticate(auth *Authentication) (err error) {
+	end := c.start(OperationAuthenticate)
+	defer func() { end(err) }()
+	return c.Connector.Authenticate(auth)
+}
+
+func (c *hookedConnector) ReadPolicyConfiguration() (policy *Policy, err error) {
+	end := c.start(OperationReadPolicy)
+	defer func() { end(err) }()
+	return c.Connector.ReadPolicyConfiguration()
+}
+
+func (c *hookedConnector) ReadZoneConfiguration() (config *ZoneConfiguration, err error) {
+	end := c.start(OperationReadZone)
+	defer func() { end(err) }()
+	return c.Connector.ReadZoneConfiguration()
+}
+
+func (c *hookedConnector) RequestCertificate(req *certificate.Request) (requestID string, err error) {
+	end := c.start(OperationRequest)
+	defer func() { end(err) }()
+	return c.Connector.RequestCertificate(req)
+}
+
+func (c *hookedConnector) RetrieveCertificate(req *certificate.Request) (certificates *certificate.PEMCollection, err error) {
+	end := c.start(OperationRetrieve)
+	defer func() { end(err) }()
+	return c.Connector.RetrieveCertificate(req)
+}
+
+func (c *hookedConnector) RevokeCertificate(req *certificate.RevocationRequest) (err error) {
+	end := c.start(OperationRevoke)
+	defer func() { end(err) }()
+	return c.Connector.RevokeCertificate(req)
+}
+
+func (c *hookedConnector) RenewCertificate(req *certificate.RenewalRequest) (requestID string, err error) {
+	end := c.start(OperationRenew)
+	defer func() { end(err) }()
+	return c.Connector.RenewCertificate(req)
+}
+
+func (c *hookedConnector) ImportCertificate(req *certificate.ImportRequest) (resp *certificate.ImportResponse, err error) {
+	end := c.start(OperationImport)
+	defer func() { end(err) }()
+	return c.Connector.ImportCertificate(req)
+}
+
+func (c *hookedConnector) ListCertificates(filter Filter) (certs []certificate.CertificateInfo, err error) {
+	end := c.start(OperationList)
+	defer func() { end(err) }()
+	return c.Connector.ListCertificates(filter)
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright 2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package endpoint
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/verror"
+)
+
+// stubConnector implements the operations the test calls, the other methods of the nil Connector panic
+type stubConnector struct {
+	Connector
+	zone string
+}
+
+func (c *stubConnector) GetType() ConnectorType { return ConnectorTypeTPP }
+func (c *stubConnector) SetZone(z string)       { c.zone = z }
+func (c *stubConnector) Authenticate(auth *Authentication) error {
+	return nil
+}
+func (c *stubConnector) RetrieveCertificate(req *certificate.Request) (*certificate.PEMCollection, error) {
+	return nil, ErrCertificatePending{CertificateID: req.PickupID}
+}
+
+// recordingHooks records the operations and HTTP requests it is called for
+type recordingHooks struct {
+	calls []string
+}
+
+func (h *recordingHooks) StartOperation(op OperationInfo) func(Outcome) {
+	return func(o Outcome) {
+		h.calls = append(h.calls, fmt.Sprintf("%s %s %s %q", op.ConnectorType, op.Zone, op.Operation, o.ErrorCategory()))
+	}
+}
+
+func (h *recordingHooks) StartHTTPRequest(req HTTPRequestInfo) func(Outcome) {
+	return func(o Outcome) {
+		h.calls = append(h.calls, fmt.Sprintf("%s %s %d %q", req.Method, req.URL, o.StatusCode, o.ErrorCategory()))
+	}
+}
+
+func TestWithHooks(t *testing.T) {
+	stub := &stubConnector{}
+	first, second := &recordingHooks{}, &recordingHooks{}
+	c := WithHooks(stub, MultiHooks(first, second))
+	c.SetZone(`Certificates\vcert`)
+	if stub.zone != `Certificates\vcert` {
+		t.Fatal("expected the zone to be set on the wrapped connector")
+	}
+	if u, ok := c.(interface{ Unwrap() Connector }); !ok || u.Unwrap() != stub {
+		t.Fatal("expected Unwrap to return the wrapped connector")
+	}
+	err := c.Authenticate(&Authentication{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.RetrieveCertificate(&certificate.Request{PickupID: `\VED\Policy\Certificates\vcert\example.com`})
+	if err == nil {
+		t.Fatal("expected the error of the wrapped connector")
+	}
+
+	expected := []string{`TPP Certificates\vcert authenticate ""`, `TPP Certificates\vcert retrieve "pending"`}
+	for _, h := range []*recordingHooks{first, second} {
+		if fmt.Sprint(h.calls) != fmt.Sprint(expected) {
+			t.Fatalf("expected the operations %q, got %q", expected, h.calls)
+		}
+	}
+}
+
+func TestOutcomeErrorCategory(t *testing.T) {
+	cases := map[Outcome]string{
+		{StatusCode: http.StatusOK}:                  "",
+		{StatusCode: http.StatusUnauthorized}:        verror.CategoryAuth,
+		{StatusCode: http.StatusNotFound}:            verror.CategoryUserData,
+		{StatusCode: http.StatusServiceUnavailable}:  verror.CategoryServerUnavailable,
+		{StatusCode: http.StatusInternalServerError}: verror.CategoryServer,
+		{Err: verror.ZoneNotFoundError}:              verror.CategoryNotFound,
+	}
+	for o, category := range cases {
+		if o.ErrorCategory() != category {
+			t.Fatalf("expected %+v to be categorized as %q, got %q", o, category, o.ErrorCategory())
+		}
+	}
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright 2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package endpoint
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/Venafi/vcert/v4/pkg/verror"
+)
+
+// ClientIdentitySource selects where the identifier of a ClientIdentity comes from
+type ClientIdentitySource int
+
+const (
+	// ClientIdentityPrimaryAddress identifies the client by the local IP address of its route to the internet. No
+	// packets are sent to find it.
+	ClientIdentityPrimaryAddress ClientIdentitySource = iota
+	// ClientIdentityExplicit identifies the client by ClientIdentity.Identifier
+	ClientIdentityExplicit
+	// ClientIdentityHostname identifies the client by the host name reported by the kernel
+	ClientIdentityHostname
+	// ClientIdentityInterface identifies the client by the address of the network interface ClientIdentity.Interface
+	ClientIdentityInterface
+	// ClientIdentityDisabled sends no identifier
+	ClientIdentityDisabled
+)
+
+// ClientIdentity configures the identifier a connector sends with the certificates it requests and imports, which
+// Venafi Cloud records as the client that did it. The zero value identifies the client by its primary IP address.
+type ClientIdentity struct {
+	Source ClientIdentitySource
+	// Identifier is the identifier of ClientIdentityExplicit
+	Identifier string
+	// Interface is the name of the network interface of ClientIdentityInterface, e.g. "eth0"
+	Interface string
+}
+
+// Validate returns an error if a field required by the Source of i is missing
+func (i ClientIdentity) Validate() error {
+	switch i.Source {
+	case ClientIdentityPrimaryAddress, ClientIdentityHostname, ClientIdentityDisabled:
+		return nil
+	case ClientIdentityExplicit:
+		if i.Identifier == "" {
+			return fmt.Errorf("%w: an explicit client identity requires an identifier", verror.UserDataError)
+		}
+	case ClientIdentityInterface:
+		if i.Interface == "" {
+			return fmt.Errorf("%w: an interface client identity requires the name of the interface", verror.UserDataError)
+		}
+	default:
+		return fmt.Errorf("%w: unknown client identity source %d", verror.UserDataError, i.Source)
+	}
+	return nil
+}
+
+// Resolve returns the identifier of the client, which is empty for ClientIdentityDisabled. It may look up the network
+// configuration, so connectors call it when they first need the identifier and not before.
+func (i ClientIdentity) Resolve() (string, error) {
+	if err := i.Validate(); err != nil {
+		return "", err
+	}
+	switch i.Source {
+	case ClientIdentityExplicit:
+		return i.Identifier, nil
+	case ClientIdentityHostname:
+		return os.Hostname()
+	case ClientIdentityInterface:
+		return interfaceAddr(i.Interface)
+	case ClientIdentityDisabled:
+		return "", nil
+	default:
+		return primaryAddr()
+	}
+}
+
+// primaryAddr returns the local address of the route to the internet. Dialing UDP only selects the route.
+func primaryAddr() (string, error) {
+	conn, err := net.Dial("udp", "8.8.8.8:80")
+	if err != nil {
+		return "", fmt.Errorf("failed to find the primary address: %s", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// interfaceAddr returns the first global unicast address of the network interface name, preferring IPv4
+func interfaceAddr(name string) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", verror.UserDataError, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the addresses of interface %s: %s", name, err)
+	}
+	var ipv6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP.String(), nil
+		}
+		if ipv6 == nil {
+			ipv6 = ipNet.IP
+		}
+	}
+	if ipv6 == nil {
+		return "", fmt.Errorf("%w: interface %s has no global unicast address", verror.UserDataError, name)
+	}
+	return ipv6.String(), nil
+}
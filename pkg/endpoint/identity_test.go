@@ -0,0 +1,54 @@
+/*
+ * Copyright 2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package endpoint
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/Venafi/vcert/v4/pkg/verror"
+)
+
+func TestClientIdentity(t *testing.T) {
+	id, err := ClientIdentity{Source: ClientIdentityExplicit, Identifier: "build-agent-7"}.Resolve()
+	if err != nil || id != "build-agent-7" {
+		t.Fatalf("expected the explicit identifier, got %q, %v", id, err)
+	}
+	id, err = ClientIdentity{Source: ClientIdentityDisabled, Identifier: "ignored"}.Resolve()
+	if err != nil || id != "" {
+		t.Fatalf("expected no identifier, got %q, %v", id, err)
+	}
+	hostname, _ := os.Hostname()
+	id, err = ClientIdentity{Source: ClientIdentityHostname}.Resolve()
+	if err != nil || id != hostname {
+		t.Fatalf("expected the host name %s, got %q, %v", hostname, id, err)
+	}
+
+	invalid := map[string]ClientIdentity{
+		"explicit without identifier": {Source: ClientIdentityExplicit},
+		"interface without name":      {Source: ClientIdentityInterface},
+		"unknown interface":           {Source: ClientIdentityInterface, Interface: "vcert-test0"},
+		"unknown source":              {Source: ClientIdentityDisabled + 1},
+	}
+	for name, i := range invalid {
+		_, err = i.Resolve()
+		if !errors.Is(err, verror.UserDataError) {
+			t.Fatalf("%s: expected a user data error, got %v", name, err)
+		}
+	}
+}
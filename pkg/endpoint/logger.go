@@ -0,0 +1,101 @@
+/*
+ * Copyright 2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package endpoint
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger receives the log messages of the connectors. keysAndValues are alternating keys and values of the fields of
+// the message, such as "zone", "pickupId" or "duration". The method set matches the leveled methods of common
+// structured loggers, so that e.g. a *slog.Logger or an hclog.Logger can be used directly and others with a small
+// adapter.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// NewStdLogger returns a Logger writing to l, or to the standard logger of package log if l is nil. Messages are
+// written as "msg key=value ...". Debug messages are written only if debug is true.
+func NewStdLogger(l *log.Logger, debug bool) Logger {
+	return &stdLogger{l: l, debug: debug}
+}
+
+// NewDiscardLogger returns a Logger that drops all messages
+func NewDiscardLogger() Logger {
+	return discardLogger{}
+}
+
+type stdLogger struct {
+	l     *log.Logger
+	debug bool
+}
+
+func (s *stdLogger) Debug(msg string, keysAndValues ...interface{}) {
+	if s.debug {
+		s.output("", msg, keysAndValues)
+	}
+}
+
+func (s *stdLogger) Info(msg string, keysAndValues ...interface{}) {
+	s.output("", msg, keysAndValues)
+}
+
+func (s *stdLogger) Warn(msg string, keysAndValues ...interface{}) {
+	s.output("Warning: ", msg, keysAndValues)
+}
+
+func (s *stdLogger) Error(msg string, keysAndValues ...interface{}) {
+	s.output("Error: ", msg, keysAndValues)
+}
+
+func (s *stdLogger) output(level, msg string, keysAndValues []interface{}) {
+	line := level + msg + formatFields(keysAndValues)
+	if s.l != nil {
+		_ = s.l.Output(3, line)
+	} else {
+		_ = log.Output(3, line)
+	}
+}
+
+// formatFields formats keysAndValues as " key=value ...". Values with spaces are quoted.
+func formatFields(keysAndValues []interface{}) string {
+	var b strings.Builder
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		value := "<missing>"
+		if i+1 < len(keysAndValues) {
+			value = fmt.Sprint(keysAndValues[i+1])
+		}
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, " %s=%s", key, value)
+	}
+	return b.String()
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Debug(string, ...interface{}) {}
+func (discardLogger) Info(string, ...interface{})  {}
+func (discardLogger) Warn(string, ...interface{})  {}
+func (discardLogger) Error(string, ...interface{}) {}
@@ -0,0 +1,46 @@
+/*
+ * Copyright 2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package endpoint
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestStdLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := NewStdLogger(log.New(&out, "", 0), false)
+	logger.Debug("Request completed", "status", "200 OK")
+	logger.Info("Starting server", "port", "443")
+	logger.Warn("Skipping empty section", "section", "")
+	logger.Error("Failed to save the metadata", "pickupId", `\VED\Policy\cert`, "error", errors.New("not found"), "odd")
+	expected := "Starting server port=443\n" +
+		"Warning: Skipping empty section section=\"\"\n" +
+		"Error: Failed to save the metadata pickupId=\\VED\\Policy\\cert error=\"not found\" odd=<missing>\n"
+	if out.String() != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+
+	out.Reset()
+	NewStdLogger(log.New(&out, "", 0), true).Debug("Request completed", "duration", 1500*time.Millisecond)
+	if out.String() != "Request completed duration=1.5s\n" {
+		t.Fatalf("expected the debug message, got %s", out.String())
+	}
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright 2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package endpoint
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Redacted replaces the secrets in traced requests and responses
+const Redacted = "[REDACTED]"
+
+// sensitiveNames are the parts of the names of headers, query parameters and JSON fields whose values are redacted.
+// Names are compared in lower case without - and _.
+var sensitiveNames = []string{"password", "passphrase", "apikey", "token", "secret", "authorization", "cookie", "privatekey", "pkcs12", "keystore"}
+
+var privateKeyPEM = regexp.MustCompile(`(?s)-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----.*?-----END [A-Z0-9 ]*PRIVATE KEY-----`)
+
+// traceTransport writes the requests sent through it and their responses to w
+type traceTransport struct {
+	next http.RoundTripper
+	w    io.Writer
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		// the request given to RoundTrip must not be modified, so its copy gets the body that was read
+		req = req.Clone(req.Context())
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	start := time.Now()
+	res, err := t.next.RoundTrip(req)
+	var resBody []byte
+	if err == nil {
+		resBody, err = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		res.Body = ioutil.NopCloser(bytes.NewReader(resBody))
+	}
+	t.trace(req, reqBody, res, resBody, time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// trace writes an entry in one Write call, so that entries of concurrent requests are not interleaved
+func (t *traceTransport) trace(req *http.Request, reqBody []byte, res *http.Response, resBody []byte, elapsed time.Duration, err error) {
+	var b strings.Builder
+	status := ""
+	if err != nil {
+		status = "failed: " + err.Error()
+	} else {
+		status = res.Status
+	}
+	fmt.Fprintf(&b, "%s %s %s in %s\n", req.Method, redactURL(req), status, elapsed.Round(time.Millisecond))
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := strings.Join(req.Header[name], ", ")
+		if isSensitiveName(name) {
+			value = Redacted
+		}
+		fmt.Fprintf(&b, "> %s: %s\n", name, value)
+	}
+	if len(reqBody) > 0 {
+		fmt.Fprintf(&b, "> %s\n", RedactBody(reqBody))
+	}
+	if res != nil && len(resBody) > 0 {
+		fmt.Fprintf(&b, "< %s\n", RedactBody(resBody))
+	}
+	_, _ = io.WriteString(t.w, b.String())
+}
+
+func redactURL(req *http.Request) string {
+	u := *req.URL
+	u.User = nil
+	query := u.Query()
+	redacted := false
+	for name := range query {
+		if isSensitiveName(name) {
+			query.Set(name, Redacted)
+			redacted = true
+		}
+	}
+	if redacted {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// RedactBody returns body with the API keys, tokens, passwords, private keys and PKCS#12 archives it holds
+// replaced by Redacted. Binary data is replaced as a whole.
+func RedactBody(body []byte) string {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&v); err == nil && !d.More() {
+		var redacted bytes.Buffer
+		e := json.NewEncoder(&redacted)
+		e.SetEscapeHTML(false)
+		if err := e.Encode(redactJSON(v)); err == nil {
+			return strings.TrimSuffix(redacted.String(), "\n")
+		}
+	}
+
+	if !utf8.Valid(body) || bytes.IndexFunc(body, func(r rune) bool { return unicode.IsControl(r) && !unicode.IsSpace(r) }) >= 0 {
+		return fmt.Sprintf("%s %d bytes of binary data", Redacted, len(body))
+	}
+	return privateKeyPEM.ReplaceAllString(string(body), Redacted)
+}
+
+func redactJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for name, value := range v {
+			if value != nil && value != "" && isSensitiveName(name) {
+				v[name] = Redacted
+			} else {
+				v[name] = redactJSON(value)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactJSON(v[i])
+		}
+	case string:
+		if isSensitiveValue(v) {
+			return Redacted
+		}
+	}
+	return v
+}
+
+func isSensitiveName(name string) bool {
+	name = strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(name))
+	for _, s := range sensitiveNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSensitiveValue reports whether s is, or is the base64 encoding of, a private key or a key store, which are
+// sent in fields such as CertificateData whose name does not tell
+func isSensitiveValue(s string) bool {
+	if strings.Contains(s, "PRIVATE KEY") {
+		return true
+	}
+	if len(s) < 64 {
+		return false
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return false
+	}
+	if bytes.Contains(data, []byte("PRIVATE KEY")) || bytes.HasPrefix(data, []byte{0xfe, 0xed, 0xfe, 0xed}) {
+		return true
+	}
+	// a PKCS#12 archive is a sequence starting with version 3
+	var pfx struct {
+		Version  int
+		AuthSafe asn1.RawValue
+		MacData  asn1.RawValue `asn1:"optional"`
+	}
+	_, err = asn1.Unmarshal(data, &pfx)
+	return err == nil && pfx.Version == 3
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright 2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package endpoint
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestRedactBody(t *testing.T) {
+	key, der := generateClientCertificate(t)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p12, err := pkcs12.Encode(rand.Reader, key, cert, nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte("key")}))
+
+	cases := []struct {
+		body     string
+		redacted string
+	}{
+		{`{"Username":"admin","Password":"hunter2"}`, `{"Password":"[REDACTED]","Username":"admin"}`},
+		{`{"access_token":"a1","refresh_token":"","expires":1600000000}`, `{"access_token":"[REDACTED]","expires":1600000000,"refresh_token":""}`},
+		{`{"apiKeys":[{"key":"k"}],"user":{"x-api-key":"k"}}`, `{"apiKeys":"[REDACTED]","user":{"x-api-key":"[REDACTED]"}}`},
+		{`{"CertificateData":"` + base64.StdEncoding.EncodeToString(p12) + `"}`, `{"CertificateData":"[REDACTED]"}`},
+		{`{"CertificateData":"` + base64.StdEncoding.EncodeToString([]byte(certPEM+keyPEM)) + `"}`, `{"CertificateData":"[REDACTED]"}`},
+		{`{"PrivateKeyData":"` + strings.Replace(keyPEM, "\n", `\n`, -1) + `"}`, `{"PrivateKeyData":"[REDACTED]"}`},
+		{certPEM + keyPEM, certPEM + "[REDACTED]\n"},
+		{string(p12), fmt.Sprintf("[REDACTED] %d bytes of binary data", len(p12))},
+	}
+	for _, c := range cases {
+		redacted := RedactBody([]byte(c.body))
+		if redacted != c.redacted {
+			t.Fatalf("expected %s to be redacted as\n%s\ngot\n%s", c.body, c.redacted, redacted)
+		}
+	}
+
+	// certificates are public, so they are not redacted
+	body := `{"CertificateData":"` + base64.StdEncoding.EncodeToString([]byte(certPEM)) + `"}`
+	if RedactBody([]byte(body)) != body {
+		t.Fatalf("expected the certificate not to be redacted, got %s", RedactBody([]byte(body)))
+	}
+}
+
+func TestTrace(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != `{"Password":"hunter2"}` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"APIKey":"c0ffee"}`))
+	}))
+	defer server.Close()
+
+	var trace bytes.Buffer
+	client := NewHTTPClient(TransportConfig{Trace: &trace})
+	req, _ := http.NewRequest("POST", server.URL+"/vedsdk/authorize/?apikey=c0ffee", strings.NewReader(`{"Password":"hunter2"}`))
+	req.Header.Add("x-venafi-api-key", "c0ffee")
+	req.Header.Add("content-type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != `{"APIKey":"c0ffee"}` {
+		t.Fatalf("expected the request and response to be unchanged by tracing, got %s %s", res.Status, body)
+	}
+
+	log := trace.String()
+	if strings.Contains(log, "hunter2") || strings.Contains(log, "c0ffee") {
+		t.Fatalf("expected the secrets to be redacted:\n%s", log)
+	}
+	for _, expected := range []string{"POST " + server.URL + "/vedsdk/authorize/?apikey=%5BREDACTED%5D 200 OK in ", "> Content-Type: application/json", "> X-Venafi-Api-Key: [REDACTED]", `> {"Password":"[REDACTED]"}`, `< {"APIKey":"[REDACTED]"}`} {
+		if !strings.Contains(log, expected) {
+			t.Fatalf("expected the trace to contain %q:\n%s", expected, log)
+		}
+	}
+}
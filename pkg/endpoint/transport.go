@@ -0,0 +1,162 @@
+/*
+ * Copyright 2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package endpoint
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Defaults of the timeouts of TransportConfig
+const (
+	DefaultTimeout             = 30 * time.Second
+	DefaultDialTimeout         = 30 * time.Second
+	DefaultTLSHandshakeTimeout = 10 * time.Second
+)
+
+// TransportConfig holds the settings of the HTTP client a Connector creates for itself. Every Connector has its
+// own, so that connectors with different settings can be used in one process.
+type TransportConfig struct {
+	// Trust holds the CAs trusted to issue the server certificate. The system pool is used if it is nil.
+	Trust *x509.CertPool
+	// InsecureSkipVerify disables the verification of the server certificate, for testing only
+	InsecureSkipVerify bool
+	// ClientCertificate is presented to servers that require client certificate authentication
+	ClientCertificate *tls.Certificate
+	// Renegotiation lets the server renegotiate the connection, which TPP does to request the client certificate
+	Renegotiation bool
+
+	// Timeout limits the time of a request, from connecting to reading the response body. DialTimeout limits the time
+	// to connect to the server and TLSHandshakeTimeout the time of the TLS handshake. The defaults are used for zero
+	// values.
+	Timeout             time.Duration
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+	// Proxy is the URL of the HTTP proxy, with the user and password if it requires authentication. The proxy of the
+	// HTTPS_PROXY and NO_PROXY environment variables is used if it is nil.
+	Proxy *url.URL
+	// NoProxy lists the servers connected to without the proxy: host names, domains whose subdomains also match,
+	// IP addresses, CIDR ranges, or "*" for all servers
+	NoProxy []string
+
+	// Trace, if set, receives the method, URL, status and duration of every request, with the headers and bodies
+	// of the request and the response. Secrets are redacted, see RedactBody.
+	Trace io.Writer
+}
+
+// NewHTTPClient returns an HTTP client with the settings of t
+func NewHTTPClient(t TransportConfig) *http.Client {
+	timeout, dialTimeout, handshakeTimeout := DefaultTimeout, DefaultDialTimeout, DefaultTLSHandshakeTimeout
+	if t.Timeout > 0 {
+		timeout = t.Timeout
+	}
+	if t.DialTimeout > 0 {
+		dialTimeout = t.DialTimeout
+	}
+	if t.TLSHandshakeTimeout > 0 {
+		handshakeTimeout = t.TLSHandshakeTimeout
+	}
+	var netTransport = &http.Transport{
+		Proxy: t.proxy(),
+		DialContext: (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: 30 * time.Second,
+			DualStack: true,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   handshakeTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	// the default TLS configuration is left alone, a custom one disables HTTP/2
+	if t.Trust != nil || t.InsecureSkipVerify || t.ClientCertificate != nil || t.Renegotiation {
+		/* #nosec */
+		tlsConfig := &tls.Config{
+			RootCAs:            t.Trust,
+			InsecureSkipVerify: t.InsecureSkipVerify,
+		}
+		if t.ClientCertificate != nil {
+			tlsConfig.Certificates = []tls.Certificate{*t.ClientCertificate}
+		}
+		if t.Renegotiation {
+			tlsConfig.Renegotiation = tls.RenegotiateFreelyAsClient
+		}
+		netTransport.TLSClientConfig = tlsConfig
+	}
+	var roundTripper http.RoundTripper = netTransport
+	if t.Trace != nil {
+		roundTripper = &traceTransport{next: netTransport, w: t.Trace}
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: roundTripper,
+	}
+}
+
+// proxy returns the function choosing the proxy of each request
+func (t TransportConfig) proxy() func(*http.Request) (*url.URL, error) {
+	proxy := http.ProxyFromEnvironment
+	if t.Proxy != nil {
+		proxy = http.ProxyURL(t.Proxy)
+	}
+	if len(t.NoProxy) == 0 {
+		return proxy
+	}
+	noProxy := t.NoProxy
+	return func(req *http.Request) (*url.URL, error) {
+		if matchNoProxy(noProxy, req.URL.Hostname()) {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+}
+
+// matchNoProxy reports whether host matches one of the entries of a NoProxy list
+func matchNoProxy(noProxy []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		case strings.Contains(entry, "/"):
+			_, network, err := net.ParseCIDR(entry)
+			if err == nil && ip != nil && network.Contains(ip) {
+				return true
+			}
+		case net.ParseIP(entry) != nil:
+			if ip != nil && ip.Equal(net.ParseIP(entry)) {
+				return true
+			}
+		default:
+			domain := strings.TrimPrefix(strings.TrimSuffix(entry, "."), ".")
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
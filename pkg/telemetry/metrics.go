@@ -0,0 +1,176 @@
+/*
+ * Copyright 2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package telemetry provides endpoint.Hooks exporting the metrics and traces of connector operations, for use with
+// vcert.Config
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the duration histograms of Metrics
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// Metrics are endpoint.Hooks counting the operations and HTTP requests of connectors and their durations. Its
+// ServeHTTP method exposes them in the Prometheus text format, so that a Prometheus server can scrape them:
+//
+//	metrics := telemetry.NewMetrics()
+//	cfg.Hooks = metrics
+//	http.Handle("/metrics", metrics)
+//
+// The metrics are vcert_operations_total and vcert_operation_duration_seconds labeled by connector, zone, operation
+// and error category ("none" on success), and vcert_http_requests_total and vcert_http_request_duration_seconds
+// labeled by connector, method and status code.
+type Metrics struct {
+	buckets []float64
+
+	lock       sync.Mutex
+	operations map[string]*histogram
+	requests   map[string]*histogram
+}
+
+// histogram holds the observations of one label set
+type histogram struct {
+	labels string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewMetrics returns Metrics with the DefaultBuckets
+func NewMetrics() *Metrics {
+	return NewMetricsWithBuckets(DefaultBuckets)
+}
+
+// NewMetricsWithBuckets returns Metrics with the given upper bounds of the duration histograms, in seconds
+func NewMetricsWithBuckets(buckets []float64) *Metrics {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &Metrics{buckets: b, operations: map[string]*histogram{}, requests: map[string]*histogram{}}
+}
+
+func (m *Metrics) StartOperation(op endpoint.OperationInfo) func(endpoint.Outcome) {
+	return func(o endpoint.Outcome) {
+		category := o.ErrorCategory()
+		if category == "" {
+			category = "none"
+		}
+		labels := formatLabels("connector", connectorLabel(op.ConnectorType), "zone", op.Zone, "operation", string(op.Operation), "error_category", category)
+		m.observe(m.operations, labels, o.Duration)
+	}
+}
+
+func (m *Metrics) StartHTTPRequest(req endpoint.HTTPRequestInfo) func(endpoint.Outcome) {
+	return func(o endpoint.Outcome) {
+		code := "none"
+		if o.StatusCode != 0 {
+			code = strconv.Itoa(o.StatusCode)
+		}
+		labels := formatLabels("connector", connectorLabel(req.ConnectorType), "method", req.Method, "code", code)
+		m.observe(m.requests, labels, o.Duration)
+	}
+}
+
+func (m *Metrics) observe(series map[string]*histogram, labels string, d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h, ok := series[labels]
+	if !ok {
+		h = &histogram{labels: labels, counts: make([]uint64, len(m.buckets))}
+		series[labels] = h
+	}
+	seconds := d.Seconds()
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format to w
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	m.lock.Lock()
+	m.write(&b, "vcert_operations_total", "Connector operations.", "vcert_operation_duration_seconds", "Duration of connector operations in seconds.", m.operations)
+	m.write(&b, "vcert_http_requests_total", "HTTP requests of connectors.", "vcert_http_request_duration_seconds", "Duration of HTTP requests of connectors in seconds.", m.requests)
+	m.lock.Unlock()
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (m *Metrics) write(b *strings.Builder, total, totalHelp, duration, durationHelp string, series map[string]*histogram) {
+	sorted := make([]*histogram, 0, len(series))
+	for _, h := range series {
+		sorted = append(sorted, h)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].labels < sorted[j].labels })
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", total, totalHelp, total)
+	for _, h := range sorted {
+		fmt.Fprintf(b, "%s{%s} %d\n", total, h.labels, h.count)
+	}
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", duration, durationHelp, duration)
+	for _, h := range sorted {
+		for i, bound := range m.buckets {
+			fmt.Fprintf(b, "%s_bucket{%s,le=\"%s\"} %d\n", duration, h.labels, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", duration, h.labels, h.count)
+		fmt.Fprintf(b, "%s_sum{%s} %s\n", duration, h.labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(b, "%s_count{%s} %d\n", duration, h.labels, h.count)
+	}
+}
+
+// formatLabels formats alternating names and values as a Prometheus label set without the braces
+func formatLabels(namesAndValues ...string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	labels := make([]string, 0, len(namesAndValues)/2)
+	for i := 0; i+1 < len(namesAndValues); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, namesAndValues[i], escape.Replace(namesAndValues[i+1])))
+	}
+	return strings.Join(labels, ",")
+}
+
+// connectorLabel returns a short name of t for metric labels and span attributes
+func connectorLabel(t endpoint.ConnectorType) string {
+	switch t {
+	case endpoint.ConnectorTypeTPP:
+		return "tpp"
+	case endpoint.ConnectorTypeCloud:
+		return "cloud"
+	case endpoint.ConnectorTypeFake:
+		return "fake"
+	default:
+		return "undefined"
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/Venafi/vcert/v4/pkg/venafi/tpp"
+)
+
+// newTPPConnector returns a TPP connector to a test server that is unavailable for the first request
+func newTPPConnector(t *testing.T, hooks endpoint.Hooks) (endpoint.Connector, *httptest.Server) {
+	var requests int
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	c, err := tpp.NewConnector(server.URL, "", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetHTTPClient(server.Client())
+	c.SetHooks(hooks)
+	connector := endpoint.WithHooks(c, hooks)
+	connector.SetZone(`Certificates\vcert`)
+	return connector, server
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetricsWithBuckets([]float64{60, 1})
+	c, server := newTPPConnector(t, metrics)
+	defer server.Close()
+	if c.Ping() == nil {
+		t.Fatal("expected the first ping to fail")
+	}
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	metrics.StartOperation(endpoint.OperationInfo{Operation: endpoint.OperationRetrieve, ConnectorType: endpoint.ConnectorTypeCloud, Zone: "app\\template"})(endpoint.Outcome{Duration: 5 * time.Second})
+
+	res := httptest.NewRecorder()
+	metrics.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(res.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("expected the Prometheus text format, got %s", res.Header().Get("Content-Type"))
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	for _, expected := range []string{
+		"# TYPE vcert_operations_total counter\n",
+		`vcert_operations_total{connector="tpp",zone="Certificates\\vcert",operation="ping",error_category="general"} 1` + "\n",
+		`vcert_operations_total{connector="tpp",zone="Certificates\\vcert",operation="ping",error_category="none"} 1` + "\n",
+		"# TYPE vcert_operation_duration_seconds histogram\n",
+		`vcert_operation_duration_seconds_bucket{connector="cloud",zone="app\\template",operation="retrieve",error_category="none",le="1"} 0` + "\n",
+		`vcert_operation_duration_seconds_bucket{connector="cloud",zone="app\\template",operation="retrieve",error_category="none",le="60"} 1` + "\n",
+		`vcert_operation_duration_seconds_sum{connector="cloud",zone="app\\template",operation="retrieve",error_category="none"} 5` + "\n",
+		`vcert_http_requests_total{connector="tpp",method="GET",code="503"} 1` + "\n",
+		`vcert_http_requests_total{connector="tpp",method="GET",code="200"} 1` + "\n",
+		`vcert_http_request_duration_seconds_count{connector="tpp",method="GET",code="200"} 1` + "\n",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Fatalf("expected the metrics to contain %s\n%s", expected, body)
+		}
+	}
+}
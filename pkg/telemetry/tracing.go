@@ -0,0 +1,81 @@
+/*
+ * Copyright 2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+)
+
+// Span is the part of a tracing span that Tracing uses. The spans of OpenTelemetry and OpenTracing fit with an
+// adapter of a few lines.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts the spans of Tracing, e.g. by calling the Start method of an OpenTelemetry tracer with the context of
+// the application
+type Tracer interface {
+	Start(name string) Span
+}
+
+// Tracing are endpoint.Hooks recording a span for every operation and HTTP request of connectors. Operation spans are
+// named "vcert.<operation>", e.g. "vcert.request", and HTTP spans "HTTP <method>". The connectors do not pass a
+// context, so HTTP spans are siblings of the span of their operation rather than children.
+type Tracing struct {
+	tracer Tracer
+}
+
+// NewTracing returns Tracing recording spans with tracer
+func NewTracing(tracer Tracer) *Tracing {
+	return &Tracing{tracer: tracer}
+}
+
+func (t *Tracing) StartOperation(op endpoint.OperationInfo) func(endpoint.Outcome) {
+	span := t.tracer.Start("vcert." + string(op.Operation))
+	span.SetAttribute("vcert.connector", connectorLabel(op.ConnectorType))
+	span.SetAttribute("vcert.zone", op.Zone)
+	span.SetAttribute("vcert.operation", string(op.Operation))
+	return func(o endpoint.Outcome) {
+		endSpan(span, o)
+	}
+}
+
+func (t *Tracing) StartHTTPRequest(req endpoint.HTTPRequestInfo) func(endpoint.Outcome) {
+	span := t.tracer.Start("HTTP " + req.Method)
+	span.SetAttribute("vcert.connector", connectorLabel(req.ConnectorType))
+	span.SetAttribute("vcert.zone", req.Zone)
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", req.URL)
+	return func(o endpoint.Outcome) {
+		if o.StatusCode != 0 {
+			span.SetAttribute("http.status_code", o.StatusCode)
+		}
+		endSpan(span, o)
+	}
+}
+
+func endSpan(span Span, o endpoint.Outcome) {
+	if category := o.ErrorCategory(); category != "" {
+		span.SetAttribute("vcert.error_category", category)
+	}
+	if o.Err != nil {
+		span.RecordError(o.Err)
+	}
+	span.End()
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry
+
+import (
+	"fmt"
+	"testing"
+)
+
+// recordingSpan records its attributes and errors
+type recordingSpan struct {
+	name       string
+	attributes map[string]interface{}
+	errors     []error
+	ended      bool
+}
+
+func (s *recordingSpan) SetAttribute(key string, value interface{}) { s.attributes[key] = value }
+func (s *recordingSpan) RecordError(err error)                      { s.errors = append(s.errors, err) }
+func (s *recordingSpan) End()                                       { s.ended = true }
+
+type recordingTracer struct {
+	spans []*recordingSpan
+}
+
+func (t *recordingTracer) Start(name string) Span {
+	span := &recordingSpan{name: name, attributes: map[string]interface{}{}}
+	t.spans = append(t.spans, span)
+	return span
+}
+
+func TestTracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	c, server := newTPPConnector(t, NewTracing(tracer))
+	defer server.Close()
+	if c.Ping() == nil {
+		t.Fatal("expected the first ping to fail")
+	}
+
+	// spans are recorded in the order they start
+	if len(tracer.spans) != 2 {
+		t.Fatalf("expected an operation span and an HTTP span, got %d", len(tracer.spans))
+	}
+	op, req := tracer.spans[0], tracer.spans[1]
+	if op.name != "vcert.ping" || !op.ended || len(op.errors) != 1 {
+		t.Fatalf("expected the ended span of the failed ping, got %+v", op)
+	}
+	expected := map[string]interface{}{"vcert.connector": "tpp", "vcert.zone": `Certificates\vcert`, "vcert.operation": "ping", "vcert.error_category": "general"}
+	if fmt.Sprint(op.attributes) != fmt.Sprint(expected) {
+		t.Fatalf("expected the attributes %v, got %v", expected, op.attributes)
+	}
+	if req.name != "HTTP GET" || !req.ended || len(req.errors) != 0 {
+		t.Fatalf("expected the ended span of the request, got %+v", req)
+	}
+	expected = map[string]interface{}{"vcert.connector": "tpp", "vcert.zone": `Certificates\vcert`, "http.method": "GET", "http.url": server.URL + "/vedsdk/", "http.status_code": 503, "vcert.error_category": "server_unavailable"}
+	if fmt.Sprint(req.attributes) != fmt.Sprint(expected) {
+		t.Fatalf("expected the attributes %v, got %v", expected, req.attributes)
+	}
+}
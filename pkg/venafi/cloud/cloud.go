@@ -19,13 +19,10 @@ package cloud
 import (
 	"bytes"
 	"crypto/sha1"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
-	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -71,7 +68,7 @@ type certificateRequestResponseData struct {
 
 type certificateRequestClientInfo struct {
 	Type       string `json:"type"`
-	Identifier string `json:"identifier"`
+	Identifier string `json:"identifier,omitempty"`
 }
 
 type certificateRequest struct {
@@ -108,7 +105,7 @@ type CertificateStatusErrorInformation struct {
 
 type apiClientInformation struct {
 	Type       string `json:"type"`
-	Identifier string `json:"identifier"`
+	Identifier string `json:"identifier,omitempty"`
 }
 
 type certificateUsageMetadata struct {
@@ -191,44 +188,44 @@ func (c *Connector) getHTTPClient() *http.Client {
 	if c.client != nil {
 		return c.client
 	}
-	var netTransport = &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-	}
-	tlsConfig := http.DefaultTransport.(*http.Transport).TLSClientConfig
-	/* #nosec */
-	if c.trust != nil {
-		if tlsConfig == nil {
-			tlsConfig = &tls.Config{}
-		} else {
-			tlsConfig = tlsConfig.Clone()
-		}
-		tlsConfig.RootCAs = c.trust
-	}
-	netTransport.TLSClientConfig = tlsConfig
-	c.client = &http.Client{
-		Timeout:   time.Second * 30,
-		Transport: netTransport,
-	}
+	c.client = endpoint.NewHTTPClient(c.transport)
 	return c.client
 }
 
 func (c *Connector) request(method string, url string, data interface{}, authNotRequired ...bool) (statusCode int, statusText string, body []byte, err error) {
+	statusCode, statusText, body, _, err = c.requestWithCorrelationID(method, url, data, authNotRequired...)
+	return
+}
+
+// requestWithCorrelationID is request that also returns the correlation ID of the response, for the errors built from it.
+// Requests that need authentication are retried once with the API key of the CredentialProvider when the API key
+// is rejected with 401 Unauthorized.
+func (c *Connector) requestWithCorrelationID(method string, url string, data interface{}, authNotRequired ...bool) (statusCode int, statusText string, body []byte, correlationID string, err error) {
+	if len(authNotRequired) == 1 && authNotRequired[0] {
+		return c.doRequest(method, url, data, c.getAPIKey())
+	}
 	if c.user == nil || c.user.Company == nil {
-		if !(len(authNotRequired) == 1 && authNotRequired[0]) {
-			err = fmt.Errorf("%w: must be autheticated to retieve certificate", verror.VcertError)
-			return
-		}
+		err = fmt.Errorf("%w: must be autheticated to retieve certificate", verror.VcertError)
+		return
 	}
 
+	apiKey := c.getAPIKey()
+	statusCode, statusText, body, correlationID, err = c.doRequest(method, url, data, apiKey)
+	if err != nil || statusCode != http.StatusUnauthorized {
+		return
+	}
+	renewed, renewErr := c.renewAPIKey(apiKey)
+	if renewErr != nil {
+		err = renewErr
+		return
+	}
+	if !renewed {
+		return
+	}
+	return c.doRequest(method, url, data, c.getAPIKey())
+}
+
+func (c *Connector) doRequest(method string, url string, data interface{}, apiKey string) (statusCode int, statusText string, body []byte, correlationID string, err error) {
 	var payload io.Reader
 	var b []byte
 	if method == "POST" {
@@ -241,8 +238,8 @@ func (c *Connector) request(method string, url string, data interface{}, authNot
 		err = fmt.Errorf("%w: %v", verror.VcertError, err)
 		return
 	}
-	if c.apiKey != "" {
-		r.Header.Add("tppl-api-key", c.apiKey)
+	if apiKey != "" {
+		r.Header.Add("tppl-api-key", apiKey)
 	}
 	if method == "POST" {
 		r.Header.Add("Accept", "application/json")
@@ -254,49 +251,35 @@ func (c *Connector) request(method string, url string, data interface{}, authNot
 
 	var httpClient = c.getHTTPClient()
 
+	start := time.Now()
+	if c.hooks != nil {
+		end := c.hooks.StartHTTPRequest(endpoint.HTTPRequestInfo{ConnectorType: endpoint.ConnectorTypeCloud, Zone: c.zone.String(), Method: method, URL: url})
+		defer func() { end(endpoint.Outcome{Duration: time.Since(start), StatusCode: statusCode, Err: err}) }()
+	}
 	res, err := httpClient.Do(r)
 	if err != nil {
+		c.log().Debug("Request failed", "method", method, "url", url, "duration", time.Since(start), "error", err)
 		err = fmt.Errorf("%w: %v", verror.ServerUnavailableError, err)
 		return
 	}
 	statusCode = res.StatusCode
 	statusText = res.Status
+	correlationID = res.Header.Get(verror.RequestIDHeader)
 
 	defer res.Body.Close()
 	body, err = ioutil.ReadAll(res.Body)
 	if err != nil {
 		err = fmt.Errorf("%w: %v", verror.ServerError, err)
 	}
-	// Do not enable trace in production
-	trace := false // IMPORTANT: sensitive information can be diclosured
-	// I hope you know what are you doing
-	if trace {
-		log.Println("#################")
-		if method == "POST" {
-			log.Printf("JSON sent for %s\n%s\n", url, string(b))
-		} else {
-			log.Printf("%s request sent to %s\n", method, url)
-		}
-		log.Printf("Response:\n%s\n", string(body))
-	} else if c.verbose {
-		log.Printf("Got %s status for %s %s\n", statusText, method, url)
-	}
+	c.log().Debug("Request completed", "method", method, "url", url, "status", statusText, "duration", time.Since(start))
 	return
 }
 
-func parseUserDetailsResult(expectedStatusCode int, httpStatusCode int, httpStatus string, body []byte) (*userDetails, error) {
+func parseUserDetailsResult(expectedStatusCode int, httpStatusCode int, correlationID string, body []byte) (*userDetails, error) {
 	if httpStatusCode == expectedStatusCode {
 		return parseUserDetailsData(body)
 	}
-	respErrors, err := parseResponseErrors(body)
-	if err != nil {
-		return nil, err // parseResponseErrors always return verror.ServerError
-	}
-	respError := fmt.Sprintf("unexpected status code on Venafi Cloud registration. Status: %s\n", httpStatus)
-	for _, e := range respErrors {
-		respError += fmt.Sprintf("Error Code: %d Error: %s\n", e.Code, e.Message)
-	}
-	return nil, fmt.Errorf("%w: %v", verror.ServerError, respError)
+	return nil, newResponseError("Venafi Cloud registration", httpStatusCode, correlationID, body)
 }
 
 func parseUserDetailsData(b []byte) (*userDetails, error) {
@@ -309,26 +292,16 @@ func parseUserDetailsData(b []byte) (*userDetails, error) {
 	return &data, nil
 }
 
-func parseZoneConfigurationResult(httpStatusCode int, httpStatus string, body []byte) (*zone, error) {
+func parseZoneConfigurationResult(httpStatusCode int, correlationID string, body []byte) (*zone, error) {
 	switch httpStatusCode {
 	case http.StatusOK:
 		return parseZoneConfigurationData(body)
-	case http.StatusBadRequest, http.StatusNotFound:
-		return nil, verror.ZoneNotFoundError
 	default:
-		respErrors, err := parseResponseErrors(body)
-		if err != nil {
-			return nil, err
+		respErr := newResponseError("Venafi Cloud zone read", httpStatusCode, correlationID, body)
+		if httpStatusCode == http.StatusBadRequest || httpStatusCode == http.StatusNotFound || hasServerCode(respErr, errorCodeNotFound) {
+			respErr.Kind = verror.ZoneNotFoundError
 		}
-
-		respError := fmt.Sprintf("Unexpected status code on Venafi Cloud zone read. Status: %s\n", httpStatus)
-		for _, e := range respErrors {
-			if e.Code == 10051 {
-				return nil, verror.ZoneNotFoundError
-			}
-			respError += fmt.Sprintf("Error Code: %d Error: %s\n", e.Code, e.Message)
-		}
-		return nil, fmt.Errorf("%w: %v", verror.ServerError, respError)
+		return nil, respErr
 	}
 }
 
@@ -341,26 +314,16 @@ func parseZoneConfigurationData(b []byte) (*zone, error) {
 	return &data, nil
 }
 
-func parseCertificateTemplateResult(httpStatusCode int, httpStatus string, body []byte) (*certificateTemplate, error) {
+func parseCertificateTemplateResult(httpStatusCode int, correlationID string, body []byte) (*certificateTemplate, error) {
 	switch httpStatusCode {
 	case http.StatusOK:
 		return parseCertificateTemplateData(body)
-	case http.StatusBadRequest:
-		return nil, verror.ZoneNotFoundError
 	default:
-		respErrors, err := parseResponseErrors(body)
-		if err != nil {
-			return nil, err
-		}
-
-		respError := fmt.Sprintf("Unexpected status code on Venafi Cloud zone read. Status: %s\n", httpStatus)
-		for _, e := range respErrors {
-			if e.Code == 10051 {
-				return nil, verror.ZoneNotFoundError
-			}
-			respError += fmt.Sprintf("Error Code: %d Error: %s\n", e.Code, e.Message)
+		respErr := newResponseError("Venafi Cloud issuing template read", httpStatusCode, correlationID, body)
+		if httpStatusCode == http.StatusBadRequest || hasServerCode(respErr, errorCodeNotFound) {
+			respErr.Kind = verror.ZoneNotFoundError
 		}
-		return nil, fmt.Errorf("%w: %v", verror.ServerError, respError)
+		return nil, respErr
 	}
 }
 
@@ -373,21 +336,12 @@ func parseCertificateTemplateData(body []byte) (*certificateTemplate, error) {
 	return &ct, nil
 }
 
-func parseCertificateRequestResult(httpStatusCode int, httpStatus string, body []byte) (*certificateRequestResponse, error) {
+func parseCertificateRequestResult(httpStatusCode int, correlationID string, body []byte) (*certificateRequestResponse, error) {
 	switch httpStatusCode {
 	case http.StatusCreated:
 		return parseCertificateRequestData(body)
 	default:
-		respErrors, err := parseResponseErrors(body)
-		if err != nil {
-			return nil, err
-		}
-
-		respError := fmt.Sprintf("Unexpected status code on Venafi Cloud zone read. Status: %s\n", httpStatus)
-		for _, e := range respErrors {
-			respError += fmt.Sprintf("Error Code: %d Error: %s\n", e.Code, e.Message)
-		}
-		return nil, fmt.Errorf("%w: %v", verror.ServerError, respError)
+		return nil, newResponseError("Venafi Cloud certificate request", httpStatusCode, correlationID, body)
 	}
 }
 
@@ -410,26 +364,16 @@ func certThumbprint(asn1 []byte) string {
 	return strings.ToUpper(fmt.Sprintf("%x", h))
 }
 
-func parseApplicationDetailsResult(httpStatusCode int, httpStatus string, body []byte) (*ApplicationDetails, error) {
+func parseApplicationDetailsResult(httpStatusCode int, correlationID string, body []byte) (*ApplicationDetails, error) {
 	switch httpStatusCode {
 	case http.StatusOK:
 		return parseApplicationDetailsData(body)
-	case http.StatusBadRequest:
-		return nil, verror.ApplicationNotFoundError
 	default:
-		respErrors, err := parseResponseErrors(body)
-		if err != nil {
-			return nil, err
-		}
-
-		respError := fmt.Sprintf("Unexpected status code on Venafi Cloud application read. Status: %s\n", httpStatus)
-		for _, e := range respErrors {
-			if e.Code == 10051 {
-				return nil, verror.ApplicationNotFoundError
-			}
-			respError += fmt.Sprintf("Error Code: %d Error: %s\n", e.Code, e.Message)
+		respErr := newResponseError("Venafi Cloud application read", httpStatusCode, correlationID, body)
+		if httpStatusCode == http.StatusBadRequest || hasServerCode(respErr, errorCodeNotFound) {
+			respErr.Kind = verror.ApplicationNotFoundError
 		}
-		return nil, fmt.Errorf("%w: %v", verror.ServerError, respError)
+		return nil, respErr
 	}
 }
 
@@ -48,7 +48,7 @@ func TestParseGetUserAccountData(t *testing.T) {
 }
 
 func TestParseBadAPIKeyError(t *testing.T) {
-	_, err := parseUserDetailsResult(http.StatusOK, http.StatusPreconditionFailed, "Auth Error", errorGetUserAccount)
+	_, err := parseUserDetailsResult(http.StatusOK, http.StatusPreconditionFailed, "", errorGetUserAccount)
 	if err == nil {
 		t.Fatalf("err nil, expected error back")
 	}
@@ -60,7 +60,7 @@ func TestParseZoneResponse(t *testing.T) {
 		t.Fatalf("err is not nil, err: %s", err)
 	}
 
-	_, err = parseZoneConfigurationResult(http.StatusNotFound, "Not Found", errorGetZoneByTag)
+	_, err = parseZoneConfigurationResult(http.StatusNotFound, "", errorGetZoneByTag)
 	if err == nil {
 		t.Fatalf("err nil, expected error back")
 	}
@@ -122,12 +122,12 @@ func TestParseCertificateRequestResponse(t *testing.T) {
 		t.Fatalf("err is not nil, err: %s", err)
 	}
 
-	_, err = parseCertificateRequestResult(http.StatusBadRequest, "Bad Data", errorRequestCertificate)
+	_, err = parseCertificateRequestResult(http.StatusBadRequest, "", errorRequestCertificate)
 	if err == nil {
 		t.Fatalf("err nil, expected error back")
 	}
 
-	_, err = parseCertificateRequestResult(http.StatusGone, "Something unexpected", errorRequestCertificate)
+	_, err = parseCertificateRequestResult(http.StatusGone, "", errorRequestCertificate)
 	if err == nil {
 		t.Fatalf("err nil, expected error back")
 	}
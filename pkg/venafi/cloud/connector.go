@@ -27,6 +27,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Venafi/vcert/v4/pkg/verror"
@@ -63,19 +64,31 @@ const (
 
 // Connector contains the base data needed to communicate with the Venafi Cloud servers
 type Connector struct {
-	baseURL string
-	apiKey  string
-	verbose bool
-	user    *userDetails
-	trust   *x509.CertPool
-	zone    cloudZone
-	client  *http.Client
+	baseURL   string
+	logger    endpoint.Logger
+	hooks     endpoint.Hooks
+	user      *userDetails
+	transport endpoint.TransportConfig
+	zone      cloudZone
+	client    *http.Client
+
+	// apiKeyLock guards apiKey and credentials, renewLock makes concurrent requests renew the API key once
+	apiKeyLock  sync.RWMutex
+	renewLock   sync.Mutex
+	apiKey      string
+	credentials endpoint.CredentialProvider
+
+	// identityOnce resolves identity into identifier when the first request needs it
+	identity     endpoint.ClientIdentity
+	identityOnce sync.Once
+	identifier   string
 }
 
-// NewConnector creates a new Venafi Cloud Connector object used to communicate with Venafi Cloud
+// NewConnector creates a new Venafi Cloud Connector object used to communicate with Venafi Cloud. It logs to the
+// standard logger, debug messages only if verbose is true, until SetLogger is called.
 func NewConnector(url string, zone string, verbose bool, trust *x509.CertPool) (*Connector, error) {
 	cZone := cloudZone{zone: zone}
-	c := Connector{verbose: verbose, trust: trust, zone: cZone}
+	c := Connector{logger: endpoint.NewStdLogger(nil, verbose), transport: endpoint.TransportConfig{Trust: trust}, zone: cZone}
 
 	var err error
 	c.baseURL, err = normalizeURL(url)
@@ -125,13 +138,13 @@ func (c *Connector) Authenticate(auth *endpoint.Authentication) (err error) {
 	if auth == nil {
 		return fmt.Errorf("failed to authenticate: missing credentials")
 	}
-	c.apiKey = auth.APIKey
+	c.setAPIKey(auth.APIKey)
 	url := c.getURL(urlResourceUserAccounts)
-	statusCode, status, body, err := c.request("GET", url, nil, true)
+	statusCode, _, body, correlationID, err := c.requestWithCorrelationID("GET", url, nil, true)
 	if err != nil {
 		return err
 	}
-	ud, err := parseUserDetailsResult(http.StatusOK, statusCode, status, body)
+	ud, err := parseUserDetailsResult(http.StatusOK, statusCode, correlationID, body)
 	if err != nil {
 		return
 	}
@@ -139,6 +152,53 @@ func (c *Connector) Authenticate(auth *endpoint.Authentication) (err error) {
 	return
 }
 
+// SetCredentialProvider sets the provider the Connector gets a new API key from when a request gets
+// 401 Unauthorized
+func (c *Connector) SetCredentialProvider(p endpoint.CredentialProvider) {
+	c.apiKeyLock.Lock()
+	defer c.apiKeyLock.Unlock()
+	c.credentials = p
+}
+
+func (c *Connector) getAPIKey() string {
+	c.apiKeyLock.RLock()
+	defer c.apiKeyLock.RUnlock()
+	return c.apiKey
+}
+
+func (c *Connector) setAPIKey(apiKey string) {
+	c.apiKeyLock.Lock()
+	defer c.apiKeyLock.Unlock()
+	c.apiKey = apiKey
+}
+
+// renewAPIKey replaces the rejected staleKey with the API key of the CredentialProvider. It reports false if there
+// is no provider or it returns staleKey again, and does nothing if another request already replaced staleKey.
+func (c *Connector) renewAPIKey(staleKey string) (bool, error) {
+	c.renewLock.Lock()
+	defer c.renewLock.Unlock()
+
+	c.apiKeyLock.RLock()
+	current, provider := c.apiKey, c.credentials
+	c.apiKeyLock.RUnlock()
+	if current != staleKey {
+		return true, nil
+	}
+	if provider == nil {
+		return false, nil
+	}
+	auth, err := provider.Credentials()
+	if err != nil {
+		return false, fmt.Errorf("%w: failed to renew credentials: %s", verror.AuthError, err)
+	}
+	if auth.APIKey == "" || auth.APIKey == staleKey {
+		return false, nil
+	}
+	c.setAPIKey(auth.APIKey)
+	c.log().Debug("Renewed Venafi Cloud API key")
+	return true, nil
+}
+
 func (c *Connector) ReadPolicyConfiguration() (policy *endpoint.Policy, err error) {
 	config, err := c.ReadZoneConfiguration()
 	if err != nil {
@@ -169,7 +229,6 @@ func (c *Connector) RequestCertificate(req *certificate.Request) (requestID stri
 		return "", fmt.Errorf("must be autheticated to request a certificate")
 	}
 
-	ipAddr := endpoint.LocalIP
 	origin := endpoint.SDKName
 	for _, f := range req.CustomFields {
 		if f.Type == certificate.CustomFieldOrigin {
@@ -189,7 +248,7 @@ func (c *Connector) RequestCertificate(req *certificate.Request) (requestID stri
 		TemplateId:    templateId,
 		ApiClientInformation: certificateRequestClientInfo{
 			Type:       origin,
-			Identifier: ipAddr,
+			Identifier: c.clientIdentifier(),
 		},
 	}
 
@@ -215,24 +274,25 @@ func (c *Connector) RequestCertificate(req *certificate.Request) (requestID stri
 		cloudReq.ValidityPeriod = validityHoursStr
 	}
 
-	statusCode, status, body, err := c.request("POST", url, cloudReq)
+	statusCode, _, body, correlationID, err := c.requestWithCorrelationID("POST", url, cloudReq)
 
 	if err != nil {
 		return "", err
 	}
-	cr, err := parseCertificateRequestResult(statusCode, status, body)
+	cr, err := parseCertificateRequestResult(statusCode, correlationID, body)
 	if err != nil {
 		return "", err
 	}
 	requestID = cr.CertificateRequests[0].ID
 	req.PickupID = requestID
+	c.log().Debug("Requested certificate", "zone", c.zone.String(), "pickupId", requestID)
 	return requestID, nil
 }
 
 func (c *Connector) getCertificateStatus(requestID string) (certStatus *certificateStatus, err error) {
 	url := c.getURL(urlResourceCertificateStatus)
 	url = fmt.Sprintf(url, requestID)
-	statusCode, _, body, err := c.request("GET", url, nil)
+	statusCode, _, body, correlationID, err := c.requestWithCorrelationID("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -244,17 +304,7 @@ func (c *Connector) getCertificateStatus(requestID string) (certStatus *certific
 		}
 		return
 	}
-	respErrors, err := parseResponseErrors(body)
-	if err == nil {
-		respError := fmt.Sprintf("Unexpected status code on Venafi Cloud certificate search. Status: %d\n", statusCode)
-		for _, e := range respErrors {
-			respError += fmt.Sprintf("Error Code: %d Error: %s\n", e.Code, e.Message)
-		}
-		return nil, fmt.Errorf(respError)
-	}
-
-	return nil, fmt.Errorf("unexpected status code on Venafi Cloud certificate search. Status: %d", statusCode)
-
+	return nil, newResponseError("Venafi Cloud certificate status", statusCode, correlationID, body)
 }
 
 // RetrieveCertificate retrieves the certificate for the specified ID
@@ -306,20 +356,20 @@ func (c *Connector) RetrieveCertificate(req *certificate.Request) (certificates
 			}
 			certStatus, err := c.getCertificateStatus(req.PickupID)
 			if err != nil {
-				return nil, fmt.Errorf("unable to retrieve: %s", err)
+				return nil, fmt.Errorf("unable to retrieve: %w", err)
 			}
 			if certStatus.Status == "ISSUED" {
 				certificateId = certStatus.CertificateIdsList[0]
 				break // to fetch the cert itself
 			} else if certStatus.Status == "FAILED" {
-				return nil, fmt.Errorf("failed to retrieve certificate. Status: %v", certStatus)
+				return nil, fmt.Errorf("%w: failed to retrieve certificate. Status: %v", verror.CertificateRejectedError, certStatus)
 			}
 			// status.Status == "REQUESTED" || status.Status == "PENDING"
 			if req.Timeout == 0 {
-				return nil, endpoint.ErrCertificatePending{CertificateID: req.PickupID, Status: certStatus.Status}
+				return nil, endpoint.ErrCertificatePending{CertificateID: req.PickupID, Status: certStatus.Status, Elapsed: time.Since(startTime)}
 			}
 			if time.Now().After(startTime.Add(req.Timeout)) {
-				return nil, endpoint.ErrRetrieveCertificateTimeout{CertificateID: req.PickupID}
+				return nil, endpoint.ErrRetrieveCertificateTimeout{CertificateID: req.PickupID, Status: certStatus.Status, Elapsed: time.Since(startTime)}
 			}
 			// fmt.Printf("pending... %s\n", status.Status)
 			time.Sleep(2 * time.Second)
@@ -337,12 +387,12 @@ func (c *Connector) RetrieveCertificate(req *certificate.Request) (certificates
 
 	switch {
 	case req.CertID != "":
-		statusCode, status, body, err := c.request("GET", url, nil)
+		statusCode, _, body, correlationID, err := c.requestWithCorrelationID("GET", url, nil)
 		if err != nil {
 			return nil, err
 		}
 		if statusCode != http.StatusOK {
-			return nil, fmt.Errorf("failed to retrieve certificate. StatusCode: %d -- Status: %s -- Server Data: %s", statusCode, status, body)
+			return nil, newResponseError("Venafi Cloud certificate retrieval", statusCode, correlationID, body)
 		}
 		return newPEMCollectionFromResponse(body, certificate.ChainOptionIgnore)
 	case req.PickupID != "":
@@ -353,7 +403,7 @@ func (c *Connector) RetrieveCertificate(req *certificate.Request) (certificates
 		default:
 			url = fmt.Sprintf(url, condorChainOptionRootLast)
 		}
-		statusCode, status, body, err := c.request("GET", url, nil)
+		statusCode, _, body, correlationID, err := c.requestWithCorrelationID("GET", url, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -365,9 +415,9 @@ func (c *Connector) RetrieveCertificate(req *certificate.Request) (certificates
 			err = req.CheckCertificate(certificates.Certificate)
 			return certificates, err
 		} else if statusCode == http.StatusConflict { // Http Status Code 409 means the certificate has not been signed by the ca yet.
-			return nil, endpoint.ErrCertificatePending{CertificateID: req.PickupID}
+			return nil, endpoint.ErrCertificatePending{CertificateID: req.PickupID, Elapsed: time.Since(startTime)}
 		} else {
-			return nil, fmt.Errorf("failed to retrieve certificate. StatusCode: %d -- Status: %s", statusCode, status)
+			return nil, newResponseError("Venafi Cloud certificate retrieval", statusCode, correlationID, body)
 		}
 	}
 	return nil, fmt.Errorf("couldn't retrieve certificate because both PickupID and CertId are empty")
@@ -486,12 +536,12 @@ func (c *Connector) RenewCertificate(renewReq *certificate.RenewalRequest) (requ
 		req.ReuseCSR = true
 		return "", fmt.Errorf("reuseCSR option is not currently available for Renew Certificate operation. A new CSR must be provided in the request")
 	}
-	statusCode, status, body, err := c.request("POST", url, req)
+	statusCode, _, body, correlationID, err := c.requestWithCorrelationID("POST", url, req)
 	if err != nil {
 		return
 	}
 
-	cr, err := parseCertificateRequestResult(statusCode, status, body)
+	cr, err := parseCertificateRequestResult(statusCode, correlationID, body)
 	if err != nil {
 		return "", fmt.Errorf("failed to renew certificate: %s", err)
 	}
@@ -503,11 +553,11 @@ func (c *Connector) searchCertificates(req *SearchRequest) (*CertificateSearchRe
 	var err error
 
 	url := c.getURL(urlResourceCertificateSearch)
-	statusCode, _, body, err := c.request("POST", url, req)
+	statusCode, _, body, correlationID, err := c.requestWithCorrelationID("POST", url, req)
 	if err != nil {
 		return nil, err
 	}
-	searchResult, err := ParseCertificateSearchResponse(statusCode, body)
+	searchResult, err := parseCertificateSearchResult(statusCode, correlationID, body)
 	if err != nil {
 		return nil, err
 	}
@@ -553,7 +603,7 @@ func (c *Connector) getCertificate(certificateId string) (*managedCertificate, e
 	var err error
 	url := c.getURL(urlResourceCertificateByID)
 	url = fmt.Sprintf(url, certificateId)
-	statusCode, _, body, err := c.request("GET", url, nil)
+	statusCode, _, body, correlationID, err := c.requestWithCorrelationID("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -567,17 +617,7 @@ func (c *Connector) getCertificate(certificateId string) (*managedCertificate, e
 		}
 		return res, nil
 	default:
-		if body != nil {
-			respErrors, err := parseResponseErrors(body)
-			if err == nil {
-				respError := fmt.Sprintf("unexpected status code on Venafi Cloud certificate search. Status: %d\n", statusCode)
-				for _, e := range respErrors {
-					respError += fmt.Sprintf("Error Code: %d Error: %s\n", e.Code, e.Message)
-				}
-				return nil, fmt.Errorf(respError)
-			}
-		}
-		return nil, fmt.Errorf("unexpected status code on Venafi Cloud certificate search. Status: %d", statusCode)
+		return nil, newResponseError("Venafi Cloud certificate read", statusCode, correlationID, body)
 	}
 }
 
@@ -594,7 +634,6 @@ func (c *Connector) ImportCertificate(req *certificate.ImportRequest) (*certific
 		}
 		zone = appDetails.ApplicationId
 	}
-	ipAddr := endpoint.LocalIP
 	origin := endpoint.SDKName
 	for _, f := range req.CustomFields {
 		if f.Type == certificate.CustomFieldOrigin {
@@ -610,7 +649,7 @@ func (c *Connector) ImportCertificate(req *certificate.ImportRequest) (*certific
 				ApplicationIds: []string{zone},
 				ApiClientInformation: apiClientInformation{
 					Type:       origin,
-					Identifier: ipAddr,
+					Identifier: c.clientIdentifier(),
 				},
 			},
 		},
@@ -654,6 +693,52 @@ func (c *Connector) SetHTTPClient(client *http.Client) {
 	c.client = client
 }
 
+// SetTransportConfig sets the settings of the HTTP client the Connector creates. It replaces the trust pool given to
+// NewConnector and has no effect after SetHTTPClient.
+func (c *Connector) SetTransportConfig(t endpoint.TransportConfig) {
+	c.transport = t
+	c.client = nil
+}
+
+// SetLogger sets the logger of the Connector. A nil logger restores the standard logger.
+func (c *Connector) SetLogger(l endpoint.Logger) {
+	c.logger = l
+}
+
+// log returns the logger of the Connector, the standard logger without debug messages if it has none, e.g. because
+// the Connector was not created by NewConnector
+func (c *Connector) log() endpoint.Logger {
+	if c.logger == nil {
+		return endpoint.NewStdLogger(nil, false)
+	}
+	return c.logger
+}
+
+// SetHooks sets the hooks called around the HTTP requests of the Connector. Use endpoint.WithHooks to observe its
+// operations.
+func (c *Connector) SetHooks(h endpoint.Hooks) {
+	c.hooks = h
+}
+
+// SetClientIdentity sets how the Connector identifies the client in the certificates it requests and imports. It must
+// be called before the first request.
+func (c *Connector) SetClientIdentity(i endpoint.ClientIdentity) {
+	c.identity = i
+	c.identityOnce = sync.Once{}
+}
+
+// clientIdentifier returns the identifier of the client, resolving it on the first call
+func (c *Connector) clientIdentifier() string {
+	c.identityOnce.Do(func() {
+		var err error
+		c.identifier, err = c.identity.Resolve()
+		if err != nil {
+			c.log().Warn("Failed to resolve the client identifier, sending none", "error", err)
+		}
+	})
+	return c.identifier
+}
+
 func (c *Connector) ListCertificates(filter endpoint.Filter) ([]certificate.CertificateInfo, error) {
 	if c.zone.String() == "" {
 		return nil, fmt.Errorf("empty zone")
@@ -733,11 +818,11 @@ func (c *Connector) getAppDetailsByName(appName string) (*ApplicationDetails, er
 	}
 	encodedAppName := netUrl.PathEscape(appName)
 	url = fmt.Sprintf(url, encodedAppName)
-	statusCode, status, body, err := c.request("GET", url, nil)
+	statusCode, _, body, correlationID, err := c.requestWithCorrelationID("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	details, err := parseApplicationDetailsResult(statusCode, status, body)
+	details, err := parseApplicationDetailsResult(statusCode, correlationID, body)
 	if err != nil {
 		return nil, err
 	}
@@ -749,10 +834,10 @@ func (c *Connector) getTemplateByID() (*certificateTemplate, error) {
 	appNameEncoded := netUrl.PathEscape(c.zone.getApplicationName())
 	citAliasEncoded := netUrl.PathEscape(c.zone.getTemplateAlias())
 	url = fmt.Sprintf(url, appNameEncoded, citAliasEncoded)
-	statusCode, status, body, err := c.request("GET", url, nil)
+	statusCode, _, body, correlationID, err := c.requestWithCorrelationID("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	t, err := parseCertificateTemplateResult(statusCode, status, body)
+	t, err := parseCertificateTemplateResult(statusCode, correlationID, body)
 	return t, err
 }
@@ -26,7 +26,10 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"reflect"
 	"strings"
@@ -118,7 +121,7 @@ func TestReadZoneConfiguration(t *testing.T) {
 
 func TestRequestCertificate(t *testing.T) {
 	conn := getTestConnector(ctx.CloudZone)
-	conn.verbose = true
+	conn.SetLogger(endpoint.NewStdLogger(nil, true))
 	err := conn.Authenticate(&endpoint.Authentication{APIKey: ctx.CloudAPIkey})
 	if err != nil {
 		t.Fatalf("%s", err)
@@ -143,7 +146,7 @@ func TestRequestCertificate(t *testing.T) {
 
 func TestRequestCertificateWithUsageMetadata(t *testing.T) {
 	conn := getTestConnector(ctx.CloudZone)
-	conn.verbose = true
+	conn.SetLogger(endpoint.NewStdLogger(nil, true))
 	err := conn.Authenticate(&endpoint.Authentication{APIKey: ctx.CloudAPIkey})
 	if err != nil {
 		t.Fatalf("%s", err)
@@ -174,7 +177,7 @@ func TestRequestCertificateWithUsageMetadata(t *testing.T) {
 
 func TestRequestCertificateWithValidDays(t *testing.T) {
 	conn := getTestConnector(ctx.CloudZone)
-	conn.verbose = true
+	conn.SetLogger(endpoint.NewStdLogger(nil, true))
 	err := conn.Authenticate(&endpoint.Authentication{APIKey: ctx.CloudAPIkey})
 	if err != nil {
 		t.Fatalf("%s", err)
@@ -803,3 +806,99 @@ func TestSearchCertificate(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestCredentialProviderRenewal(t *testing.T) {
+	var unauthorized int
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("tppl-api-key") != "rotated" {
+			unauthorized++
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c, err := NewConnector(server.URL+"/", "", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetHTTPClient(server.Client())
+	c.user = &userDetails{Company: &company{}}
+	c.setAPIKey("expired")
+
+	// without a provider the 401 is returned as is
+	statusCode, _, _, err := c.request("GET", server.URL+"/v1/useraccounts", nil)
+	if err != nil || statusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d, %v", statusCode, err)
+	}
+
+	c.SetCredentialProvider(credentialsFunc(func() (*endpoint.Authentication, error) {
+		return &endpoint.Authentication{APIKey: "rotated"}, nil
+	}))
+	statusCode, _, _, err = c.request("GET", server.URL+"/v1/useraccounts", nil)
+	if err != nil || statusCode != http.StatusOK {
+		t.Fatalf("expected the request to be retried with the rotated API key, got %d, %v", statusCode, err)
+	}
+	if unauthorized != 2 || c.getAPIKey() != "rotated" {
+		t.Fatalf("expected one 401 per request and the rotated API key, got %d 401s and %s", unauthorized, c.getAPIKey())
+	}
+}
+
+func TestRetrieveCertificateNotFound(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	c, err := NewConnector(server.URL+"/", "", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetHTTPClient(server.Client())
+	c.user = &userDetails{Company: &company{}}
+	c.setAPIKey("key")
+
+	_, err = c.RetrieveCertificate(&certificate.Request{CertID: "a1b2"})
+	var respErr *verror.ResponseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a 404 response error, got %v", err)
+	}
+}
+
+func TestClientIdentity(t *testing.T) {
+	var body []byte
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("certificate")}))
+	cases := map[string]struct {
+		identity endpoint.ClientIdentity
+		expected string
+	}{
+		"explicit": {endpoint.ClientIdentity{Source: endpoint.ClientIdentityExplicit, Identifier: "build-agent-7"}, `"apiClientInformation":{"type":"Venafi VCert-Go","identifier":"build-agent-7"}`},
+		"disabled": {endpoint.ClientIdentity{Source: endpoint.ClientIdentityDisabled}, `"apiClientInformation":{"type":"Venafi VCert-Go"}`},
+	}
+	for name, c := range cases {
+		conn, err := NewConnector(server.URL+"/", "", false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetHTTPClient(server.Client())
+		conn.user = &userDetails{Company: &company{}}
+		conn.SetClientIdentity(c.identity)
+		_, _ = conn.ImportCertificate(&certificate.ImportRequest{CertificateData: certPEM, PolicyDN: "app"})
+		if !strings.Contains(string(body), c.expected) {
+			t.Fatalf("%s: expected the import request to contain %s, got %s", name, c.expected, body)
+		}
+	}
+}
+
+type credentialsFunc func() (*endpoint.Authentication, error)
+
+func (f credentialsFunc) Credentials() (*endpoint.Authentication, error) {
+	return f()
+}
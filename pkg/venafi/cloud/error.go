@@ -19,9 +19,14 @@ package cloud
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+
 	"github.com/Venafi/vcert/v4/pkg/verror"
 )
 
+// errorCodeNotFound is returned by Venafi Cloud for a zone or application that does not exist
+const errorCodeNotFound = 10051
+
 type responseError struct {
 	Code    int         `json:"code,omitempty"`
 	Message string      `json:"message,omitempty"`
@@ -41,3 +46,28 @@ func parseResponseErrors(b []byte) ([]responseError, error) {
 
 	return data.Errors, nil
 }
+
+// newResponseError returns the error for an unexpected response of endpoint, with the errors Venafi Cloud put in body
+func newResponseError(endpoint string, statusCode int, correlationID string, body []byte) *verror.ResponseError {
+	respErr := &verror.ResponseError{StatusCode: statusCode, Endpoint: endpoint, RequestID: correlationID}
+	respErrors, err := parseResponseErrors(body)
+	if err != nil {
+		return respErr
+	}
+	var messages []string
+	for _, e := range respErrors {
+		respErr.ServerCodes = append(respErr.ServerCodes, e.Code)
+		messages = append(messages, e.Message)
+	}
+	respErr.Message = strings.Join(messages, "; ")
+	return respErr
+}
+
+func hasServerCode(respErr *verror.ResponseError, code int) bool {
+	for _, c := range respErr.ServerCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
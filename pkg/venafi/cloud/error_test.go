@@ -17,7 +17,11 @@
 package cloud
 
 import (
+	"errors"
+	"net/http"
 	"testing"
+
+	"github.com/Venafi/vcert/v4/pkg/verror"
 )
 
 func TestParseResponseErrors(t *testing.T) {
@@ -46,3 +50,23 @@ func TestParseResponseErrorWithArgs(t *testing.T) {
 		t.Fatalf("ParseResponseErrors returned incorrect code.  Expected: 10726 Actual: %d", errors[0].Code)
 	}
 }
+
+func TestParseResultResponseError(t *testing.T) {
+	data := []byte("{\"errors\":[{\"code\":10051,\"message\":\"Unable to find application\",\"args\":[]}]}")
+	_, err := parseApplicationDetailsResult(http.StatusNotFound, "req-1", data)
+	if !errors.Is(err, verror.ApplicationNotFoundError) {
+		t.Fatalf("expected ApplicationNotFoundError, got %s", err)
+	}
+	var respErr *verror.ResponseError
+	if !errors.As(err, &respErr) {
+		t.Fatalf("expected a *verror.ResponseError, got %T", err)
+	}
+	if respErr.StatusCode != http.StatusNotFound || len(respErr.ServerCodes) != 1 || respErr.ServerCodes[0] != 10051 || respErr.RequestID != "req-1" {
+		t.Fatalf("unexpected error details %+v", respErr)
+	}
+
+	_, err = parseCertificateRequestResult(http.StatusInternalServerError, "", []byte("not json"))
+	if !errors.As(err, &respErr) || !errors.Is(err, verror.ServerError) || respErr.Message != "" {
+		t.Fatalf("an unparsable body should still give a ServerError with the status code, got %s", err)
+	}
+}
@@ -109,6 +109,10 @@ func (c Certificate) ToCertificateInfo() certificate.CertificateInfo {
 }
 
 func ParseCertificateSearchResponse(httpStatusCode int, body []byte) (searchResult *CertificateSearchResponse, err error) {
+	return parseCertificateSearchResult(httpStatusCode, "", body)
+}
+
+func parseCertificateSearchResult(httpStatusCode int, correlationID string, body []byte) (searchResult *CertificateSearchResponse, err error) {
 	switch httpStatusCode {
 	case http.StatusOK:
 		var searchResult = &CertificateSearchResponse{}
@@ -118,16 +122,6 @@ func ParseCertificateSearchResponse(httpStatusCode int, body []byte) (searchResu
 		}
 		return searchResult, nil
 	default:
-		if body != nil {
-			respErrors, err := parseResponseErrors(body)
-			if err == nil {
-				respError := fmt.Sprintf("Unexpected status code on Venafi Cloud certificate search. Status: %d\n", httpStatusCode)
-				for _, e := range respErrors {
-					respError += fmt.Sprintf("Error Code: %d Error: %s\n", e.Code, e.Message)
-				}
-				return nil, fmt.Errorf(respError)
-			}
-		}
-		return nil, fmt.Errorf("unexpected status code on Venafi Cloud certificate search. Status: %d", httpStatusCode)
+		return nil, newResponseError("Venafi Cloud certificate search", httpStatusCode, correlationID, body)
 	}
 }
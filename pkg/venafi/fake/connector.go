@@ -34,14 +34,28 @@ import (
 )
 
 type Connector struct {
-	verbose bool
+	logger endpoint.Logger
 }
 
 func NewConnector(verbose bool, trust *x509.CertPool) *Connector {
-	c := Connector{verbose: verbose}
+	c := Connector{logger: endpoint.NewStdLogger(nil, verbose)}
 	return &c
 }
 
+// SetLogger sets the logger of the Connector. A nil logger restores the standard logger.
+func (c *Connector) SetLogger(l endpoint.Logger) {
+	c.logger = l
+}
+
+// log returns the logger of the Connector, the standard logger without debug messages if it has none, e.g. because
+// the Connector was not created by NewConnector
+func (c *Connector) log() endpoint.Logger {
+	if c.logger == nil {
+		return endpoint.NewStdLogger(nil, false)
+	}
+	return c.logger
+}
+
 func (c *Connector) GetType() endpoint.ConnectorType {
 	return endpoint.ConnectorTypeFake
 }
@@ -57,9 +71,16 @@ func (c *Connector) Authenticate(auth *endpoint.Authentication) (err error) {
 	return
 }
 
+// pendingPrefix marks the common names of requests that are never issued, for testing how clients handle
+// certificates that are pending, e.g. waiting for an approval
+const pendingPrefix = "pending."
+
+const pendingStatus = "Pending"
+
 type fakeRequestID struct {
-	Req *certificate.Request
-	CSR string
+	Req     *certificate.Request
+	CSR     string
+	Pending bool `json:",omitempty"`
 }
 
 func validateRequest(req *certificate.Request) error {
@@ -89,6 +110,8 @@ func (c *Connector) RequestCertificate(req *certificate.Request) (requestID stri
 	default:
 		return "", fmt.Errorf("Unexpected option in PrivateKeyOrigin")
 	}
+	fakeRequest.Pending = strings.HasPrefix(requestCommonName(req), pendingPrefix)
+	c.log().Debug("Requested certificate in test mode", "cn", requestCommonName(req), "pending", fakeRequest.Pending)
 
 	js, err := json.Marshal(fakeRequest)
 	if err != nil {
@@ -99,6 +122,21 @@ func (c *Connector) RequestCertificate(req *certificate.Request) (requestID stri
 	return pickupID, nil
 }
 
+func requestCommonName(req *certificate.Request) string {
+	if req.Subject.CommonName != "" {
+		return req.Subject.CommonName
+	}
+	block, _ := pem.Decode(req.GetCSR())
+	if block == nil {
+		return ""
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return ""
+	}
+	return csr.Subject.CommonName
+}
+
 func issueCertificate(csr *x509.CertificateRequest) ([]byte, error) {
 	limit := new(big.Int).Lsh(big.NewInt(1), 128)
 	serial, _ := rand.Int(rand.Reader, limit)
@@ -152,6 +190,13 @@ func (c *Connector) RetrieveCertificate(req *certificate.Request) (pcc *certific
 	if err != nil {
 		return nil, fmt.Errorf("failed to json.Unmarshal(fakeRequestId): %s\n", err)
 	}
+	if fakeRequest.Pending {
+		if req.Timeout == 0 {
+			return nil, endpoint.ErrCertificatePending{CertificateID: req.PickupID, Status: pendingStatus}
+		}
+		time.Sleep(req.Timeout)
+		return nil, endpoint.ErrRetrieveCertificateTimeout{CertificateID: req.PickupID, Status: pendingStatus, Elapsed: req.Timeout}
+	}
 
 	var csrPEMbytes []byte
 	var pk crypto.Signer
@@ -233,9 +278,12 @@ func (c *Connector) ReadZoneConfiguration() (config *endpoint.ZoneConfiguration,
 	return
 }
 
-// RenewCertificate attempts to renew the certificate
-func (c *Connector) RenewCertificate(revReq *certificate.RenewalRequest) (requestID string, err error) {
-	return "", fmt.Errorf("renew is not supported in -test-mode")
+// RenewCertificate issues a new certificate for the request of the renewal, the previous certificate is not looked up
+func (c *Connector) RenewCertificate(renewReq *certificate.RenewalRequest) (requestID string, err error) {
+	if renewReq.CertificateRequest == nil {
+		return "", fmt.Errorf("renew without a certificate request is not supported in -test-mode")
+	}
+	return c.RequestCertificate(renewReq.CertificateRequest)
 }
 
 func (c *Connector) ImportCertificate(req *certificate.ImportRequest) (*certificate.ImportResponse, error) {
@@ -17,8 +17,11 @@
 package fake
 
 import (
+	"errors"
 	"fmt"
 	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/Venafi/vcert/v4/pkg/verror"
 	"testing"
 	"time"
 )
@@ -50,6 +53,36 @@ func TestRetrieveCertificate(t *testing.T) {
 	}
 }
 
+func TestRetrievePendingCertificate(t *testing.T) {
+	conn := getTestConnector()
+	req := &certificate.Request{KeyType: certificate.KeyTypeECDSA}
+	req.Subject.CommonName = "pending.example.com"
+	err := conn.GenerateRequest(&endpoint.ZoneConfiguration{}, req)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	req.PickupID, err = conn.RequestCertificate(req)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	_, err = conn.RetrieveCertificate(req)
+	var pending endpoint.ErrCertificatePending
+	if !errors.Is(err, verror.CertificatePendingError) || !errors.As(err, &pending) || pending.CertificateID != req.PickupID {
+		t.Fatalf("expected the certificate to be pending, got %v", err)
+	}
+
+	req.Timeout = 10 * time.Millisecond
+	_, err = conn.RetrieveCertificate(req)
+	var timeout endpoint.ErrRetrieveCertificateTimeout
+	if !errors.Is(err, verror.RetrieveCertificateTimeoutError) || !errors.As(err, &timeout) || timeout.Elapsed != req.Timeout || timeout.Status == "" {
+		t.Fatalf("expected a timeout with the pending status, got %v", err)
+	}
+	if errors.Is(err, verror.CertificatePendingError) {
+		t.Fatal("a timeout should not match CertificatePendingError")
+	}
+}
+
 func getTestConnector() *Connector {
 	c := NewConnector(true, nil)
 	return c
@@ -92,3 +125,46 @@ func TestRequestCertificate(t *testing.T) {
 		t.Fatalf("should return non-empty pickupId")
 	}
 }
+
+func TestRequestCertificateWithoutLogger(t *testing.T) {
+	for _, connector := range []*Connector{{}, NewConnector(false, nil)} {
+		connector.SetLogger(nil)
+		req := &certificate.Request{}
+		req.Subject.CommonName = "test-mode"
+		req.CsrOrigin = certificate.LocalGeneratedCSR
+		req.KeyType = certificate.KeyTypeECDSA
+		err := connector.GenerateRequest(nil, req)
+		if err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		_, err = connector.RequestCertificate(req)
+		if err != nil {
+			t.Fatalf("error: %s", err)
+		}
+	}
+}
+
+func TestRenewCertificate(t *testing.T) {
+	var connector = getTestConnector()
+	_, err := connector.RenewCertificate(&certificate.RenewalRequest{Thumbprint: "AABBCC"})
+	if err == nil {
+		t.Fatal("renewal without certificate request should fail")
+	}
+
+	req := &certificate.Request{}
+	req.Subject.CommonName = "renew.test-mode"
+	req.CsrOrigin = certificate.LocalGeneratedCSR
+	err = connector.GenerateRequest(nil, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pickupID, err := connector.RenewCertificate(&certificate.RenewalRequest{Thumbprint: "AABBCC", CertificateRequest: req})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.PickupID = pickupID
+	_, err = connector.RetrieveCertificate(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,240 @@
+/*
+ * Copyright 2021 Venafi, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tpp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+)
+
+// tokenServer emulates the TPP token endpoint: every refresh issues access-N and refresh-N, which expire after lifetime
+type tokenServer struct {
+	sync.Mutex
+	refreshes    int
+	unauthorized int
+	lifetime     time.Duration
+}
+
+func (s *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	switch r.URL.Path {
+	case "/" + string(urlResourceRefreshAccessToken):
+		var req oauthRefreshAccessTokenRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Refresh_token != fmt.Sprintf("refresh-%d", s.refreshes) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.refreshes++
+		_ = json.NewEncoder(w).Encode(OauthRefreshAccessTokenResponse{
+			Access_token:  fmt.Sprintf("access-%d", s.refreshes),
+			Refresh_token: fmt.Sprintf("refresh-%d", s.refreshes),
+			Expires:       int(time.Now().Add(s.lifetime).Unix()),
+		})
+	case "/" + string(urlResourceSystemStatusVersion):
+		if r.Header.Get("Authorization") != fmt.Sprintf("Bearer access-%d", s.refreshes) {
+			s.unauthorized++
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"Version":"20.4.0.0"}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestAccessTokenRefresh(t *testing.T) {
+	ts := &tokenServer{lifetime: time.Hour}
+	server := httptest.NewTLSServer(ts)
+	defer server.Close()
+
+	tpp, err := NewConnector(server.URL, "", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpp.SetHTTPClient(server.Client())
+	var refreshed []string
+	tpp.SetTokenRefreshCallback(func(resp OauthRefreshAccessTokenResponse) {
+		refreshed = append(refreshed, resp.Refresh_token)
+	})
+
+	auth := &endpoint.Authentication{RefreshToken: "refresh-0"}
+	if err = tpp.Authenticate(auth); err != nil {
+		t.Fatal(err)
+	}
+	if auth.RefreshToken != "refresh-1" {
+		t.Fatalf("expected Authenticate to return the new refresh token, got %q", auth.RefreshToken)
+	}
+
+	// the access token is revoked on the server: the request gets 401, refreshes and retries.
+	// From now on the server issues tokens that are about to expire.
+	ts.Lock()
+	ts.lifetime = accessTokenRefreshMargin / 2
+	ts.Unlock()
+	tpp.tokenLock.Lock()
+	tpp.accessToken = "revoked"
+	tpp.tokenLock.Unlock()
+	if _, err = tpp.requestSystemVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if ts.unauthorized != 1 || ts.refreshes != 2 {
+		t.Fatalf("expected one 401 and a refresh, got %d 401s and %d refreshes", ts.unauthorized, ts.refreshes)
+	}
+
+	// the access token is about to expire: it is refreshed before the request is sent
+	if _, err = tpp.requestSystemVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tpp.requestSystemVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if ts.unauthorized != 1 || ts.refreshes != 4 {
+		t.Fatalf("expected the expiring token to be refreshed before each request, got %d 401s and %d refreshes", ts.unauthorized, ts.refreshes)
+	}
+
+	expected := []string{"refresh-1", "refresh-2", "refresh-3", "refresh-4"}
+	if !reflect.DeepEqual(refreshed, expected) {
+		t.Fatalf("expected refresh callbacks for %v, got %v", expected, refreshed)
+	}
+}
+
+func TestAccessTokenWithoutRefreshToken(t *testing.T) {
+	ts := &tokenServer{lifetime: time.Hour}
+	server := httptest.NewTLSServer(ts)
+	defer server.Close()
+
+	tpp, err := NewConnector(server.URL, "", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpp.SetHTTPClient(server.Client())
+	if err = tpp.Authenticate(&endpoint.Authentication{AccessToken: "expired"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tpp.requestSystemVersion(); err == nil {
+		t.Fatal("expected the request to fail with an access token that can not be refreshed")
+	}
+	if ts.refreshes != 0 {
+		t.Fatalf("expected no refresh, got %d", ts.refreshes)
+	}
+}
+
+func TestCredentialProviderRenewal(t *testing.T) {
+	ts := &tokenServer{lifetime: time.Hour}
+	server := httptest.NewTLSServer(ts)
+	defer server.Close()
+
+	tpp, err := NewConnector(server.URL, "", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpp.SetHTTPClient(server.Client())
+	if err = tpp.Authenticate(&endpoint.Authentication{AccessToken: "rotated-out"}); err != nil {
+		t.Fatal(err)
+	}
+	tpp.SetCredentialProvider(credentialsFunc(func() (*endpoint.Authentication, error) {
+		return &endpoint.Authentication{AccessToken: "access-0"}, nil
+	}))
+
+	if _, err = tpp.requestSystemVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if ts.unauthorized != 1 || tpp.getRequestAuth().accessToken != "access-0" {
+		t.Fatalf("expected the request to be retried with the credentials of the provider, got %d 401s and %+v", ts.unauthorized, tpp.getRequestAuth())
+	}
+}
+
+type credentialsFunc func() (*endpoint.Authentication, error)
+
+func (f credentialsFunc) Credentials() (*endpoint.Authentication, error) {
+	return f()
+}
+
+func TestClientCertificateAuthentication(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client.vcert.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := &tokenServer{lifetime: time.Hour}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+string(urlResourceAuthorizeCertificate) {
+			ts.ServeHTTP(w, r)
+			return
+		}
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "client.vcert.test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(OauthGetRefreshTokenResponse{
+			Access_token:  "access-0",
+			Refresh_token: "refresh-0",
+			Expires:       int(time.Now().Add(time.Hour).Unix()),
+		})
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	trust := x509.NewCertPool()
+	trust.AddCert(server.Certificate())
+	tpp, err := NewConnector(server.URL, "", false, trust)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tpp.Authenticate(&endpoint.Authentication{ClientPKCS12: true}); err == nil {
+		t.Fatal("expected authentication without a client certificate to fail")
+	}
+
+	tpp.SetClientCertificate(&tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key})
+	if err = tpp.Authenticate(&endpoint.Authentication{ClientPKCS12: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tpp.requestSystemVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if auth := tpp.getRequestAuth(); auth.accessToken != "access-0" || ts.unauthorized != 0 {
+		t.Fatalf("expected requests to use the access token of the client certificate, got %+v and %d 401s", auth, ts.unauthorized)
+	}
+}
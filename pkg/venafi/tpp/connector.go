@@ -17,15 +17,16 @@
 package tpp
 
 import (
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
-	"log"
 	"net/http"
 	neturl "net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Venafi/vcert/v4/pkg/util"
@@ -37,18 +38,37 @@ import (
 
 // Connector contains the base data needed to communicate with a TPP Server
 type Connector struct {
-	baseURL     string
-	apiKey      string
-	accessToken string
-	verbose     bool
-	trust       *x509.CertPool
-	zone        string
-	client      *http.Client
+	baseURL   string
+	logger    endpoint.Logger
+	hooks     endpoint.Hooks
+	transport endpoint.TransportConfig
+	zone      string
+	client    *http.Client
+
+	// tokenLock guards the credentials below, refreshLock makes concurrent requests share one refresh
+	tokenLock          sync.RWMutex
+	refreshLock        sync.Mutex
+	apiKey             string
+	accessToken        string
+	accessTokenExpires time.Time
+	refreshToken       string
+	clientID           string
+	onTokenRefresh     TokenRefreshCallback
+	credentials        endpoint.CredentialProvider
 }
 
-// NewConnector creates a new TPP Connector object used to communicate with TPP
+// TokenRefreshCallback is called with the response of every access token refresh done by the Connector.
+// TPP may rotate the refresh token on each refresh, so callers that store the refresh token should save
+// resp.Refresh_token here.
+type TokenRefreshCallback func(resp OauthRefreshAccessTokenResponse)
+
+// accessTokenRefreshMargin is how long before its expiration the access token is refreshed
+const accessTokenRefreshMargin = time.Minute
+
+// NewConnector creates a new TPP Connector object used to communicate with TPP. It logs to the standard logger,
+// debug messages only if verbose is true, until SetLogger is called.
 func NewConnector(url string, zone string, verbose bool, trust *x509.CertPool) (*Connector, error) {
-	c := Connector{verbose: verbose, trust: trust, zone: zone}
+	c := Connector{logger: endpoint.NewStdLogger(nil, verbose), transport: endpoint.TransportConfig{Trust: trust}, zone: zone}
 	var err error
 	c.baseURL, err = normalizeURL(url)
 	if err != nil {
@@ -124,7 +144,7 @@ func (c *Connector) Authenticate(auth *endpoint.Authentication) (err error) {
 		}
 
 		resp := result.(authorizeResponse)
-		c.apiKey = resp.APIKey
+		c.setAPIKey(resp.APIKey)
 		return nil
 
 	} else if auth.RefreshToken != "" {
@@ -135,12 +155,37 @@ func (c *Connector) Authenticate(auth *endpoint.Authentication) (err error) {
 		}
 
 		resp := result.(OauthRefreshAccessTokenResponse)
-		c.accessToken = resp.Access_token
+		c.tokenLock.Lock()
+		c.clientID = auth.ClientId
+		c.setTokens(resp)
+		c.tokenLock.Unlock()
 		auth.RefreshToken = resp.Refresh_token
+		c.notifyTokenRefresh(resp)
 		return nil
 
 	} else if auth.AccessToken != "" {
-		c.accessToken = auth.AccessToken
+		c.setAccessToken(auth.AccessToken)
+		return nil
+
+	} else if auth.ClientPKCS12 {
+		if auth.Scope == "" {
+			auth.Scope = defaultScope
+		}
+		data := oauthCertificateTokenRequest{Client_id: auth.ClientId, Scope: auth.Scope}
+		result, err := processAuthData(c, urlResourceAuthorizeCertificate, data)
+		if err != nil {
+			return err
+		}
+
+		resp := result.(OauthGetRefreshTokenResponse)
+		c.tokenLock.Lock()
+		c.clientID = auth.ClientId
+		c.setTokens(OauthRefreshAccessTokenResponse{
+			Access_token:  resp.Access_token,
+			Expires:       resp.Expires,
+			Refresh_token: resp.Refresh_token,
+		})
+		c.tokenLock.Unlock()
 		return nil
 	}
 	return fmt.Errorf("failed to authenticate: can't determine valid credentials set")
@@ -207,6 +252,176 @@ func (c *Connector) RefreshAccessToken(auth *endpoint.Authentication) (resp Oaut
 	}
 }
 
+// SetTokenRefreshCallback sets the function called when the Connector refreshes its access token, including
+// the initial refresh done by Authenticate. Only Connectors authenticated with a refresh token refresh their
+// access token: before it expires, according to the expiration returned by TPP, or when a request gets
+// 401 Unauthorized.
+func (c *Connector) SetTokenRefreshCallback(f TokenRefreshCallback) {
+	c.tokenLock.Lock()
+	defer c.tokenLock.Unlock()
+	c.onTokenRefresh = f
+}
+
+// SetCredentialProvider sets the provider the Connector authenticates with again when a request gets
+// 401 Unauthorized and its access token can not be refreshed
+func (c *Connector) SetCredentialProvider(p endpoint.CredentialProvider) {
+	c.tokenLock.Lock()
+	defer c.tokenLock.Unlock()
+	c.credentials = p
+}
+
+// SetClientCertificate sets the certificate the Connector presents to TPP servers that require client certificate
+// authentication. It is used by the HTTP client the Connector creates, so it has no effect after SetHTTPClient.
+// Authenticate with ClientPKCS12 set obtains an access token for the identity of the certificate.
+func (c *Connector) SetClientCertificate(cert *tls.Certificate) {
+	c.transport.ClientCertificate = cert
+	c.transport.Renegotiation = true
+	c.client = nil
+}
+
+// SetTransportConfig sets the settings of the HTTP client the Connector creates. It replaces the trust pool given to
+// NewConnector and has no effect after SetHTTPClient.
+func (c *Connector) SetTransportConfig(t endpoint.TransportConfig) {
+	c.transport = t
+	c.client = nil
+}
+
+// SetLogger sets the logger of the Connector. A nil logger restores the standard logger.
+func (c *Connector) SetLogger(l endpoint.Logger) {
+	c.logger = l
+}
+
+// log returns the logger of the Connector, the standard logger without debug messages if it has none, e.g. because
+// the Connector was not created by NewConnector
+func (c *Connector) log() endpoint.Logger {
+	if c.logger == nil {
+		return endpoint.NewStdLogger(nil, false)
+	}
+	return c.logger
+}
+
+// SetHooks sets the hooks called around the HTTP requests of the Connector. Use endpoint.WithHooks to observe its
+// operations.
+func (c *Connector) SetHooks(h endpoint.Hooks) {
+	c.hooks = h
+}
+
+// requestAuth is the access token or API key a request is sent with
+type requestAuth struct {
+	accessToken string
+	apiKey      string
+}
+
+func (c *Connector) getRequestAuth() requestAuth {
+	c.tokenLock.RLock()
+	defer c.tokenLock.RUnlock()
+	return requestAuth{accessToken: c.accessToken, apiKey: c.apiKey}
+}
+
+// setAccessToken sets an access token that can not be refreshed
+func (c *Connector) setAccessToken(token string) {
+	c.tokenLock.Lock()
+	defer c.tokenLock.Unlock()
+	c.accessToken = token
+	c.accessTokenExpires = time.Time{}
+	c.refreshToken = ""
+	c.apiKey = ""
+}
+
+func (c *Connector) setAPIKey(apiKey string) {
+	c.tokenLock.Lock()
+	defer c.tokenLock.Unlock()
+	c.apiKey = apiKey
+	c.accessToken = ""
+	c.accessTokenExpires = time.Time{}
+	c.refreshToken = ""
+}
+
+// setTokens stores the tokens of resp; the caller must hold tokenLock
+func (c *Connector) setTokens(resp OauthRefreshAccessTokenResponse) {
+	c.apiKey = ""
+	c.accessToken = resp.Access_token
+	c.accessTokenExpires = time.Time{}
+	if resp.Expires > 0 {
+		c.accessTokenExpires = time.Unix(int64(resp.Expires), 0)
+	}
+	if resp.Refresh_token != "" {
+		c.refreshToken = resp.Refresh_token
+	}
+}
+
+func (c *Connector) notifyTokenRefresh(resp OauthRefreshAccessTokenResponse) {
+	c.tokenLock.RLock()
+	f := c.onTokenRefresh
+	c.tokenLock.RUnlock()
+	if f != nil {
+		f(resp)
+	}
+}
+
+// accessTokenExpiring reports whether the access token can be refreshed and expires within accessTokenRefreshMargin
+func (c *Connector) accessTokenExpiring() bool {
+	c.tokenLock.RLock()
+	defer c.tokenLock.RUnlock()
+	if c.refreshToken == "" || c.accessTokenExpires.IsZero() {
+		return false
+	}
+	return time.Now().Add(accessTokenRefreshMargin).After(c.accessTokenExpires)
+}
+
+// renewCredentials replaces the stale credentials of a request, refreshing the access token with the stored refresh
+// token or else authenticating again with the credentials of the CredentialProvider. It reports false if the
+// Connector has no way to renew them. It does nothing if another request already replaced stale, so that
+// concurrent requests renew the credentials only once.
+func (c *Connector) renewCredentials(stale requestAuth) (bool, error) {
+	c.refreshLock.Lock()
+	defer c.refreshLock.Unlock()
+
+	c.tokenLock.RLock()
+	current := requestAuth{accessToken: c.accessToken, apiKey: c.apiKey}
+	refreshToken, clientID, provider := c.refreshToken, c.clientID, c.credentials
+	c.tokenLock.RUnlock()
+	if current != stale {
+		return true, nil
+	}
+
+	if refreshToken != "" {
+		err := c.refreshAccessToken(refreshToken, clientID)
+		if err == nil || provider == nil {
+			return err == nil, err
+		}
+		c.log().Debug("Failed to refresh the access token, authenticating with the credential provider", "error", err)
+	}
+	if provider == nil {
+		return false, nil
+	}
+	auth, err := provider.Credentials()
+	if err != nil {
+		return false, fmt.Errorf("%w: failed to renew credentials: %s", verror.AuthError, err)
+	}
+	err = c.Authenticate(auth)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// refreshAccessToken obtains a new access token with refreshToken
+func (c *Connector) refreshAccessToken(refreshToken, clientID string) error {
+	data := oauthRefreshAccessTokenRequest{Client_id: clientID, Refresh_token: refreshToken}
+	result, err := processAuthData(c, urlResourceRefreshAccessToken, data)
+	if err != nil {
+		return fmt.Errorf("%w: failed to refresh access token: %s", verror.AuthError, err)
+	}
+	resp := result.(OauthRefreshAccessTokenResponse)
+	c.tokenLock.Lock()
+	c.setTokens(resp)
+	c.tokenLock.Unlock()
+	c.log().Debug("Refreshed TPP access token")
+	c.notifyTokenRefresh(resp)
+	return nil
+}
+
 // VerifyAccessToken - call to check whether token is valid and, if so, return its properties
 func (c *Connector) VerifyAccessToken(auth *endpoint.Authentication) (resp OauthVerifyTokenResponse, err error) {
 
@@ -215,7 +430,7 @@ func (c *Connector) VerifyAccessToken(auth *endpoint.Authentication) (resp Oauth
 	}
 
 	if auth.AccessToken != "" {
-		c.accessToken = auth.AccessToken
+		c.setAccessToken(auth.AccessToken)
 		statusCode, statusText, body, err := c.request("GET", urlResource(urlResourceAuthorizeVerify), nil)
 		if err != nil {
 			return resp, err
@@ -243,7 +458,7 @@ func (c *Connector) RevokeAccessToken(auth *endpoint.Authentication) (err error)
 	}
 
 	if auth.AccessToken != "" {
-		c.accessToken = auth.AccessToken
+		c.setAccessToken(auth.AccessToken)
 		statusCode, statusText, _, err := c.request("GET", urlResource(urlResourceRevokeAccessToken), nil)
 		if err != nil {
 			return err
@@ -544,9 +759,7 @@ func (c *Connector) proccessLocation(req *certificate.Request) error {
 		return fmt.Errorf("unable to retrieve certificate guid: %s", err)
 	}
 	if guid == "" {
-		if c.verbose {
-			log.Printf("certificate with DN %s doesn't exists so no need to check if it is associated with any instances", certDN)
-		}
+		c.log().Debug("Certificate doesn't exist so no need to check if it is associated with any instances", "dn", certDN)
 		return nil
 	}
 	details, err := c.searchCertificateDetails(guid)
@@ -554,19 +767,15 @@ func (c *Connector) proccessLocation(req *certificate.Request) error {
 		return err
 	}
 	if len(details.Consumers) == 0 {
-		log.Printf("There were no instances associated with certificate %s", certDN)
+		c.log().Info("There were no instances associated with certificate", "dn", certDN)
 		return nil
 	}
-	if c.verbose {
-		log.Printf("checking associated instances from:\n %s", details.Consumers)
-	}
+	c.log().Debug("Checking associated instances", "dn", certDN, "instances", details.Consumers)
 	var device string
 	requestedDevice := getDeviceDN(stripBackSlashes(c.zone), *req.Location)
 
 	for _, device = range details.Consumers {
-		if c.verbose {
-			log.Printf("comparing requested instance %s to %s", requestedDevice, device)
-		}
+		c.log().Debug("Comparing requested instance", "requested", requestedDevice, "instance", device)
 		if device == requestedDevice {
 			if req.Location.Replace {
 				err = c.dissociate(certDN, device)
@@ -593,15 +802,16 @@ func (c *Connector) RequestCertificate(req *certificate.Request) (requestID stri
 	if err != nil {
 		return "", err
 	}
-	statusCode, status, body, err := c.request("POST", urlResourceCertificateRequest, tppCertificateRequest)
+	statusCode, _, body, correlationID, err := c.requestWithCorrelationID("POST", urlResourceCertificateRequest, tppCertificateRequest)
 	if err != nil {
 		return "", err
 	}
-	requestID, err = parseRequestResult(statusCode, status, body)
+	requestID, err = parseRequestResult(statusCode, correlationID, body)
 	if err != nil {
 		return "", err
 	}
 	req.PickupID = requestID
+	c.log().Debug("Requested certificate", "zone", c.zone, "pickupId", requestID)
 
 	if len(req.CustomFields) == 0 {
 		return
@@ -614,7 +824,7 @@ func (c *Connector) RequestCertificate(req *certificate.Request) (requestID stri
 	//the 19.2 WebSDK calls
 	metadataItems, err := c.requestMetadataItems(requestID)
 	if err != nil {
-		log.Println(err)
+		c.log().Error("Failed to read the metadata of the certificate", "pickupId", requestID, "error", err)
 		return
 	}
 	//prepare struct for search
@@ -645,18 +855,18 @@ func (c *Connector) RequestCertificate(req *certificate.Request) (requestID stri
 	if allItemsFound {
 		return
 	}
-	log.Println("Saving metadata custom field using 19.2 method")
+	c.log().Info("Saving metadata custom field using 19.2 method", "pickupId", requestID)
 	//Create a metadata/set command with the metadata from tppCertificateRequest
 	guidItems, err := prepareLegacyMetadata(c, tppCertificateRequest.CustomFields, requestID)
 	if err != nil {
-		log.Println(err)
+		c.log().Error("Failed to prepare the metadata of the certificate", "pickupId", requestID, "error", err)
 		return
 	}
 	requestData := metadataSetRequest{requestID, guidItems, true}
 	//c.request with the metadata request
 	_, err = c.setCertificateMetadata(requestData)
 	if err != nil {
-		log.Println(err)
+		c.log().Error("Failed to save the metadata of the certificate", "pickupId", requestID, "error", err)
 	}
 	return
 }
@@ -698,7 +908,7 @@ func (c *Connector) RetrieveCertificate(req *certificate.Request) (certificates
 		var retrieveResponse *certificateRetrieveResponse
 		retrieveResponse, err = c.retrieveCertificateOnce(certReq)
 		if err != nil {
-			return nil, fmt.Errorf("unable to retrieve: %s", err)
+			return nil, fmt.Errorf("unable to retrieve: %w", err)
 		}
 		if retrieveResponse.CertificateData != "" {
 			certificates, err = newPEMCollectionFromResponse(retrieveResponse.CertificateData, req.ChainOption)
@@ -709,21 +919,21 @@ func (c *Connector) RetrieveCertificate(req *certificate.Request) (certificates
 			return
 		}
 		if req.Timeout == 0 {
-			return nil, endpoint.ErrCertificatePending{CertificateID: req.PickupID, Status: retrieveResponse.Status}
+			return nil, endpoint.ErrCertificatePending{CertificateID: req.PickupID, Status: retrieveResponse.Status, Elapsed: time.Since(startTime)}
 		}
 		if time.Now().After(startTime.Add(req.Timeout)) {
-			return nil, endpoint.ErrRetrieveCertificateTimeout{CertificateID: req.PickupID}
+			return nil, endpoint.ErrRetrieveCertificateTimeout{CertificateID: req.PickupID, Status: retrieveResponse.Status, Elapsed: time.Since(startTime)}
 		}
 		time.Sleep(2 * time.Second)
 	}
 }
 
 func (c *Connector) retrieveCertificateOnce(certReq certificateRetrieveRequest) (*certificateRetrieveResponse, error) {
-	statusCode, status, body, err := c.request("POST", urlResourceCertificateRetrieve, certReq)
+	statusCode, _, body, correlationID, err := c.requestWithCorrelationID("POST", urlResourceCertificateRetrieve, certReq)
 	if err != nil {
 		return nil, err
 	}
-	retrieveResponse, err := parseRetrieveResult(statusCode, status, body)
+	retrieveResponse, err := parseRetrieveResult(statusCode, correlationID, body)
 	if err != nil {
 		return nil, err
 	}
@@ -817,12 +1027,12 @@ func (c *Connector) RenewCertificate(renewReq *certificate.RenewalRequest) (requ
 	if renewReq.CertificateRequest != nil && len(renewReq.CertificateRequest.GetCSR()) != 0 {
 		r.PKCS10 = string(renewReq.CertificateRequest.GetCSR())
 	}
-	statusCode, status, body, err := c.request("POST", urlResourceCertificateRenew, r)
+	statusCode, _, body, correlationID, err := c.requestWithCorrelationID("POST", urlResourceCertificateRenew, r)
 	if err != nil {
 		return "", err
 	}
 
-	response, err := parseRenewResult(statusCode, status, body)
+	response, err := parseRenewResult(statusCode, correlationID, body)
 	if err != nil {
 		return "", err
 	}
@@ -846,11 +1056,11 @@ func (c *Connector) RevokeCertificate(revReq *certificate.RevocationRequest) (er
 		revReq.Comments,
 		revReq.Disable,
 	}
-	statusCode, status, body, err := c.request("POST", urlResourceCertificateRevoke, r)
+	statusCode, _, body, correlationID, err := c.requestWithCorrelationID("POST", urlResourceCertificateRevoke, r)
 	if err != nil {
 		return err
 	}
-	revokeResponse, err := parseRevokeResult(statusCode, status, body)
+	revokeResponse, err := parseRevokeResult(statusCode, correlationID, body)
 	if err != nil {
 		return
 	}
@@ -867,7 +1077,7 @@ func (c *Connector) ReadPolicyConfiguration() (policy *endpoint.Policy, err erro
 		return nil, fmt.Errorf("empty zone")
 	}
 	rq := struct{ PolicyDN string }{getPolicyDN(c.zone)}
-	statusCode, status, body, err := c.request("POST", urlResourceCertificatePolicy, rq)
+	statusCode, _, body, correlationID, err := c.requestWithCorrelationID("POST", urlResourceCertificatePolicy, rq)
 	if err != nil {
 		return
 	}
@@ -890,8 +1100,9 @@ func (c *Connector) ReadPolicyConfiguration() (policy *endpoint.Policy, err erro
 		if zoneNonFoundregexp.Match([]byte(r.Error)) {
 			return nil, verror.ZoneNotFoundError
 		}
+		return nil, newResponseError("TPP Policy Read", statusCode, correlationID, body)
 	} else {
-		return nil, fmt.Errorf("Invalid status: %s Server data: %s", status, body)
+		return nil, newResponseError("TPP Policy Read", statusCode, correlationID, body)
 	}
 	return
 }
@@ -904,7 +1115,7 @@ func (c *Connector) ReadZoneConfiguration() (config *endpoint.ZoneConfiguration,
 	zoneConfig := endpoint.NewZoneConfiguration()
 	zoneConfig.HashAlgorithm = x509.SHA256WithRSA //todo: check this can have problem with ECDSA key
 	rq := struct{ PolicyDN string }{getPolicyDN(c.zone)}
-	statusCode, status, body, err := c.request("POST", urlResourceCertificatePolicy, rq)
+	statusCode, _, body, correlationID, err := c.requestWithCorrelationID("POST", urlResourceCertificatePolicy, rq)
 	if err != nil {
 		return
 	}
@@ -930,7 +1141,7 @@ func (c *Connector) ReadZoneConfiguration() (config *endpoint.ZoneConfiguration,
 			return nil, verror.ZoneNotFoundError
 		}
 	}
-	return nil, fmt.Errorf("Invalid status: %s Server response: %s", status, string(body))
+	return nil, newResponseError("TPP Zone Configuration Read", statusCode, correlationID, body)
 
 }
 
@@ -967,7 +1178,7 @@ func (c *Connector) ImportCertificate(req *certificate.ImportRequest) (*certific
 		}
 		err = c.putCertificateInfo(response.CertificateDN, []nameSliceValuePair{{Name: "Origin", Value: []string{origin}}})
 		if err != nil {
-			log.Println(err)
+			c.log().Error("Failed to set the origin of the imported certificate", "dn", response.CertificateDN, "error", err)
 		}
 		return response, nil
 	case http.StatusBadRequest:
@@ -1081,7 +1292,7 @@ func (c *Connector) dissociate(certDN, applicationDN string) error {
 		[]string{applicationDN},
 		true,
 	}
-	log.Println("Dissociating device", applicationDN)
+	c.log().Info("Dissociating device", "dn", certDN, "device", applicationDN)
 	statusCode, status, body, err := c.request("POST", urlResourceCertificatesDissociate, req)
 	if err != nil {
 		return err
@@ -1102,13 +1313,13 @@ func (c *Connector) associate(certDN, applicationDN string, pushToNew bool) erro
 		[]string{applicationDN},
 		pushToNew,
 	}
-	log.Println("Associating device", applicationDN)
+	c.log().Info("Associating device", "dn", certDN, "device", applicationDN)
 	statusCode, status, body, err := c.request("POST", urlResourceCertificatesAssociate, req)
 	if err != nil {
 		return err
 	}
 	if statusCode != 200 {
-		log.Printf("We have problem with server response.\n  status: %s\n  body: %s\n", status, body)
+		c.log().Error("Failed to associate device", "dn", certDN, "device", applicationDN, "status", status, "body", string(body))
 		return verror.ServerBadDataResponce
 	}
 	return nil
@@ -1130,7 +1341,7 @@ func (c *Connector) configDNToGuid(objectDN string) (guid string, err error) {
 		Result           int    `json:",omitempty"`
 	}
 
-	log.Println("Getting guid for object DN", objectDN)
+	c.log().Debug("Getting guid for object", "dn", objectDN)
 	statusCode, status, body, err := c.request("POST", urlResourceConfigDnToGuid, req)
 
 	if err != nil {
@@ -1151,7 +1362,7 @@ func (c *Connector) configDNToGuid(objectDN string) (guid string, err error) {
 	}
 
 	if resp.Result == 400 {
-		log.Printf("object with DN %s doesn't exist", objectDN)
+		c.log().Debug("Object doesn't exist", "dn", objectDN)
 		return "", nil
 	}
 
@@ -1,3 +1,6 @@
+//go:build integration
+// +build integration
+
 /*
  * Copyright 2018 Venafi, Inc.
  *
@@ -19,14 +22,12 @@ package tpp
 import (
 	"crypto/ecdsa"
 	"crypto/rsa"
-	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"net/http"
 	"net/url"
 	"os"
 	"reflect"
@@ -44,9 +45,6 @@ var ctx *test.Context
 
 func init() {
 	ctx = test.GetEnvContext()
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{
-		Renegotiation:      tls.RenegotiateFreelyAsClient,
-		InsecureSkipVerify: true}
 
 	if ctx.TPPurl == "" {
 		fmt.Println("TPP URL cannot be empty. See Makefile")
@@ -71,7 +69,11 @@ func init() {
 
 func getTestConnector(url string, zone string) (c *Connector, err error) {
 	c, err = NewConnector(url, zone, false, nil)
-	return c, err
+	if err != nil {
+		return nil, err
+	}
+	c.SetTransportConfig(endpoint.TransportConfig{InsecureSkipVerify: true, Renegotiation: true})
+	return c, nil
 }
 
 func TestNewConnectorURLSuccess(t *testing.T) {
@@ -1407,7 +1409,7 @@ func TestEnrollWithLocation(t *testing.T) {
 		t.Fatalf("err is not nil, err: %s url: %s", err, expectedURL)
 	}
 
-	tpp.verbose = true
+	tpp.SetLogger(endpoint.NewStdLogger(nil, true))
 
 	if tpp.apiKey == "" {
 		err = tpp.Authenticate(&endpoint.Authentication{AccessToken: ctx.TPPaccessToken})
@@ -19,12 +19,25 @@ package tpp
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+
+	"github.com/Venafi/vcert/v4/pkg/verror"
 )
 
+// responseError is the body TPP returns along with an unexpected status code
 type responseError struct {
 	ErrorDetails string `json:"ErrorDetails,omitempty"`
+	Message      string `json:"Error,omitempty"`
 }
 
+func (e *responseError) message() string {
+	if e.ErrorDetails != "" {
+		return strings.TrimSpace(e.ErrorDetails)
+	}
+	return strings.TrimSpace(e.Message)
+}
+
+// NewResponseError returns a *verror.ResponseError with the error message of the TPP response body b
 func NewResponseError(b []byte) error {
 	if len(b) == 0 {
 		return fmt.Errorf("failed to parser empty error message")
@@ -34,9 +47,18 @@ func NewResponseError(b []byte) error {
 	if err != nil {
 		return fmt.Errorf("failed to parser server error: %s", err)
 	}
-	return data
+	return &verror.ResponseError{Message: data.message()}
 }
 
-func (e *responseError) Error() string {
-	return e.ErrorDetails
+// newResponseError returns the error for an unexpected response of endpoint. The message is taken from the
+// JSON body, or is the body itself if TPP did not return JSON.
+func newResponseError(endpoint string, statusCode int, correlationID string, body []byte) *verror.ResponseError {
+	respErr := &verror.ResponseError{StatusCode: statusCode, Endpoint: endpoint, RequestID: correlationID}
+	var data responseError
+	if err := json.Unmarshal(body, &data); err == nil {
+		respErr.Message = data.message()
+	} else {
+		respErr.Message = strings.TrimSpace(string(body))
+	}
+	return respErr
 }
@@ -17,8 +17,12 @@
 package tpp
 
 import (
+	"errors"
+	"net/http"
 	"strings"
 	"testing"
+
+	"github.com/Venafi/vcert/v4/pkg/verror"
 )
 
 func TestNewResponseError(t *testing.T) {
@@ -39,3 +43,21 @@ func TestNewResponseError(t *testing.T) {
 		t.Fatal("failed to parse error message")
 	}
 }
+
+func TestNewResponseErrorDetails(t *testing.T) {
+	err := newResponseError("TPP Certificate Request", http.StatusBadRequest, "req-1", []byte(`{"Error":"Unable to find the policy folder"}`))
+	if !errors.Is(err, verror.ServerBadDataResponce) || !errors.Is(err, verror.ServerError) {
+		t.Fatalf("a 400 response should match ServerBadDataResponce and ServerError: %s", err)
+	}
+	if err.Message != "Unable to find the policy folder" || err.RequestID != "req-1" {
+		t.Fatalf("unexpected error details %+v", err)
+	}
+	if !strings.Contains(err.Error(), "Status: 400 Bad Request") || !strings.Contains(err.Error(), "Request ID: req-1") {
+		t.Fatalf("unexpected error text %q", err)
+	}
+
+	err = newResponseError("TPP Certificate Retrieval", http.StatusServiceUnavailable, "", []byte("<html>Service Unavailable</html>"))
+	if !errors.Is(err, verror.ServerUnavailableError) || err.Message != "<html>Service Unavailable</html>" {
+		t.Fatalf("a 503 response should match ServerUnavailableError and keep the body: %+v", err)
+	}
+}
@@ -71,7 +71,7 @@ func (c *Connector) searchCertificatesByFingerprint(fp string) (*CertificateSear
 
 func (c *Connector) configReadDN(req ConfigReadDNRequest) (resp ConfigReadDNResponse, err error) {
 
-	statusCode, status, body, err := c.request("POST", urlResourceConfigReadDn, req)
+	statusCode, _, body, correlationID, err := c.requestWithCorrelationID("POST", urlResourceConfigReadDn, req)
 	if err != nil {
 		return resp, err
 	}
@@ -82,7 +82,7 @@ func (c *Connector) configReadDN(req ConfigReadDNRequest) (resp ConfigReadDNResp
 			return resp, err
 		}
 	} else {
-		return resp, fmt.Errorf("unexpected status code on %s. Status: %s", urlResourceConfigReadDn, status)
+		return resp, newResponseError(string(urlResourceConfigReadDn), statusCode, correlationID, body)
 	}
 
 	return resp, nil
@@ -125,11 +125,7 @@ func parseCertificateDetailsResponse(statusCode int, body []byte) (searchResult
 		}
 		return searchResult, nil
 	default:
-		if body != nil {
-			return nil, NewResponseError(body)
-		} else {
-			return nil, fmt.Errorf("Unexpected status code on certificate search. Status: %d", statusCode)
-		}
+		return nil, newResponseError("TPP certificate details", statusCode, "", body)
 	}
 }
 
@@ -143,11 +139,7 @@ func ParseCertificateSearchResponse(httpStatusCode int, body []byte) (searchResu
 		}
 		return searchResult, nil
 	default:
-		if body != nil {
-			return nil, NewResponseError(body)
-		} else {
-			return nil, fmt.Errorf("Unexpected status code on certificate search. Status: %d", httpStatusCode)
-		}
+		return nil, newResponseError("TPP certificate search", httpStatusCode, "", body)
 	}
 }
 
@@ -18,15 +18,12 @@ package tpp
 
 import (
 	"bytes"
-	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
-	"net"
 	"net/http"
 	"regexp"
 	"strings"
@@ -34,6 +31,7 @@ import (
 
 	"github.com/Venafi/vcert/v4/pkg/certificate"
 	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/Venafi/vcert/v4/pkg/verror"
 )
 
 const defaultKeySize = 2048
@@ -360,6 +358,43 @@ func retrieveChainOptionFromString(order string) retrieveChainOption {
 }
 
 func (c *Connector) request(method string, resource urlResource, data interface{}) (statusCode int, statusText string, body []byte, err error) {
+	statusCode, statusText, body, _, err = c.requestWithCorrelationID(method, resource, data)
+	return
+}
+
+// requestWithCorrelationID is request that also returns the correlation ID of the response, for the errors built from it.
+// It refreshes the access token when it is about to expire, and renews the credentials when they are rejected
+// with 401 Unauthorized.
+func (c *Connector) requestWithCorrelationID(method string, resource urlResource, data interface{}) (statusCode int, statusText string, body []byte, correlationID string, err error) {
+	// the authorization resources manage the credentials themselves
+	if resource == urlResourceAuthorize || strings.HasPrefix(string(resource), "vedauth/") {
+		return c.doRequest(method, resource, data, c.getRequestAuth())
+	}
+
+	auth := c.getRequestAuth()
+	if c.accessTokenExpiring() {
+		if _, err = c.renewCredentials(auth); err != nil {
+			return
+		}
+		auth = c.getRequestAuth()
+	}
+	statusCode, statusText, body, correlationID, err = c.doRequest(method, resource, data, auth)
+	if err != nil || statusCode != http.StatusUnauthorized || auth == (requestAuth{}) {
+		return
+	}
+
+	renewed, renewErr := c.renewCredentials(auth)
+	if renewErr != nil {
+		err = renewErr
+		return
+	}
+	if !renewed {
+		return
+	}
+	return c.doRequest(method, resource, data, c.getRequestAuth())
+}
+
+func (c *Connector) doRequest(method string, resource urlResource, data interface{}, auth requestAuth) (statusCode int, statusText string, body []byte, correlationID string, err error) {
 	url := c.baseURL + string(resource)
 	var payload io.Reader
 	var b []byte
@@ -370,40 +405,33 @@ func (c *Connector) request(method string, resource urlResource, data interface{
 
 	r, _ := http.NewRequest(method, url, payload)
 	r.Close = true
-	if c.accessToken != "" {
-		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.accessToken))
-	} else if c.apiKey != "" {
-		r.Header.Add("x-venafi-api-key", c.apiKey)
+	if auth.accessToken != "" {
+		r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", auth.accessToken))
+	} else if auth.apiKey != "" {
+		r.Header.Add("x-venafi-api-key", auth.apiKey)
 	}
 	r.Header.Add("content-type", "application/json")
 	r.Header.Add("cache-control", "no-cache")
 
+	start := time.Now()
+	if c.hooks != nil {
+		end := c.hooks.StartHTTPRequest(endpoint.HTTPRequestInfo{ConnectorType: endpoint.ConnectorTypeTPP, Zone: c.zone, Method: method, URL: url})
+		defer func() { end(endpoint.Outcome{Duration: time.Since(start), StatusCode: statusCode, Err: err}) }()
+	}
 	res, err := c.getHTTPClient().Do(r)
 	if res != nil {
 		statusCode = res.StatusCode
 		statusText = res.Status
+		correlationID = res.Header.Get(verror.RequestIDHeader)
 	}
 	if err != nil {
+		c.log().Debug("Request failed", "method", method, "url", url, "duration", time.Since(start), "error", err)
 		return
 	}
 
 	defer res.Body.Close()
 	body, err = ioutil.ReadAll(res.Body)
-	// Do not enable trace in production
-	trace := false // IMPORTANT: sensitive information can be diclosured
-	// I hope you know what are you doing
-	if trace {
-		log.Println("#################")
-		log.Printf("Headers are:\n%s", r.Header)
-		if method == "POST" || method == "PUT" {
-			log.Printf("JSON sent for %s\n%s\n", url, string(b))
-		} else {
-			log.Printf("%s request sent to %s\n", method, url)
-		}
-		log.Printf("Response:\n%s\n", string(body))
-	} else if c.verbose {
-		log.Printf("Got %s status for %s %s\n", statusText, method, url)
-	}
+	c.log().Debug("Request completed", "method", method, "url", url, "status", statusText, "duration", time.Since(start))
 	return
 }
 
@@ -411,33 +439,7 @@ func (c *Connector) getHTTPClient() *http.Client {
 	if c.client != nil {
 		return c.client
 	}
-	var netTransport = &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-	}
-	tlsConfig := http.DefaultTransport.(*http.Transport).TLSClientConfig
-	/* #nosec */
-	if c.trust != nil {
-		if tlsConfig == nil {
-			tlsConfig = &tls.Config{}
-		} else {
-			tlsConfig = tlsConfig.Clone()
-		}
-		tlsConfig.RootCAs = c.trust
-	}
-	netTransport.TLSClientConfig = tlsConfig
-	c.client = &http.Client{
-		Timeout:   time.Second * 30,
-		Transport: netTransport,
-	}
+	c.client = endpoint.NewHTTPClient(c.transport)
 	return c.client
 }
 
@@ -516,7 +518,7 @@ func stripBackSlashes(s string) string {
 	return string(result)
 }
 
-func parseConfigResult(httpStatusCode int, httpStatus string, body []byte) (tppData tppPolicyData, err error) {
+func parseConfigResult(httpStatusCode int, correlationID string, body []byte) (tppData tppPolicyData, err error) {
 	tppData = tppPolicyData{}
 	switch httpStatusCode {
 	case http.StatusOK:
@@ -526,7 +528,7 @@ func parseConfigResult(httpStatusCode int, httpStatus string, body []byte) (tppD
 		}
 		return tppData, nil
 	default:
-		return tppData, fmt.Errorf("Unexpected status code on TPP Config Operation. Status: %s", httpStatus)
+		return tppData, newResponseError("TPP Config Operation", httpStatusCode, correlationID, body)
 	}
 }
 
@@ -535,7 +537,7 @@ func parseConfigData(b []byte) (data tppPolicyData, err error) {
 	return
 }
 
-func parseRequestResult(httpStatusCode int, httpStatus string, body []byte) (string, error) {
+func parseRequestResult(httpStatusCode int, correlationID string, body []byte) (string, error) {
 	switch httpStatusCode {
 	case http.StatusOK, http.StatusCreated:
 		reqData, err := parseRequestData(body)
@@ -544,7 +546,7 @@ func parseRequestResult(httpStatusCode int, httpStatus string, body []byte) (str
 		}
 		return reqData.CertificateDN, nil
 	default:
-		return "", fmt.Errorf("Unexpected status code on TPP Certificate Request.\n Status:\n %s. \n Body:\n %s\n", httpStatus, body)
+		return "", newResponseError("TPP Certificate Request", httpStatusCode, correlationID, body)
 	}
 }
 
@@ -553,7 +555,7 @@ func parseRequestData(b []byte) (data certificateRequestResponse, err error) {
 	return
 }
 
-func parseRetrieveResult(httpStatusCode int, httpStatus string, body []byte) (certificateRetrieveResponse, error) {
+func parseRetrieveResult(httpStatusCode int, correlationID string, body []byte) (certificateRetrieveResponse, error) {
 	var retrieveResponse certificateRetrieveResponse
 	switch httpStatusCode {
 	case http.StatusOK, http.StatusAccepted:
@@ -563,7 +565,7 @@ func parseRetrieveResult(httpStatusCode int, httpStatus string, body []byte) (ce
 		}
 		return retrieveResponse, nil
 	default:
-		return retrieveResponse, fmt.Errorf("Unexpected status code on TPP Certificate Retrieval. Status: %s", httpStatus)
+		return retrieveResponse, newResponseError("TPP Certificate Retrieval", httpStatusCode, correlationID, body)
 	}
 }
 
@@ -572,7 +574,7 @@ func parseRetrieveData(b []byte) (data certificateRetrieveResponse, err error) {
 	return
 }
 
-func parseRevokeResult(httpStatusCode int, httpStatus string, body []byte) (certificateRevokeResponse, error) {
+func parseRevokeResult(httpStatusCode int, correlationID string, body []byte) (certificateRevokeResponse, error) {
 	var revokeResponse certificateRevokeResponse
 	switch httpStatusCode {
 	case http.StatusOK, http.StatusAccepted:
@@ -582,7 +584,7 @@ func parseRevokeResult(httpStatusCode int, httpStatus string, body []byte) (cert
 		}
 		return revokeResponse, nil
 	default:
-		return revokeResponse, fmt.Errorf("Unexpected status code on TPP Certificate Revocation. Status: %s", httpStatus)
+		return revokeResponse, newResponseError("TPP Certificate Revocation", httpStatusCode, correlationID, body)
 	}
 }
 
@@ -591,10 +593,10 @@ func parseRevokeData(b []byte) (data certificateRevokeResponse, err error) {
 	return
 }
 
-func parseRenewResult(httpStatusCode int, httpStatus string, body []byte) (resp certificateRenewResponse, err error) {
+func parseRenewResult(httpStatusCode int, correlationID string, body []byte) (resp certificateRenewResponse, err error) {
 	resp, err = parseRenewData(body)
 	if err != nil {
-		return resp, fmt.Errorf("failed to parse certificate renewal response. status: %s", httpStatus)
+		return resp, newResponseError("TPP Certificate Renewal", httpStatusCode, correlationID, body)
 	}
 	return resp, nil
 }
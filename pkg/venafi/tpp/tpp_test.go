@@ -39,7 +39,7 @@ func TestParseConfigFindPolicyData(t *testing.T) {
 		t.Fatalf("Values count was not expected count of 2 actual count is %d", len(tppData.Values))
 	}
 
-	tppData, err = parseConfigResult(http.StatusBadRequest, "Bad Request", data)
+	tppData, err = parseConfigResult(http.StatusBadRequest, "", data)
 	if err == nil {
 		t.Fatalf("err is nil when expected to not be")
 	}
@@ -67,7 +67,7 @@ func TestParseCertificateRequestData(t *testing.T) {
 		t.Fatalf("Parse Certificate retrieve response did not include expected CertificateDN: \\VED\\Policy\\Web SDK Testing\\bonjoTest 33 -- Actual: %s", requestDN)
 	}
 
-	requestDN, err = parseRequestResult(http.StatusBadRequest, "Bad Request", data)
+	requestDN, err = parseRequestResult(http.StatusBadRequest, "", data)
 	if err == nil {
 		t.Fatalf("err is nil when expected to not be")
 	}
@@ -95,7 +95,7 @@ func TestParseCertificateRetrieveData(t *testing.T) {
 		t.Fatalf("Parse Certificate retrieve response did not include expected filename: test.bonjo.com.cer -- Actual: %s", resp.Filename)
 	}
 
-	resp, err = parseRetrieveResult(http.StatusBadRequest, "Bad Request", data)
+	resp, err = parseRetrieveResult(http.StatusBadRequest, "", data)
 	if err == nil {
 		t.Fatalf("err is nil when expected to not be")
 	}
@@ -0,0 +1,50 @@
+package verror
+
+import (
+	"errors"
+	"net"
+)
+
+// Categories returned by Category. The vcert CLI reports them with the matching exit codes.
+const (
+	CategoryPending           = "pending"
+	CategoryTimeout           = "timeout"
+	CategoryAuth              = "auth"
+	CategoryNotFound          = "not_found"
+	CategoryPolicy            = "policy"
+	CategoryUserData          = "user_data"
+	CategoryServerUnavailable = "server_unavailable"
+	CategoryServer            = "server"
+	CategoryNetwork           = "network"
+	CategoryGeneral           = "general"
+)
+
+// Category returns a short name of the kind of err, suitable as a metric label, or "" if err is nil. The most
+// specific sentinel err matches decides, e.g. an AuthError is "auth" and not "user_data".
+func Category(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, CertificatePendingError):
+		return CategoryPending
+	case errors.Is(err, RetrieveCertificateTimeoutError):
+		return CategoryTimeout
+	case errors.Is(err, AuthError):
+		return CategoryAuth
+	case errors.Is(err, ZoneNotFoundError), errors.Is(err, ApplicationNotFoundError):
+		return CategoryNotFound
+	case errors.Is(err, PolicyValidationError):
+		return CategoryPolicy
+	case errors.Is(err, UserDataError):
+		return CategoryUserData
+	case errors.Is(err, ServerUnavailableError):
+		return CategoryServerUnavailable
+	case errors.Is(err, ServerError):
+		return CategoryServer
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return CategoryNetwork
+	}
+	return CategoryGeneral
+}
@@ -0,0 +1,36 @@
+package verror
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestCategory(t *testing.T) {
+	cases := []struct {
+		err      error
+		category string
+	}{
+		{nil, ""},
+		{fmt.Errorf("%w: failed to refresh access token", AuthError), CategoryAuth},
+		{NoCredentialsError, CategoryAuth},
+		{&ResponseError{Kind: ZoneNotFoundError, StatusCode: http.StatusNotFound}, CategoryNotFound},
+		{ApplicationNotFoundError, CategoryNotFound},
+		{fmt.Errorf("%w: key size 1024 is not allowed", PolicyValidationError), CategoryPolicy},
+		{CertificateCheckError, CategoryUserData},
+		{&ResponseError{StatusCode: http.StatusServiceUnavailable}, CategoryServerUnavailable},
+		{&ResponseError{StatusCode: http.StatusInternalServerError}, CategoryServer},
+		{CertificatePendingError, CategoryPending},
+		{RetrieveCertificateTimeoutError, CategoryTimeout},
+		{&url.Error{Op: "Post", URL: "https://tpp.example.com", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, CategoryNetwork},
+		{errors.New("unexpected"), CategoryGeneral},
+	}
+	for _, c := range cases {
+		if category := Category(c.err); category != c.category {
+			t.Fatalf("expected %v to be categorized as %q, got %q", c.err, c.category, category)
+		}
+	}
+}
@@ -12,6 +12,10 @@ var (
 	PolicyValidationError           = fmt.Errorf("%w: policy doesn't match request", VcertError)
 	CertificateCheckError           = fmt.Errorf("%w: request doesn't match certificate", UserDataError)
 	AuthError                       = fmt.Errorf("%w: auth error", UserDataError)
+	NoCredentialsError              = fmt.Errorf("%w: no credentials found", AuthError)
 	ZoneNotFoundError               = fmt.Errorf("%w: zone not found", UserDataError)
 	ApplicationNotFoundError        = fmt.Errorf("%w: application not found", UserDataError)
+	CertificatePendingError         = fmt.Errorf("%w: certificate issuance is pending", VcertError)
+	RetrieveCertificateTimeoutError = fmt.Errorf("%w: timed out waiting for the certificate", VcertError)
+	CertificateRejectedError        = fmt.Errorf("%w: certificate request was rejected", VcertError)
 )
@@ -0,0 +1,71 @@
+package verror
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RequestIDHeader is the response header the connectors read the RequestID of a ResponseError from
+const RequestIDHeader = "X-Request-Id"
+
+// ResponseError is an unexpected response of a Venafi server. It matches Kind with errors.Is, or the sentinel of
+// its status code if Kind is nil, so callers that only check for ServerError keep working.
+type ResponseError struct {
+	// Kind is the sentinel error the response stands for, such as ZoneNotFoundError
+	Kind error
+	// StatusCode is the HTTP status code of the response, or 0 if it is not known
+	StatusCode int
+	// Endpoint names the operation that failed, e.g. "TPP Certificate Request"
+	Endpoint string
+	// ServerCodes are the error codes in the response body, as returned by Venafi Cloud
+	ServerCodes []int
+	// Message is the error message in the response body
+	Message string
+	// RequestID is the correlation ID of the request, if the server returned one
+	RequestID string
+}
+
+func (e *ResponseError) kind() error {
+	if e.Kind != nil {
+		return e.Kind
+	}
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return ServerBadDataResponce
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return AuthError
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return ServerTemporaryUnavailableError
+	}
+	return ServerError
+}
+
+func (e *ResponseError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.kind().Error())
+	if e.Endpoint != "" {
+		fmt.Fprintf(&b, ": unexpected status code on %s", e.Endpoint)
+	}
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&b, ". Status: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	if len(e.ServerCodes) > 0 {
+		codes := make([]string, len(e.ServerCodes))
+		for i, c := range e.ServerCodes {
+			codes[i] = fmt.Sprint(c)
+		}
+		fmt.Fprintf(&b, ". Error Code: %s", strings.Join(codes, ", "))
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&b, ". Error: %s", e.Message)
+	}
+	if e.RequestID != "" {
+		fmt.Fprintf(&b, ". Request ID: %s", e.RequestID)
+	}
+	return b.String()
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.kind()
+}
@@ -0,0 +1,72 @@
+package verror
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestResponseErrorIs(t *testing.T) {
+	cases := []struct {
+		err     *ResponseError
+		matches []error
+		other   error
+	}{
+		{&ResponseError{StatusCode: http.StatusInternalServerError}, []error{ServerError, VcertError}, ServerUnavailableError},
+		{&ResponseError{StatusCode: http.StatusBadRequest}, []error{ServerBadDataResponce, ServerError}, UserDataError},
+		{&ResponseError{StatusCode: http.StatusGatewayTimeout}, []error{ServerTemporaryUnavailableError, ServerUnavailableError, ServerError}, ServerBadDataResponce},
+		{&ResponseError{Kind: ZoneNotFoundError, StatusCode: http.StatusNotFound}, []error{ZoneNotFoundError, UserDataError}, ServerError},
+	}
+	for _, c := range cases {
+		for _, target := range c.matches {
+			if !errors.Is(c.err, target) {
+				t.Errorf("%q should match %q", c.err, target)
+			}
+		}
+		if errors.Is(c.err, c.other) {
+			t.Errorf("%q should not match %q", c.err, c.other)
+		}
+	}
+}
+
+func TestResponseErrorStatusCode(t *testing.T) {
+	cases := []struct {
+		statusCode int
+		kind       error
+		category   string
+	}{
+		{http.StatusBadRequest, ServerBadDataResponce, CategoryServer},
+		{http.StatusUnauthorized, AuthError, CategoryAuth},
+		{http.StatusForbidden, AuthError, CategoryAuth},
+		{http.StatusNotFound, ServerError, CategoryServer},
+		{http.StatusInternalServerError, ServerError, CategoryServer},
+		{http.StatusBadGateway, ServerTemporaryUnavailableError, CategoryServerUnavailable},
+		{http.StatusServiceUnavailable, ServerTemporaryUnavailableError, CategoryServerUnavailable},
+		{http.StatusGatewayTimeout, ServerTemporaryUnavailableError, CategoryServerUnavailable},
+	}
+	for _, c := range cases {
+		err := &ResponseError{StatusCode: c.statusCode}
+		if !errors.Is(err, c.kind) {
+			t.Errorf("status %d should match %q, got %q", c.statusCode, c.kind, err)
+		}
+		if category := Category(err); category != c.category {
+			t.Errorf("status %d should have the category %q, got %q", c.statusCode, c.category, category)
+		}
+	}
+}
+
+func TestResponseErrorText(t *testing.T) {
+	err := &ResponseError{
+		StatusCode:  http.StatusBadRequest,
+		Endpoint:    "Venafi Cloud certificate request",
+		ServerCodes: []int{10726},
+		Message:     "Distinguished name component CN is invalid",
+		RequestID:   "4b1f",
+	}
+	expected := "vcert error: server error: server returns 400 code. your request has problems: " +
+		"unexpected status code on Venafi Cloud certificate request. Status: 400 Bad Request. " +
+		"Error Code: 10726. Error: Distinguished name component CN is invalid. Request ID: 4b1f"
+	if err.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, err.Error())
+	}
+}